@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/mailer"
+	"github.com/muhamash/go-first-rest-api/internal/session"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// accountExport is everything the API stores about a user
+type accountExport struct {
+	ExportedAt  time.Time              `json:"exportedAt"`
+	Profile     profileResponse        `json:"profile"`
+	Events      []*database.Event      `json:"events"`
+	Attendances []*database.Attendee   `json:"attendances"`
+	Sessions    []*session.Session     `json:"sessions"`
+	APIKeys     []*database.APIKey     `json:"apiKeys"`
+	Identities  []*database.Identity   `json:"identities"`
+	AuditLog    []*database.AuditEntry `json:"auditLog"`
+}
+
+type deleteAccountRequest struct {
+	Password string `json:"password" binding:"required"`
+}
+
+func (h *AuthHandler) buildExport(user *database.User) (*accountExport, error) {
+	export := &accountExport{ExportedAt: time.Now().UTC(), Profile: h.profile(user)}
+
+	var err error
+	if export.Events, err = h.Models.Events.GetByOwner(user.ID); err != nil {
+		return nil, err
+	}
+	if export.Attendances, err = h.Models.Attendees.GetByUser(user.ID); err != nil {
+		return nil, err
+	}
+	if export.Sessions, err = h.Sessions.List(redisclient.Ctx, user.ID); err != nil {
+		return nil, err
+	}
+	if export.APIKeys, err = h.Models.APIKeys.ListForUser(user.ID); err != nil {
+		return nil, err
+	}
+	if export.Identities, err = h.Models.Identities.ListForUser(user.ID); err != nil {
+		return nil, err
+	}
+	if export.AuditLog, err = h.Models.Audit.ListForUser(user.ID); err != nil {
+		return nil, err
+	}
+
+	return export, nil
+}
+
+// ExportMe returns a copy of the signed-in user's personal data
+//
+//	@Summary		Exports the current user's data
+//	@Description	Returns the profile, owned events, registrations, sessions, API keys, linked identities and audit entries. format=zip (default) returns one JSON file per section; format=json returns a single document.
+//	@Tags			me
+//	@Produce		application/zip
+//	@Produce		json
+//	@Param			format	query	string	false	"zip or json"
+//	@Success		200
+//	@Router			/api/v1/me/export [get]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) ExportMe(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	format := c.DefaultQuery("format", "zip")
+	if format != "zip" && format != "json" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be zip or json"})
+		return
+	}
+
+	export, err := h.buildExport(user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export account data", "detail": err.Error()})
+		return
+	}
+
+	filename := fmt.Sprintf("account-%d-%s", user.ID, export.ExportedAt.Format("20060102"))
+	c.Header("Cache-Control", "no-store")
+
+	if format == "json" {
+		c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.json"`, filename))
+		c.JSON(http.StatusOK, export)
+		return
+	}
+
+	sections := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", export.Profile},
+		{"events.json", export.Events},
+		{"attendances.json", export.Attendances},
+		{"sessions.json", export.Sessions},
+		{"api_keys.json", export.APIKeys},
+		{"identities.json", export.Identities},
+		{"audit_log.json", export.AuditLog},
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.zip"`, filename))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+
+	archive := zip.NewWriter(c.Writer)
+	for _, section := range sections {
+		file, err := archive.CreateHeader(&zip.FileHeader{Name: section.name, Method: zip.Deflate, Modified: export.ExportedAt})
+		if err != nil {
+			log.Printf("failed to write export for user %d: %v", user.ID, err)
+			return
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(section.data); err != nil {
+			log.Printf("failed to write export for user %d: %v", user.ID, err)
+			return
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("failed to write export for user %d: %v", user.ID, err)
+	}
+}
+
+// DeleteMe schedules the signed-in user's account for deletion
+//
+//	@Summary		Deletes the current user
+//	@Description	Signs out every session and deletes the account once the grace period ends. Events the user owns are deleted with it. Signing in and calling POST /me/restore before then cancels the deletion.
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		deleteAccountRequest	true	"Current password"
+//	@Success		202		{object}	gin.H
+//	@Router			/api/v1/me [delete]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) DeleteMe(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	var req deleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.Password)); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Password is incorrect"})
+		return
+	}
+
+	if user.DeletionScheduledAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account deletion is already scheduled", "deletionScheduledAt": user.DeletionScheduledAt})
+		return
+	}
+
+	deleteAt := time.Now().UTC().Add(h.DeletionGrace)
+	if err := h.Models.Users.ScheduleDeletion(user.ID, deleteAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion", "detail": err.Error()})
+		return
+	}
+
+	if err := h.Models.Audit.Record(&database.AuditEntry{
+		Action:       database.AuditDeletionScheduled,
+		ActorId:      &user.ID,
+		TargetUserId: &user.ID,
+		IP:           c.ClientIP(),
+		Detail:       fmt.Sprintf("account will be deleted at %s", deleteAt.Format(time.RFC3339)),
+	}); err != nil {
+		log.Printf("failed to record audit entry: %v", err)
+	}
+
+	// this also ends the session the request came from
+	if err := h.Sessions.RevokeAll(redisclient.Ctx, user.ID); err != nil {
+		log.Printf("failed to sign out user %d after scheduling deletion: %v", user.ID, err)
+	}
+
+	if err := h.Mailer.Send(c.Request.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Your account is scheduled for deletion",
+		Body: fmt.Sprintf("Hi %s,\n\nYour account and the events you own will be deleted on %s. To keep your account, sign in before then and restore it (POST %s/api/v1/me/restore).\n",
+			user.Username, deleteAt.Format("2 January 2006 15:04 MST"), h.APIURL),
+	}); err != nil {
+		log.Printf("failed to send deletion notice to user %d: %v", user.ID, err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"status":              "ok",
+		"message":             "Account scheduled for deletion; to keep it, sign in and call POST /api/v1/me/restore before then",
+		"deletionScheduledAt": deleteAt,
+	})
+}
+
+// RestoreMe cancels a scheduled deletion of the signed-in user's account
+//
+//	@Summary		Cancels account deletion
+//	@Description	Keeps an account that was scheduled for deletion
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/me/restore [post]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) RestoreMe(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	cancelled, err := h.Models.Users.CancelDeletion(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel account deletion", "detail": err.Error()})
+		return
+	}
+	if !cancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account is not scheduled for deletion"})
+		return
+	}
+
+	if err := h.Models.Audit.Record(&database.AuditEntry{
+		Action:       database.AuditDeletionCancelled,
+		ActorId:      &user.ID,
+		TargetUserId: &user.ID,
+		IP:           c.ClientIP(),
+	}); err != nil {
+		log.Printf("failed to record audit entry: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Account deletion cancelled"})
+}
@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/jwtkeys"
+	"github.com/muhamash/go-first-rest-api/internal/lockout"
+	"github.com/muhamash/go-first-rest-api/internal/session"
+)
+
+type AdminHandler struct {
+	Models     database.Models
+	LoginGuard *lockout.Guard
+	Sessions   *session.Store
+	Keys       *jwtkeys.Manager
+}
+
+type suspendUserRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// targetUser loads the user named by the :id path parameter, answering the
+// request itself when it cannot
+func (h *AdminHandler) targetUser(c *gin.Context) (*database.User, bool) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID", "detail": err.Error()})
+		return nil, false
+	}
+
+	user, err := h.Models.Users.Get(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user", "detail": err.Error()})
+		return nil, false
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	return user, true
+}
+
+// lastUserManager refuses, writing the response itself, to take away the last
+// active account that can manage users, which nobody could then undo
+func (h *AdminHandler) lastUserManager(c *gin.Context, user *database.User) bool {
+	if err := h.Models.Roles.LoadForUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user roles", "detail": err.Error()})
+		return true
+	}
+	if !user.HasPermission(database.PermUsersManage) || user.SuspendedAt != nil {
+		return false
+	}
+
+	managers, err := h.Models.Roles.CountActiveUsersWithPermission(database.PermUsersManage)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count user managers", "detail": err.Error()})
+		return true
+	}
+	if managers <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": "This is the last account that can manage users"})
+		return true
+	}
+	return false
+}
+
+// recordAudit writes an admin action on a user to the audit log
+func (h *AdminHandler) recordAudit(c *gin.Context, action string, target *database.User, detail string) {
+	admin := utils.RetrieveUserFromContext(c)
+	if err := h.Models.Audit.Record(&database.AuditEntry{
+		Action:       action,
+		ActorId:      &admin.ID,
+		TargetUserId: &target.ID,
+		IP:           c.ClientIP(),
+		Detail:       detail,
+	}); err != nil {
+		log.Printf("failed to record audit entry: %v", err)
+	}
+}
+
+// ListUsers returns a page of users with their account state
+//
+//	@Summary		Lists users
+//	@Description	Get a page of users; supports limit, offset, cursor, sort, q (searches username, email and display name), username, email, role and status (active, suspended, unverified, pending_deletion)
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/admin/users [get]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) ListUsers(c *gin.Context) {
+	query, err := utils.ParseListQuery(c, database.AdminUserListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		return
+	}
+
+	users, page, err := h.Models.Users.ListForAdmin(query)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"status": "error", "error": "Failed to retrieve users", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"users":  users,
+		"page":   page,
+	})
+}
+
+// GetUser returns one user with their account state
+//
+//	@Summary		Gets a user
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	database.AdminUser
+//	@Router			/api/v1/admin/users/{id} [get]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) GetUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Models.Roles.LoadForUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user roles", "detail": err.Error()})
+		return
+	}
+
+	roles := user.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"user": database.AdminUser{
+			SafeUser:            database.SafeUser{ID: user.ID, Username: user.Username, Email: user.Email},
+			DisplayName:         user.DisplayName,
+			EmailVerifiedAt:     user.EmailVerifiedAt,
+			SuspendedAt:         user.SuspendedAt,
+			SuspensionReason:    user.SuspensionReason,
+			DeletionScheduledAt: user.DeletionScheduledAt,
+			Roles:               roles,
+		},
+	})
+}
+
+// SuspendUser blocks a user from signing in and signs out all their sessions
+//
+//	@Summary		Suspends a user
+//	@Description	The user's sessions end immediately, and neither logins nor their API keys work until the suspension is lifted
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"User ID"
+//	@Param			body	body		suspendUserRequest	false	"Why the user is suspended"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/admin/users/{id}/suspend [post]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) SuspendUser(c *gin.Context) {
+	admin := utils.RetrieveUserFromContext(c)
+
+	var req suspendUserRequest
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot suspend yourself"})
+		return
+	}
+	if h.lastUserManager(c, user) {
+		return
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if err := h.Models.Users.Suspend(user.ID, reason); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to suspend user", "detail": err.Error()})
+		return
+	}
+
+	if err := h.Sessions.RevokeAll(redisclient.Ctx, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "User suspended but failed to sign out their sessions", "detail": err.Error()})
+		return
+	}
+
+	h.recordAudit(c, database.AuditUserSuspended, user, reason)
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "User suspended", "userId": user.ID})
+}
+
+// UnsuspendUser lifts a suspension
+//
+//	@Summary		Unsuspends a user
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/admin/users/{id}/unsuspend [post]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) UnsuspendUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	lifted, err := h.Models.Users.Unsuspend(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unsuspend user", "detail": err.Error()})
+		return
+	}
+	if !lifted {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is not suspended"})
+		return
+	}
+
+	h.recordAudit(c, database.AuditUserUnsuspended, user, "")
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "User unsuspended", "userId": user.ID})
+}
+
+// LogoutUser signs a user out everywhere
+//
+//	@Summary		Signs a user out everywhere
+//	@Description	Revokes every session and the access and refresh tokens issued for them. API keys are not affected.
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/admin/users/{id}/logout [post]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) LogoutUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Sessions.RevokeAll(redisclient.Ctx, user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to sign out user", "detail": err.Error()})
+		return
+	}
+
+	h.recordAudit(c, database.AuditForcedLogout, user, "")
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "User signed out of every session", "userId": user.ID})
+}
+
+// DeleteUser deletes a user right away, without a grace period
+//
+//	@Summary		Deletes a user
+//	@Description	Permanently deletes the user, the events they own and their registrations
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/admin/users/{id} [delete]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) DeleteUser(c *gin.Context) {
+	admin := utils.RetrieveUserFromContext(c)
+
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot delete yourself; use DELETE /api/v1/me"})
+		return
+	}
+	if h.lastUserManager(c, user) {
+		return
+	}
+
+	if err := h.Models.Users.Delete(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete user", "detail": err.Error()})
+		return
+	}
+
+	if err := h.Sessions.RevokeAll(redisclient.Ctx, user.ID); err != nil {
+		log.Printf("failed to revoke sessions of deleted user %d: %v", user.ID, err)
+	}
+
+	// the entry keeps only the id: the account's personal data is gone
+	h.recordAudit(c, database.AuditUserDeleted, user, "")
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "User deleted", "userId": user.ID})
+}
+
+// UnlockUser lifts a login lockout
+//
+//	@Summary		Unlocks an account
+//	@Description	Lifts a lockout caused by repeated failed logins and clears the account's failure history
+//	@Tags			admin
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/admin/users/{id}/unlock [post]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+
+	if err := h.LoginGuard.Unlock(redisclient.Ctx, user.Email); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock account", "detail": err.Error()})
+		return
+	}
+
+	h.recordAudit(c, database.AuditAccountUnlock, user, "")
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ok",
+		"message": "Account unlocked",
+		"userId":  user.ID,
+	})
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+	"github.com/muhamash/go-first-rest-api/internal/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// newAdminRouter serves the admin user routes as caller, without the
+// permission middleware in front
+func newAdminRouter(t *testing.T, models database.Models, caller *database.User) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	h := &AdminHandler{Models: models, Sessions: &session.Store{Redis: rdb}}
+
+	router := gin.New()
+	admin := router.Group("/admin", func(c *gin.Context) { c.Set("user", caller) })
+	admin.DELETE("/users/:id", h.DeleteUser)
+	admin.POST("/users/:id/suspend", h.SuspendUser)
+	return router
+}
+
+func insertUser(t *testing.T, models database.Models, name string, roles ...string) *database.User {
+	t.Helper()
+
+	user := &database.User{Username: name, Email: name + "@example.com", Password: "x"}
+	if err := models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	for _, role := range roles {
+		if err := models.Roles.Grant(user.ID, role); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return user
+}
+
+func TestDeleteUserKeepsEmailOutOfAuditLog(t *testing.T) {
+	models := database.NewModels(dbtest.New(t))
+	admin := insertUser(t, models, "admin", database.RoleAdmin)
+	target := insertUser(t, models, "target", database.RoleMember)
+	router := newAdminRouter(t, models, admin)
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/admin/users/%d", target.ID), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("delete: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	entries, err := models.Audit.ListForUser(target.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) == 0 {
+		t.Fatal("deletion was not audited")
+	}
+	for _, entry := range entries {
+		if strings.Contains(entry.Detail, target.Email) {
+			t.Errorf("%s entry keeps the email: %q", entry.Action, entry.Detail)
+		}
+	}
+}
+
+func TestLastUserManagerIsKept(t *testing.T) {
+	tests := []struct {
+		method, path string
+	}{
+		{http.MethodPost, "/admin/users/%d/suspend"},
+		{http.MethodDelete, "/admin/users/%d"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method, func(t *testing.T) {
+			models := database.NewModels(dbtest.New(t))
+			manager := insertUser(t, models, "manager", database.RoleAdmin)
+			// a caller whose own access is not counted, like an API client of a removed admin
+			router := newAdminRouter(t, models, &database.User{ID: manager.ID + 100})
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, fmt.Sprintf(tt.path, manager.ID), nil))
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("last manager: got %d, want 409: %s", rec.Code, rec.Body.String())
+			}
+
+			other := insertUser(t, models, "other", database.RoleAdmin)
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, fmt.Sprintf(tt.path, other.ID), nil))
+			if rec.Code != http.StatusOK {
+				t.Fatalf("one of two managers: got %d, want 200: %s", rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
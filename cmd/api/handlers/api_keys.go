@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+)
+
+// every key starts with this, so leaked keys are easy to spot in logs and code
+const apiKeyPrefix = "evk_"
+
+type createAPIKeyRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+	// Scopes are permission names, e.g. "events:create"; a key without scopes can only read
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+}
+
+// CreateAPIKey issues a personal API key
+//
+//	@Summary		Creates an API key
+//	@Description	Creates a named API key limited to the given scopes; the key is shown only in this response
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		createAPIKeyRequest	true	"Key"
+//	@Success		201		{object}	gin.H
+//	@Router			/api/v1/auth/api-keys [post]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) CreateAPIKey(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	var req createAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// a key can never do more than its owner
+	scopes := []string{}
+	for _, scope := range req.Scopes {
+		if !user.HasPermission(scope) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot grant a scope you do not hold", "scope": scope})
+			return
+		}
+		if !slices.Contains(scopes, scope) {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expiresAt must be in the future"})
+		return
+	}
+
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate API key"})
+		return
+	}
+	value := apiKeyPrefix + secret
+
+	key := &database.APIKey{
+		UserId:    user.ID,
+		Name:      req.Name,
+		Prefix:    value[:len(apiKeyPrefix)+8],
+		Scopes:    scopes,
+		ExpiresAt: req.ExpiresAt,
+	}
+	if key.ExpiresAt != nil {
+		expiresAt := key.ExpiresAt.UTC()
+		key.ExpiresAt = &expiresAt
+	}
+
+	if err := h.Models.APIKeys.Insert(key, utils.HashToken(value)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create API key", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":  "ok",
+		"message": "Store this key now; it cannot be shown again",
+		"key":     value,
+		"apiKey":  key,
+	})
+}
+
+// GetAPIKeys lists the user's API keys
+//
+//	@Summary		Lists API keys
+//	@Description	Lists the user's API keys that have not been revoked; the keys themselves are never returned
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/auth/api-keys [get]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) GetAPIKeys(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	keys, err := h.Models.APIKeys.ListForUser(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve API keys", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ok",
+		"apiKeys": keys,
+	})
+}
+
+// RevokeAPIKey revokes one of the user's API keys
+//
+//	@Summary		Revokes an API key
+//	@Description	Revokes one of the user's API keys; it stops working immediately
+//	@Tags			auth
+//	@Produce		json
+//	@Param			id	path		int	true	"API key ID"
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/auth/api-keys/{id} [delete]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) RevokeAPIKey(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid API key ID", "detail": err.Error()})
+		return
+	}
+
+	if err := h.Models.APIKeys.Revoke(user.ID, id); err != nil {
+		if errors.Is(err, database.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "API key not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke API key", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "API key revoked"})
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
 
@@ -21,12 +22,13 @@ type AttendeeHandler struct {
 // @Produce		json
 // @Param			id	path		int	true	"Event ID"
 // @Param			userId	path		int	true	"User ID"
+// @Param			occurrence	query		string	false	"Occurrence ID; required for recurring events"
 // @Success		201		{object}	database.Attendee
 // @Router			/api/v1/events/{id}/attendees/{userId} [post]
 // @Security		BearerAuth
 
 func (h *AttendeeHandler) RegisterAttendeeToEvent(c *gin.Context)  {
-	eventId, err := strconv.Atoi(c.Param("eventId"))
+	eventId, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID", "detail": err.Error()})
 		return
@@ -50,6 +52,21 @@ func (h *AttendeeHandler) RegisterAttendeeToEvent(c *gin.Context)  {
 		return
 	}
 
+	contextUser := utils.RetrieveUserFromContext(c)
+	if !event.VisibleTo(contextUser) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if event.Status != database.EventPublished {
+		c.JSON(http.StatusConflict, gin.H{"error": eventClosedMessage(event.Status)})
+		return
+	}
+
+	occurrence, ok := occurrenceParam(c, &h.Models.Events, event)
+	if !ok {
+		return
+	}
+
 	userToAdd, err := h.Models.Users.Get(userId)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user", "detail": err.Error()})
@@ -60,36 +77,53 @@ func (h *AttendeeHandler) RegisterAttendeeToEvent(c *gin.Context)  {
 		return
 	}
 
-	contextUser := utils.RetrieveUserFromContext(c)
-
-	if event.OwnerId != nil && *event.OwnerId == contextUser.ID {
+	if event.OwnerId != nil && *event.OwnerId == contextUser.ID && userId == contextUser.ID {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot register yourself as an attendee for your own event"})
 		return
 	}	
 
-	existingAttendee, err := h.Models.Attendees.GetByEventAndAttendee(eventId, userId)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check existing attendee", "detail": err.Error()})
+	// Only organizers of the event (or admins) may register someone else
+	if userId != contextUser.ID && !(contextUser.HasPermission(database.PermAttendeesManage) && canManageEvent(contextUser, event)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to register other users for this event"})
 		return
 	}
-	if existingAttendee != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "User is already registered for this event"})
+
+	result, err := h.Models.Attendees.Register(eventId, occurrence, userId, userId != contextUser.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrAlreadyRegistered):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "User is already registered for this event"})
+		case errors.Is(err, database.ErrEventNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		case errors.Is(err, database.ErrEventNotOpen):
+			c.JSON(http.StatusConflict, gin.H{"error": "This event is no longer taking registrations"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register attendee", "detail": err.Error()})
+		}
 		return
 	}
 
-	attendee := database.Attendee{
-		EventId: eventId,
-		UserId:  userId,
-	}
-	
-	result, err := h.Models.Attendees.Insert(&attendee)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to register attendee", "detail": err.Error()})
+	if result.RegistrationStatus == database.RegistrationWaitlisted {
+		position, err := h.Models.Attendees.WaitlistPosition(result)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to determine waitlist position", "detail": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"status": "ok",
+			"message": "The event is full; user has been added to the waitlist",
+			"registrationStatus": result.RegistrationStatus,
+			"waitlistPosition": position,
+			"attendee": result,
+		})
 		return
 	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status": "ok",
 		"message": "User registered as an attendee for the event successfully",
+		"registrationStatus": result.RegistrationStatus,
 		"attendee": result,
 	})
 
@@ -103,11 +137,12 @@ func (h *AttendeeHandler) RegisterAttendeeToEvent(c *gin.Context)  {
 //	@Accept			json
 //	@Produce		json
 //	@Param			id	path		int	true	"Event ID"
+//	@Param			occurrence	query		string	false	"Occurrence ID; required for recurring events"
 //	@Success		200	{object}	[]database.User
 //	@Router			/api/v1/events/{id}/attendees [get]
 
 func (h *AttendeeHandler) GetAttendeesForEvent(c *gin.Context) {
-	eventId, err := strconv.Atoi(c.Param("eventId"))
+	eventId, err := eventParam(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID", "detail": err.Error()})
 		return
@@ -119,23 +154,41 @@ func (h *AttendeeHandler) GetAttendeesForEvent(c *gin.Context) {
 		return
 	}
 
-	if event == nil {
-		c.JSON(http.StatusNotFound, gin.H{"Message": "Not found ant event",})
+	// a draft and its attendees are only there for its owner
+	if event == nil || !event.VisibleTo(utils.RetrieveUserFromContext(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	occurrence, ok := occurrenceParam(c, &h.Models.Events, event)
+	if !ok {
 		return
 	}
 
 
-	attendees, err := h.Models.Attendees.GetAttendeesByEvent(eventId)
+	query, err := utils.ParseListQuery(c, database.AttendeeListSpec)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	attendees, page, err := h.Models.Attendees.ListAttendeesByEvent(eventId, occurrence, query)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve attendees", "detail": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status":     "ok",
-		"totalAttendees": len(attendees),
+		"totalAttendees": page.Total,
 		"attendees":  attendees,
+		"page":       page,
 		"eventId": eventId,
+		"occurrence": occurrence,
 		"eventName": event.Name,
 		"eventLocation": event.Location,
 		"ownerId": event.OwnerId,
@@ -150,6 +203,7 @@ func (h *AttendeeHandler) GetAttendeesForEvent(c *gin.Context) {
 //	@Accept			json
 //	@Produce		json
 //	@Param			id	path		int	true	"Attendee ID"
+//	@Param			tz	query		string	false	"IANA time zone to show times in (default: each event's own)"
 //	@Success		200	{object}	[]database.Event
 //	@Router			/api/v1/attendees/{id}/events [get]
 
@@ -160,21 +214,132 @@ func (h *AttendeeHandler) GetEventsByAttendee(c *gin.Context) {
 		return
 	}
 
-	attendee, err := h.Models.Attendees.GetEventsByAttendee(user)
+	query, err := utils.ParseListQuery(c, database.AttendeeEventListSpec)
 	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	zone, ok := displayZone(c)
+	if !ok {
+		return
+	}
+
+	attendee, page, err := h.Models.Attendees.ListEventsByAttendee(user, query)
+	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event", "detail": err.Error()})
 		return
 	}
 
-	if attendee == nil {
+	if page.Total == 0 {
 		c.JSON(http.StatusNotFound, gin.H{"error": "event not found"})
 		return
 	}
+	if zone != nil {
+		for _, event := range attendee {
+			event.ShowIn(zone)
+		}
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"status": "ok",
 		"attendeeId": user,
 		"event": attendee,
+		"page": page,
+	})
+}
+
+type updateAttendeeStatusRequest struct {
+	Status string `json:"status" binding:"required"`
+}
+
+// UpdateAttendeeStatus changes an attendee's RSVP status
+//
+//	@Summary		Changes an attendee's RSVP status
+//	@Description	Moves an attendee through invited, pending, going, maybe, declined and checked_in
+//	@Tags			attendees
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int							true	"Event ID"
+//	@Param			userId	path		int							true	"User ID"
+//	@Param			occurrence	query	string						false	"Occurrence ID; required for recurring events"
+//	@Param			status	body		updateAttendeeStatusRequest	true	"New status"
+//	@Success		200		{object}	database.Attendee
+//	@Router			/api/v1/events/{id}/attendees/{userId} [patch]
+//	@Security		BearerAuth
+
+func (h *AttendeeHandler) UpdateAttendeeStatus(c *gin.Context) {
+	eventId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID", "detail": err.Error()})
+		return
+	}
+
+	userId, err := strconv.Atoi(c.Param("userId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID", "detail": err.Error()})
+		return
+	}
+
+	var req updateAttendeeStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	event, err := h.Models.Events.GET(eventId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event", "detail": err.Error()})
+		return
+	}
+	contextUser := utils.RetrieveUserFromContext(c)
+	if event == nil || !event.VisibleTo(contextUser) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+	if event.Status != database.EventPublished {
+		c.JSON(http.StatusConflict, gin.H{"error": eventClosedMessage(event.Status)})
+		return
+	}
+
+	occurrence, ok := occurrenceParam(c, &h.Models.Events, event)
+	if !ok {
+		return
+	}
+
+	// Attendees answer their own RSVP; checking people in is the organizer's job
+	isOrganizer := contextUser.HasPermission(database.PermAttendeesManage) && canManageEvent(contextUser, event)
+	if !isOrganizer && (userId != contextUser.ID || req.Status == database.StatusCheckedIn) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not allowed to change this attendee's status"})
+		return
+	}
+
+	attendee, promoted, err := h.Models.Attendees.UpdateStatus(eventId, occurrence, userId, req.Status)
+	if err != nil {
+		switch {
+		case errors.Is(err, database.ErrAttendeeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not registered for this event"})
+		case errors.Is(err, database.ErrInvalidStatus):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "allowed": database.AttendeeStatuses})
+		case errors.Is(err, database.ErrInvalidTransition), errors.Is(err, database.ErrAttendeeWaitlisted):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "requested": req.Status})
+		case errors.Is(err, database.ErrEventNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		case errors.Is(err, database.ErrEventNotOpen):
+			c.JSON(http.StatusConflict, gin.H{"error": "This event is no longer taking RSVPs"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update attendee status", "detail": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":               "ok",
+		"attendee":             attendee,
+		"promotedFromWaitlist": promoted,
 	})
 }
 
@@ -186,6 +351,7 @@ func (h *AttendeeHandler) GetEventsByAttendee(c *gin.Context) {
 // @Produce		json
 // @Param			id	path		int	true	"Event ID"
 // @Param			userId	path		int	true	"User ID"
+// @Param			occurrence	query		string	false	"Occurrence ID; required for recurring events"
 // @Success		204
 // @Router			/api/v1/events/{id}/attendees/{userId} [delete]
 // @Security		BearerAuth
@@ -198,7 +364,7 @@ func (h *AttendeeHandler) DeleteAttendeeFromEvent(c *gin.Context) {
 		return
 	}
 
-	eventId, err := strconv.Atoi(c.Param("eventId"))
+	eventId, err := eventParam(c)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user eventId", "detail": err.Error()})
 		return
@@ -215,16 +381,39 @@ func (h *AttendeeHandler) DeleteAttendeeFromEvent(c *gin.Context) {
 		return
 	}
 
-	// user := app.GetUserFromContext(c)
-	// if event.OwnerId != user.Id {
-	// 	c.JSON(http.StatusInternalServerError, gin.H{"error": "You are not authorized to delete an attendeeFromEvent"})
-	// }
+	occurrence, ok := occurrenceParam(c, &h.Models.Events, event)
+	if !ok {
+		return
+	}
 
-	err = h.Models.Attendees.Delete(userId, eventId)
+	// Attendees may leave on their own; otherwise the event organizer (or an admin) must do it
+	contextUser := utils.RetrieveUserFromContext(c)
+	if userId != contextUser.ID && !(contextUser.HasPermission(database.PermAttendeesManage) && canManageEvent(contextUser, event)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not authorized to remove attendees from this event"})
+		return
+	}
+
+	promoted, err := h.Models.Attendees.Delete(userId, eventId, occurrence)
 	if err != nil {
+		if errors.Is(err, database.ErrAttendeeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User is not registered for this event"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete attendee"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"status": "oka"})
-}
\ No newline at end of file
+	c.JSON(http.StatusOK, gin.H{"status": "oka", "promotedFromWaitlist": promoted})
+}
+// eventClosedMessage explains why an event that is not published takes no registrations
+func eventClosedMessage(status string) string {
+	switch status {
+	case database.EventDraft:
+		return "This event has not been published yet"
+	case database.EventCancelled:
+		return "This event has been cancelled"
+	case database.EventCompleted:
+		return "This event is over"
+	}
+	return "This event is not taking registrations"
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+func insertEvent(t *testing.T, models database.Models, owner *database.User, name, status string, start time.Time) *database.Event {
+	t.Helper()
+
+	description, location, zone := "Cake and music", "Hall", "UTC"
+	end := start.Add(4 * time.Hour)
+	event := &database.Event{Name: &name, Description: &description, Location: &location, StartsAt: &start, EndsAt: &end,
+		Timezone: &zone, OwnerId: &owner.ID, Status: status}
+	if err := models.Events.Insert(event); err != nil {
+		t.Fatal(err)
+	}
+	return event
+}
+
+func TestDraftAttendeesAreHidden(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+	stranger := insertUser(t, models, "stranger")
+	draft := insertEvent(t, models, owner, "Party", database.EventDraft, time.Now().Add(24*time.Hour))
+
+	h := &AttendeeHandler{Models: models}
+	tests := []struct {
+		name   string
+		caller *database.User
+		want   int
+	}{
+		{"owner", owner, http.StatusOK},
+		{"someone else", stranger, http.StatusNotFound},
+		{"signed out", nil, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		router := gin.New()
+		router.GET("/events/attendees/:id", func(c *gin.Context) {
+			if tt.caller != nil {
+				c.Set("user", tt.caller)
+			}
+		}, h.GetAttendeesForEvent)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/events/attendees/%d", draft.Id), nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
+
+func TestRSVPOnlyOnPublishedEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+	guest := insertUser(t, models, "guest")
+
+	h := &AttendeeHandler{Models: models}
+	router := gin.New()
+	router.PATCH("/events/:id/attendees/:userId", func(c *gin.Context) { c.Set("user", guest) }, h.UpdateAttendeeStatus)
+
+	rsvp := func(event *database.Event) int {
+		req := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/events/%d/attendees/%d", event.Id, guest.ID), strings.NewReader(`{"status": "maybe"}`))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name   string
+		status string
+		want   int
+	}{
+		{"published", "", http.StatusOK},
+		{"cancelled", database.EventCancelled, http.StatusConflict},
+		{"completed", database.EventCompleted, http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		event := insertEvent(t, models, owner, tt.name, database.EventPublished, time.Now().Add(-time.Hour))
+		if _, err := models.Attendees.Register(event.Id, "", guest.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		if tt.status != "" {
+			if err := models.Events.SetStatus(event, tt.status); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := rsvp(event); got != tt.want {
+			t.Errorf("%s: got %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// a draft is not the guest's to see at all
+	draft := insertEvent(t, models, owner, "draft", database.EventDraft, time.Now().Add(time.Hour))
+	if got := rsvp(draft); got != http.StatusNotFound {
+		t.Errorf("draft: got %d, want %d", got, http.StatusNotFound)
+	}
+}
@@ -1,16 +1,25 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
 	redisclient "github.com/muhamash/go-first-rest-api/internal"
 	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/jwtkeys"
+	"github.com/muhamash/go-first-rest-api/internal/lockout"
+	"github.com/muhamash/go-first-rest-api/internal/mailer"
+	"github.com/muhamash/go-first-rest-api/internal/oidc"
+	"github.com/muhamash/go-first-rest-api/internal/passwordpolicy"
+	"github.com/muhamash/go-first-rest-api/internal/session"
 	"github.com/redis/go-redis/v9"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -23,12 +32,30 @@ type registerRequest struct {
 
 type AuthHandler struct {
 	Models database.Models
-	jwtSecret string
+	Keys      *jwtkeys.Manager
 	Redis     *redis.Client
+	Sessions  *session.Store
+	LoginGuard *lockout.Guard
+	Mailer    mailer.Mailer
+	AppURL    string
+	APIURL    string
+	// MFAIssuer is the account name authenticator apps show next to the codes
+	MFAIssuer string
+	// OIDCProviders are the external identity providers users may sign in with, by name
+	OIDCProviders map[string]*oidc.Provider
+	// DeletionGrace is how long a deleted account can still be restored
+	DeletionGrace time.Duration
+	// PasswordPolicy is what new passwords are checked against
+	PasswordPolicy *passwordpolicy.Policy
+
+	// mail tracks emails still being sent in the background
+	mail sync.WaitGroup
 }
 type loginRequest struct {
 	Password string `json:"password" binding:"required"`
 	Email    string `json:"email" binding:"required"`
+	// Device labels the session, e.g. "Pixel 8"; the User-Agent is used when empty
+	Device string `json:"device"`
 }
 
 type loginResponse struct {
@@ -36,6 +63,7 @@ type loginResponse struct {
 	RefreshToken string `json:"refresh_token"`
 	UserId       int64  `json:"userId"`
 	UserName     string `json:"userName"`
+	EmailVerified bool  `json:"emailVerified"`
 }
 
 // login
@@ -43,7 +71,7 @@ type loginResponse struct {
 // Login logs in a user
 //
 //	@Summary		Logs in a user
-//	@Description	Logs in a user
+//	@Description	Logs in a user; accounts with two-factor authentication get an mfa_required challenge for /auth/mfa/verify instead of tokens
 //	@Tags			auth
 //	@Accept			json
 //	@Produce		json
@@ -59,61 +87,80 @@ func (h *AuthHandler) LoginUser(c *gin.Context) {
 		return
 	}
 
-	existingUser, err := h.Models.Users.GetByEmail(auth.Email)
-	if err != nil || existingUser == nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+	wait, err := h.LoginGuard.RetryAfter(redisclient.Ctx, auth.Email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
 		return
 	}
-
-	err = bcrypt.CompareHashAndPassword([]byte(existingUser.Password), []byte(auth.Password))
-	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+	if wait > 0 {
+		tooManyAttempts(c, wait)
 		return
 	}
 
-	accessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": existingUser.ID,
-		"email":   existingUser.Email,
-		"exp":     time.Now().Add(15 * time.Minute).Unix(),
-	})
-	accessString, err := accessToken.SignedString([]byte(h.jwtSecret))
+	existingUser, err := h.Models.Users.GetByEmail(auth.Email)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user"})
+		return
+	}
+	if existingUser == nil {
+		h.loginFailed(c, auth.Email, nil)
 		return
 	}
 
-	refreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": existingUser.ID,
-		"email":   existingUser.Email,
-		"exp":     time.Now().Add(7 * 24 * time.Hour).Unix(),
-	})
-	refreshString, err := refreshToken.SignedString([]byte(h.jwtSecret))
+	err = bcrypt.CompareHashAndPassword([]byte(existingUser.Password), []byte(auth.Password))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate refresh token"})
+		h.loginFailed(c, auth.Email, existingUser)
 		return
 	}
 
-	// Invalidate previous tokens by overwriting in Redis
-	accessKey := fmt.Sprintf("access_token:user:%d", existingUser.ID)
-	refreshKey := fmt.Sprintf("refresh_token:user:%d", existingUser.ID)
+	// the failures are only forgotten once every factor is through, see respondWithSession
+	h.completeLogin(c, existingUser, auth.Device, false)
+}
 
-	// Save new tokens
-	err = h.Redis.Set(redisclient.Ctx, accessKey, accessString, 15*time.Minute).Err()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store access token"})
+// completeLogin is the end of every way of signing in: once the first factor is
+// satisfied, it either asks for the second factor or starts the session.
+// VerifyMFA comes back through here with mfaVerified set once the code checks out.
+func (h *AuthHandler) completeLogin(c *gin.Context, user *database.User, device string, mfaVerified bool) {
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account is suspended"})
 		return
 	}
-	err = h.Redis.Set(redisclient.Ctx, refreshKey, refreshString, 7*24*time.Hour).Err()
+
+	if !mfaVerified {
+		mfaSecret, err := h.Models.MFA.GetSecret(user.ID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor status"})
+			return
+		}
+		if mfaSecret != "" {
+			h.startMFAChallenge(c, user, device)
+			return
+		}
+	}
+
+	h.respondWithSession(c, user, device)
+}
+
+// respondWithSession starts a session and answers with its tokens. It is the
+// only place failed logins are forgiven, so a correct password alone never
+// resets the count while a second factor is still being guessed.
+func (h *AuthHandler) respondWithSession(c *gin.Context, user *database.User, device string) {
+	accessString, refreshString, err := h.startSession(c, user, device)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start session", "detail": err.Error()})
 		return
 	}
 
+	if err := h.LoginGuard.RecordSuccess(redisclient.Ctx, user.Email); err != nil {
+		log.Printf("failed to reset login failures: %v", err)
+	}
+
 	c.JSON(http.StatusOK, loginResponse{
-		Token:        accessString,
-		RefreshToken: refreshString,
-		UserId:       int64(existingUser.ID),
-		UserName:     existingUser.Username,
+		Token:         accessString,
+		RefreshToken:  refreshString,
+		UserId:        int64(user.ID),
+		UserName:      user.Username,
+		EmailVerified: user.EmailVerifiedAt != nil,
 	})
 }
 
@@ -143,6 +190,10 @@ func (h *AuthHandler) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	if h.rejectWeakPassword(c, "password", req.Password, req.Name, req.Email) {
+		return
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
 	if err != nil {
 		log.Println("Insert error:", err)
@@ -158,7 +209,7 @@ func (h *AuthHandler) RegisterUser(c *gin.Context) {
 		Email:    req.Email,		
 	}
 
-	if err := h.Models.Users.Insert(&user); err != nil {
+	if err := h.Models.Users.Insert(&user, database.RoleMember); err != nil {
 		if err.Error() == "email already registered" {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		} else {
@@ -167,141 +218,151 @@ func (h *AuthHandler) RegisterUser(c *gin.Context) {
 		return
 	}
 
+	// The account exists either way; a failed send can be retried through the resend endpoint
+	if err := h.sendVerificationEmail(&user); err != nil {
+		log.Printf("failed to send verification email to user %d: %v", user.ID, err)
+	}
+
 	c.JSON(http.StatusCreated, gin.H{
-		"message": "User registered successfully",
+		"message": "User registered successfully; check your email to verify your address",
 		"user": gin.H{
 			"id":       user.ID,
 			"username": user.Username,
 			"email":    user.Email,
+			"roles":    []string{database.RoleMember},
+			"emailVerified": false,
 		},
 	})
 	
 }	
 
+// RefreshToken exchanges a refresh token for a new token pair
+//
+//	@Summary		Refreshes the token pair
+//	@Description	Rotates the refresh token; presenting an already-rotated token revokes the whole session
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/auth/refresh [post]
+
 func (h *AuthHandler) RefreshToken(c *gin.Context) {
 	var req struct {
-		RefreshToken string `json:"refresh_token"`
+		RefreshToken string `json:"refresh_token" binding:"required"`
 	}
 	if err := c.ShouldBindJSON(&req); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request"})
 		return
 	}
 
-	token, err := jwt.Parse(req.RefreshToken, func(t *jwt.Token) (interface{}, error) {
-		return []byte(h.jwtSecret), nil
-	})
-
+	token, err := h.Keys.Parse(req.RefreshToken)
 	if err != nil || !token.Valid {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token", "details": err.Error()})
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid refresh token"})
 		return
 	}
 
 	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
+	if !ok || claims["typ"] != tokenTypeRefresh {
 		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid claims"})
 		return
 	}
 
-	userId := int64(claims["user_id"].(float64))
-	email := claims["email"].(string)
-	accessKey := fmt.Sprintf("access_token:user:%d", userId)
-	refreshKey := fmt.Sprintf("refresh_token:user:%d", userId)
+	userIDFloat, _ := claims["user_id"].(float64)
+	sessionID, _ := claims["sid"].(string)
+	jti, _ := claims["jti"].(string)
+	// Tokens issued before sessions existed carry no sid; those users have to log in again
+	if sessionID == "" || jti == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token is no longer supported; please log in again"})
+		return
+	}
 
-	// Check stored refresh token
-	storedToken, err := h.Redis.Get(redisclient.Ctx, refreshKey).Result()
-	if err != nil || storedToken != req.RefreshToken {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token mismatch"})
+	sess, err := h.Sessions.Get(redisclient.Ctx, sessionID)
+	if errors.Is(err, session.ErrNotFound) || (err == nil && sess.UserID != int(userIDFloat)) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has expired or was revoked"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load session", "detail": err.Error()})
 		return
 	}
 
-	// Create new access token
-	newAccessToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userId,
-		"email":   email,
-		"exp":     time.Now().Add(15 * time.Minute).Unix(),
-	})
-	newAccessString, err := newAccessToken.SignedString([]byte(h.jwtSecret))
+	user, err := h.Models.Users.Get(sess.UserID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate access token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user", "detail": err.Error()})
+		return
+	}
+	if user == nil {
+		h.Sessions.Revoke(redisclient.Ctx, sess.UserID, sess.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has expired or was revoked"})
 		return
 	}
 
-	// Create new refresh token
-	newRefreshToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"user_id": userId,
-		"email":   email,
-		"exp":     time.Now().Add(7 * 24 * time.Hour).Unix(),
-	})
-	newRefreshString, err := newRefreshToken.SignedString([]byte(h.jwtSecret))
+	newJTI, err := utils.GenerateToken(16)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate refresh token"})
 		return
 	}
 
-	// Store the new tokens
-	err = h.Redis.Set(redisclient.Ctx, accessKey, newAccessString, 15*time.Minute).Err()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store access token"})
+	if err := h.Sessions.Rotate(redisclient.Ctx, sess, jti, newJTI, c.ClientIP()); err != nil {
+		switch {
+		case errors.Is(err, session.ErrTokenReused):
+			// Someone is replaying an old token: it may have been stolen, so the
+			// session it belongs to is gone for both the thief and the owner
+			log.Printf("refresh token reuse detected for user %d, session %s revoked", sess.UserID, sess.ID)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Refresh token was already used; the session has been revoked"})
+		case errors.Is(err, session.ErrNotFound):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has expired or was revoked"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate refresh token", "detail": err.Error()})
+		}
 		return
 	}
-	err = h.Redis.Set(redisclient.Ctx, refreshKey, newRefreshString, 7*24*time.Hour).Err()
+
+	accessString, refreshString, err := h.issueTokens(user, sess)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store refresh token"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate tokens", "detail": err.Error()})
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{
-		"access_token":         newAccessString,
-		"refresh_token": newRefreshString,
+		"access_token":  accessString,
+		"refresh_token": refreshString,
 	})
 }
 
 // logout
 func (h *AuthHandler) LogoutUser(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
 	userID, err := strconv.Atoi(c.Param("id"))
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
 		return
 	}
+	if userID != user.ID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You can only log yourself out"})
+		return
+	}
 
-	key := fmt.Sprintf("refresh_token:user:%d", userID)
+	// Only the session the access token belongs to; other devices stay signed in
+	sessionID := utils.RetrieveSessionIDFromContext(c)
+	if sessionID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Access token is not bound to a session"})
+		return
+	}
 
-	// Delete refresh token from Redis
-	if err := h.Redis.Del(redisclient.Ctx, key).Err(); err != nil {
+	if err := h.Sessions.Revoke(redisclient.Ctx, user.ID, sessionID); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
-}
-
-
-// get all users
-// @Summary		Get all users
-// @Description	Get all users
-// @Tags			test
-// @Accept			json
-// @Produce		json
-// @Success		200	{object}	gin.H "Successful response"
-// @Router			/api/v1/auth/users [get]
-func (h *AuthHandler) GetAllUsers(c *gin.Context){
-	users, err := h.Models.Users.GetAllUser()
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"status": "error",
-			"error":  "Failed to retrieve users",
-			"detail": err.Error(),
-		})
+	// Revoking the session already stops its access tokens; the denylist entry
+	// covers this token on its own until it expires
+	tokenID, expiresAt := utils.RetrieveTokenFromContext(c)
+	if err := h.Sessions.DenyAccessToken(redisclient.Ctx, tokenID, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to logout"})
 		return
 	}
 
-	fmt.Print(users)
-
-	c.JSON(http.StatusOK, gin.H{
-		"status":       "ok",
-		"totalUsers":  len(users),
-		"users":       users,
-	})
-
+	c.JSON(http.StatusOK, gin.H{"message": "Successfully logged out"})
 }
@@ -0,0 +1,385 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/ical"
+)
+
+const calendarProdID = "-//go-first-rest-api//Events//EN"
+
+// every feed token starts with this, like API keys do
+const calendarFeedPrefix = "cal_"
+
+// calendarFeedURL is the address calendar apps subscribe to
+func calendarFeedURL(apiURL, token string) string {
+	return strings.TrimRight(apiURL, "/") + "/api/v1/calendar/" + token + ".ics"
+}
+
+// eventUID is the iCalendar UID of an event, stable across exports
+func (h *EventHandler) eventUID(eventId int, occurrence string) string {
+	host := "localhost"
+	if parsed, err := url.Parse(h.APIURL); err == nil && parsed.Hostname() != "" {
+		host = parsed.Hostname()
+	}
+	if occurrence != "" {
+		compact := strings.NewReplacer("-", "", ":", "").Replace(occurrence)
+		return fmt.Sprintf("event-%d-%s@%s", eventId, compact, host)
+	}
+	return fmt.Sprintf("event-%d@%s", eventId, host)
+}
+
+func (h *EventHandler) icalEvent(event *database.Event, uid string) *ical.Event {
+	vevent := &ical.Event{
+		UID: uid,
+		URL: strings.TrimRight(h.APIURL, "/") + "/api/v1/events/" + strconv.Itoa(event.Id),
+	}
+	if event.StartsAt != nil {
+		vevent.Start = *event.StartsAt
+	}
+	vevent.End = event.EndsAt
+	if event.Name != nil {
+		vevent.Summary = *event.Name
+	}
+	if event.Description != nil {
+		vevent.Description = *event.Description
+	}
+	if event.Location != nil {
+		vevent.Location = *event.Location
+	}
+	switch event.Status {
+	case database.EventDraft:
+		vevent.Status = "TENTATIVE"
+	case database.EventCancelled:
+		vevent.Status = "CANCELLED"
+	}
+	return vevent
+}
+
+// calendarEvents turns an event into VEVENTs. A recurring event is written as
+// its series plus the occurrences changed within a year either side of now.
+func (h *EventHandler) calendarEvents(event *database.Event) ([]*ical.Event, error) {
+	if event.StartsAt == nil {
+		return []*ical.Event{}, nil
+	}
+
+	series := h.icalEvent(event, h.eventUID(event.Id, ""))
+	if !event.IsRecurring() {
+		return []*ical.Event{series}, nil
+	}
+	series.RRule = *event.RRule
+	series.ExDates = event.ExDates
+
+	now := time.Now().UTC()
+	from := now.Add(-database.MaxOccurrenceWindow)
+	if event.StartsAt.After(from) {
+		from = *event.StartsAt
+	}
+	occurrences, err := h.Models.Events.Occurrences(event, from, now.Add(database.MaxOccurrenceWindow))
+	if err != nil {
+		return nil, err
+	}
+
+	vevents := []*ical.Event{series}
+	for _, occurrence := range occurrences {
+		if !occurrence.Modified {
+			continue
+		}
+		original, err := time.Parse(time.RFC3339, occurrence.OccurrenceId)
+		if err != nil {
+			continue
+		}
+		changed := h.icalEvent(&occurrence.Event, series.UID)
+		changed.RecurrenceID = &original
+		vevents = append(vevents, changed)
+	}
+
+	return vevents, nil
+}
+
+// rsvpStatus maps an attendee's RSVP onto a VEVENT STATUS
+func rsvpStatus(attending *database.AttendeeEvent) string {
+	if attending.RegistrationStatus == database.RegistrationConfirmed &&
+		(attending.Status == database.StatusGoing || attending.Status == database.StatusCheckedIn) {
+		return "CONFIRMED"
+	}
+	return "TENTATIVE"
+}
+
+// etagMatches reports whether an If-None-Match header names etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveCalendar writes an iCalendar response. The ETag covers the calendar's
+// content but not its DTSTAMPs, so an unchanged calendar answers 304.
+func serveCalendar(c *gin.Context, calendar *ical.Calendar, filename string) {
+	var content bytes.Buffer
+	if err := calendar.Encode(&content, time.Time{}); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build calendar", "detail": err.Error()})
+		return
+	}
+	sum := sha256.Sum256(content.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "private, no-cache")
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := calendar.Encode(&body, time.Now()); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build calendar", "detail": err.Error()})
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="`+filename+`"`)
+	c.Data(http.StatusOK, "text/calendar; charset=utf-8", body.Bytes())
+}
+
+// GetEventICS exports an event as iCalendar
+//
+//	@Summary		Exports an event as iCalendar
+//	@Description	Returns the event as a .ics file; recurring events include their RRULE, EXDATEs and changed occurrences. Supports If-None-Match.
+//	@Tags			events
+//	@Produce		text/calendar
+//	@Param			id	path	int	true	"Event ID"
+//	@Success		200
+//	@Success		304
+//	@Router			/api/v1/events/{id}/ics [get]
+
+func (h *EventHandler) GetEventICS(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID", "detail": err.Error()})
+		return
+	}
+
+	event, err := h.Models.Events.GET(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event", "detail": err.Error()})
+		return
+	}
+	if event == nil || !event.VisibleTo(utils.RetrieveUserFromContext(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	vevents, err := h.calendarEvents(event)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to expand event", "detail": err.Error()})
+		return
+	}
+
+	calendar := &ical.Calendar{ProdID: calendarProdID, Events: vevents}
+	if event.Name != nil {
+		calendar.Name = *event.Name
+	}
+	serveCalendar(c, calendar, fmt.Sprintf("event-%d.ics", event.Id))
+}
+
+// GetCalendarFeed serves a user's calendar feed
+//
+//	@Summary		Serves a calendar feed
+//	@Description	Every event the feed's owner organizes or attends, as iCalendar for calendar apps to subscribe to. The token in the URL authenticates the request. Supports If-None-Match.
+//	@Tags			events
+//	@Produce		text/calendar
+//	@Param			token	path	string	true	"Feed token, optionally followed by .ics"
+//	@Success		200
+//	@Success		304
+//	@Router			/api/v1/calendar/{token} [get]
+
+func (h *EventHandler) GetCalendarFeed(c *gin.Context) {
+	token := strings.TrimSuffix(c.Param("token"), ".ics")
+	if !strings.HasPrefix(token, calendarFeedPrefix) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	feed, err := h.Models.CalendarFeeds.GetByHash(utils.HashToken(token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve calendar feed", "detail": err.Error()})
+		return
+	}
+	if feed == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+
+	user, err := h.Models.Users.Get(feed.UserId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user", "detail": err.Error()})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Calendar feed not found"})
+		return
+	}
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "This account is suspended"})
+		return
+	}
+
+	owned, err := h.Models.Events.GetByOwner(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events", "detail": err.Error()})
+		return
+	}
+	attending, err := h.Models.Attendees.GetEventsByAttendee(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve events", "detail": err.Error()})
+		return
+	}
+
+	vevents := []*ical.Event{}
+	organizing := map[int]bool{}
+	for _, event := range owned {
+		organizing[event.Id] = true
+		series, err := h.calendarEvents(event)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to expand event", "detail": err.Error()})
+			return
+		}
+		vevents = append(vevents, series...)
+	}
+
+	// attendees get the occurrences they registered for, not the whole series
+	for _, registration := range attending {
+		if organizing[registration.Id] || registration.Status == database.StatusDeclined {
+			continue
+		}
+
+		event := &registration.Event
+		if registration.Occurrence != "" {
+			occurrence, err := h.Models.Events.GetOccurrence(event, registration.Occurrence)
+			if err != nil {
+				continue
+			}
+			event = &occurrence.Event
+			event.RRule = nil
+		}
+		if event.StartsAt == nil {
+			continue
+		}
+
+		vevent := h.icalEvent(event, h.eventUID(event.Id, registration.Occurrence))
+		if event.Status != database.EventCancelled {
+			vevent.Status = rsvpStatus(registration)
+		}
+		vevents = append(vevents, vevent)
+	}
+
+	if err := h.Models.CalendarFeeds.Touch(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update calendar feed", "detail": err.Error()})
+		return
+	}
+
+	calendar := &ical.Calendar{
+		ProdID: calendarProdID,
+		Name:   user.Username + "'s events",
+		Events: vevents,
+	}
+	serveCalendar(c, calendar, "events.ics")
+}
+
+// GetCalendarFeedStatus tells the user whether they have a calendar feed
+//
+//	@Summary		Shows the calendar feed
+//	@Description	Whether the user has a calendar feed, and when it was created and last fetched. The URL is only shown when the feed is created.
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{object}	database.CalendarFeed
+//	@Router			/api/v1/me/calendar-feed [get]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) GetCalendarFeedStatus(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	feed, err := h.Models.CalendarFeeds.Get(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve calendar feed", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "active": feed != nil, "feed": feed})
+}
+
+// CreateCalendarFeed creates the user's calendar feed, or replaces its URL
+//
+//	@Summary		Creates a calendar feed
+//	@Description	Returns a secret feed URL for calendar apps. Calling it again issues a new URL and the old one stops working. The URL is shown only in this response.
+//	@Tags			me
+//	@Produce		json
+//	@Success		201	{object}	gin.H
+//	@Router			/api/v1/me/calendar-feed [post]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) CreateCalendarFeed(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	secret, err := utils.GenerateToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate calendar feed token"})
+		return
+	}
+	token := calendarFeedPrefix + secret
+
+	feed, err := h.Models.CalendarFeeds.Rotate(user.ID, utils.HashToken(token))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create calendar feed", "detail": err.Error()})
+		return
+	}
+
+	feedURL := calendarFeedURL(h.APIURL, token)
+	c.JSON(http.StatusCreated, gin.H{
+		"status":    "ok",
+		"message":   "Store this URL now; it cannot be shown again",
+		"url":       feedURL,
+		"webcalUrl": "webcal://" + strings.TrimPrefix(strings.TrimPrefix(feedURL, "https://"), "http://"),
+		"feed":      feed,
+	})
+}
+
+// RevokeCalendarFeed turns the user's calendar feed off
+//
+//	@Summary		Revokes the calendar feed
+//	@Description	The feed URL stops working
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/me/calendar-feed [delete]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) RevokeCalendarFeed(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	revoked, err := h.Models.CalendarFeeds.Revoke(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke calendar feed", "detail": err.Error()})
+		return
+	}
+	if !revoked {
+		c.JSON(http.StatusNotFound, gin.H{"error": "You have no calendar feed"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Calendar feed revoked"})
+}
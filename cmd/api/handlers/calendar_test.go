@@ -0,0 +1,66 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/ical"
+)
+
+func TestServeCalendarETag(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	calendar := &ical.Calendar{ProdID: "-//test//EN", Events: []*ical.Event{{UID: "a", Start: start, Summary: "Standup"}}}
+
+	router := gin.New()
+	router.GET("/calendar.ics", func(c *gin.Context) { serveCalendar(c, calendar, "calendar.ics") })
+	serve := func(ifNoneMatch string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/calendar.ics", nil)
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		return rec
+	}
+
+	first := serve("")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("got %d with ETag %q", first.Code, etag)
+	}
+
+	// DTSTAMP is the time of the request, but the ETag does not move with it
+	time.Sleep(1100 * time.Millisecond)
+	second := serve("")
+	if second.Header().Get("ETag") != etag {
+		t.Errorf("ETag changed from %s to %s", etag, second.Header().Get("ETag"))
+	}
+	if first.Body.String() == second.Body.String() {
+		t.Error("DTSTAMP did not change between requests")
+	}
+
+	tests := []struct {
+		ifNoneMatch string
+		want        int
+	}{
+		{etag, http.StatusNotModified},
+		{"W/" + etag, http.StatusNotModified},
+		{`"other", ` + etag, http.StatusNotModified},
+		{"*", http.StatusNotModified},
+		{`"other"`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := serve(tt.ifNoneMatch).Code; got != tt.want {
+			t.Errorf("If-None-Match %s: got %d, want %d", tt.ifNoneMatch, got, tt.want)
+		}
+	}
+
+	calendar.Events[0].Summary = "Long standup"
+	if serve(etag).Code != http.StatusOK {
+		t.Error("changed calendar answered 304")
+	}
+}
@@ -1,18 +1,27 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
 	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/mailer"
 )
 
 type EventHandler struct {
 	Models database.Models
+	// Mailer tells attendees when an event is cancelled
+	Mailer mailer.Mailer
+	// APIURL is this API's public base URL, used in exported calendars
+	APIURL string
 }
 
 // create event
@@ -20,7 +29,7 @@ type EventHandler struct {
 // CreateEvent creates a new event
 //
 //	@Summary		Creates a new event
-//	@Description	Creates a new event
+//	@Description	Creates a new event, as a draft only its owner can see unless status is "published"
 //	@Tags			events
 //	@Accept			json
 //	@Produce		json
@@ -37,6 +46,15 @@ func (h *EventHandler) CreateEvent(c *gin.Context) {
 		return
 	}
 
+	if problems := validateEvent(&event); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event", "detail": strings.Join(problems, "; "), "status":"error"})
+		return
+	}
+
+	// The creator always owns the event, whatever the body says
+	contextUser := utils.RetrieveUserFromContext(c)
+	event.OwnerId = &contextUser.ID
+
 	err := h.Models.Events.Insert(&event)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create event", "detail": err.Error(), "status":"error"})
@@ -48,21 +66,130 @@ func (h *EventHandler) CreateEvent(c *gin.Context) {
 	})
 }
 
+// validateEvent checks a new event against the rules every event must meet,
+// whether created on its own or imported, and normalizes its schedule.
+// It returns what is wrong, if anything.
+func validateEvent(event *database.Event) []string {
+	problems := []string{}
+
+	required := map[string]*string{"name": event.Name, "description": event.Description, "location": event.Location}
+	for _, field := range []string{"name", "description", "location"} {
+		if value := required[field]; value == nil || strings.TrimSpace(*value) == "" {
+			problems = append(problems, field+" is required")
+		}
+	}
+	if err := binding.Validator.ValidateStruct(event); err != nil {
+		problems = append(problems, err.Error())
+	}
+
+	if event.StartsAt == nil || event.StartsAt.IsZero() {
+		problems = append(problems, "startsAt is required")
+	}
+	if event.EndsAt == nil || event.EndsAt.IsZero() {
+		problems = append(problems, "endsAt is required")
+	}
+	if event.Timezone != nil && *event.Timezone != "" {
+		if _, err := database.LoadLocation(*event.Timezone); err != nil {
+			problems = append(problems, "timezone: "+err.Error())
+		}
+	}
+	// later statuses are reached through the lifecycle endpoints
+	if event.Status != "" && event.Status != database.EventDraft && event.Status != database.EventPublished {
+		problems = append(problems, `status must be "draft" or "published"`)
+	}
+	if len(problems) == 0 {
+		problems = append(problems, checkSchedule(event)...)
+	}
+
+	return problems
+}
+
+// checkSchedule checks an event's times, time zone and recurrence, moves its
+// times into its time zone (UTC when none is given) and normalizes its rule
+func checkSchedule(event *database.Event) []string {
+	if event.Timezone == nil || *event.Timezone == "" {
+		zone := "UTC"
+		event.Timezone = &zone
+	}
+	loc, err := database.LoadLocation(*event.Timezone)
+	if err != nil {
+		return []string{"timezone: " + err.Error()}
+	}
+	event.ShowIn(loc)
+
+	problems := []string{}
+	if !event.EndsAt.After(*event.StartsAt) {
+		problems = append(problems, "endsAt must be after startsAt")
+	}
+	if err := normalizeRecurrence(event); err != nil {
+		problems = append(problems, "rrule: "+err.Error())
+	}
+
+	return problems
+}
+
+// displayZone reads ?tz=, the IANA time zone the caller wants event times
+// shown in. Without it each event is shown in its own time zone (nil).
+func displayZone(c *gin.Context) (*time.Location, bool) {
+	name := c.Query("tz")
+	if name == "" {
+		return nil, true
+	}
+
+	loc, err := database.LoadLocation(name)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "tz must be an IANA time zone such as Europe/Berlin", "detail": err.Error()})
+		return nil, false
+	}
+	return loc, true
+}
+
 // get all events
 
-// GetEvents returns all events
+// GetEvents returns a page of events
 //
-//	@Summary		Returns all events
-//	@Description	Returns all events
+//	@Summary		Returns a page of events
+//	@Description	Returns events with offset or cursor pagination, filters and sorting
 //	@Tags			events
 //	@Accept			json
 //	@Produce		json
+//	@Param			limit		query		int		false	"Page size (max 100)"
+//	@Param			offset		query		int		false	"Number of events to skip"
+//	@Param			cursor		query		string	false	"next_cursor from the previous page"
+//	@Param			sort		query		string	false	"id, name, startsAt, endsAt or location; prefix with - for descending"
+//	@Param			from		query		string	false	"Only events starting on or after this date"
+//	@Param			to			query		string	false	"Only events starting on or before this date"
+//	@Param			location	query		string	false	"Location contains"
+//	@Param			owner		query		int		false	"Owner user ID"
+//	@Param			name		query		string	false	"Name contains"
+//	@Param			status		query		string	false	"Comma-separated event statuses; drafts are only listed for their owner"
+//	@Param			expand		query		bool	false	"List occurrences of recurring events starting from from up to (not including) to, by start"
+//	@Param			tz			query		string	false	"IANA time zone to show times in (default: each event's own)"
 //	@Success		200		{object}	[]database.Event
 //	@Router			/api/v1/events [get]
 
 func (h *EventHandler) GetAllEvent(c *gin.Context) {
-	events, err := h.Models.Events.GetAll()
+	query, err := utils.ParseListQuery(c, database.EventListSpec)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		return
+	}
+	zone, ok := displayZone(c)
+	if !ok {
+		return
+	}
+
+	if expand, _ := strconv.ParseBool(c.Query("expand")); expand {
+		h.listOccurrences(c, query, zone)
+		return
+	}
+
+	events, page, err := h.Models.Events.List(query, utils.RetrieveUserFromContext(c))
 	if err != nil {
+		if errors.Is(err, database.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"status": "error",
 			"error":  "Failed to retrieve events",
@@ -71,10 +198,84 @@ func (h *EventHandler) GetAllEvent(c *gin.Context) {
 		return
 	}
 
+	if zone != nil {
+		for _, event := range events {
+			event.ShowIn(zone)
+		}
+	}
+
 	c.JSON(http.StatusOK, gin.H{
 		"status":       "ok",
-		"totalEvents":  len(events),
+		"totalEvents":  page.Total,
 		"events":       events,
+		"page":         page,
+	})
+}
+
+// search events
+
+// SearchEvents runs a full-text search over event names, descriptions and locations
+//
+//	@Summary		Searches events
+//	@Description	Full-text search over name, description and location, best matches first
+//	@Tags			events
+//	@Accept			json
+//	@Produce		json
+//	@Param			q		query		string	true	"Search text"
+//	@Param			limit	query		int		false	"Page size (max 100)"
+//	@Param			offset	query		int		false	"Number of results to skip"
+//	@Param			tz		query		string	false	"IANA time zone to show times in (default: each event's own)"
+//	@Success		200		{object}	[]database.EventSearchResult
+//	@Router			/api/v1/events/search [get]
+
+func (h *EventHandler) SearchEvents(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "Query parameter q is required"})
+		return
+	}
+
+	// search is ranked, so it pages by offset only and has no sort or filters
+	query, err := utils.ParseListQuery(c, database.ListSpec{})
+	if err == nil && query.Cursor != "" {
+		err = fmt.Errorf("search results cannot be paged with a cursor; use offset")
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		return
+	}
+	limit, offset := query.Limit, query.Offset
+
+	zone, ok := displayZone(c)
+	if !ok {
+		return
+	}
+
+	results, total, err := h.Models.Events.Search(q, limit, offset, utils.RetrieveUserFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "Failed to search events",
+			"detail": err.Error(),
+		})
+		return
+	}
+	if zone != nil {
+		for _, result := range results {
+			result.ShowIn(zone)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ok",
+		"query":   q,
+		"results": results,
+		"page": database.PageInfo{
+			Total:   total,
+			Limit:   limit,
+			Offset:  offset,
+			HasMore: offset+len(results) < total,
+		},
 	})
 }
 
@@ -88,6 +289,7 @@ func (h *EventHandler) GetAllEvent(c *gin.Context) {
 //	@Accept			json
 //	@Produce		json
 //	@Param			id	path		int	true	"Event ID"
+//	@Param			tz	query		string	false	"IANA time zone to show times in (default: the event's own)"
 //	@Success		200	{object}	database.Event
 //	@Router			/api/v1/events/{id} [get]
 
@@ -100,7 +302,8 @@ func (h *EventHandler) GetEvent(c *gin.Context) {
 	 
 	event, err := h.Models.Events.GET(id)
 	fmt.Println("Event is:", event, id)
-	if event == nil {
+	// a draft is only there for its owner
+	if event == nil || !event.VisibleTo(utils.RetrieveUserFromContext(c)) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
 		return
 	}
@@ -110,6 +313,14 @@ func (h *EventHandler) GetEvent(c *gin.Context) {
 		return
 	}
 
+	zone, ok := displayZone(c)
+	if !ok {
+		return
+	}
+	if zone != nil {
+		event.ShowIn(zone)
+	}
+
 	c.JSON(http.StatusOK, gin.H{"status":"ok","event": event})
 }
 
@@ -118,7 +329,7 @@ func (h *EventHandler) GetEvent(c *gin.Context) {
 // UpdateEvent updates an existing event
 //
 //	@Summary		Updates an existing event
-//	@Description	Updates an existing event
+//	@Description	Updates an existing event. "capacity": null removes the seat limit; seats a change frees go to the waitlist.
 //	@Tags			events
 //	@Accept			json
 //	@Produce		json
@@ -146,16 +357,20 @@ func (h *EventHandler) UpdateEvent(c *gin.Context) {
 	contextUser := utils.RetrieveUserFromContext(c)
 
 	// Check if the requester is the owner
-	if existingEvent.OwnerId == nil || *existingEvent.OwnerId != contextUser.ID {
+	if !canManageEvent(contextUser, existingEvent) {
 		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the owner of the event"})
 		return
 	}
 
 	var updateData database.Event
-	if err := c.ShouldBindJSON(&updateData); err != nil {
+	if err := c.ShouldBindBodyWith(&updateData, binding.JSON); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "detail": err.Error()})
 		return
 	}
+	if updateData.Status != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "status cannot be updated here; use the publish, cancel and complete endpoints"})
+		return
+	}
 
 	// Track and apply changes
 	updatedFields := gin.H{}
@@ -169,21 +384,100 @@ func (h *EventHandler) UpdateEvent(c *gin.Context) {
 		existingEvent.Description = updateData.Description
 		updatedFields["description"] = *updateData.Description
 	}
-	if updateData.Date != nil {
-		existingEvent.Date = updateData.Date
-		updatedFields["date"] = updateData.Date.Format(time.RFC3339)
+	if updateData.StartsAt != nil {
+		existingEvent.StartsAt = updateData.StartsAt
+		updatedFields["startsAt"] = updateData.StartsAt.Format(time.RFC3339)
+	}
+	if updateData.EndsAt != nil {
+		existingEvent.EndsAt = updateData.EndsAt
+		updatedFields["endsAt"] = updateData.EndsAt.Format(time.RFC3339)
+	}
+	zoneChanged := updateData.Timezone != nil && *updateData.Timezone != *existingEvent.Timezone
+	if updateData.Timezone != nil {
+		existingEvent.Timezone = updateData.Timezone
+		updatedFields["timezone"] = *updateData.Timezone
 	}
 	if updateData.Location != nil {
 		existingEvent.Location = updateData.Location
 		updatedFields["location"] = *updateData.Location
 	}
+	// "capacity": null lifts the limit; leaving the field out keeps it
+	capacityRaised := false
+	clearCapacity := sentNull(c, "capacity")
+	if clearCapacity {
+		capacityRaised = existingEvent.Capacity != nil
+		existingEvent.Capacity = nil
+		updatedFields["capacity"] = nil
+	} else if updateData.Capacity != nil {
+		capacityRaised = existingEvent.Capacity != nil && *updateData.Capacity > *existingEvent.Capacity
+		existingEvent.Capacity = updateData.Capacity
+		updatedFields["capacity"] = *updateData.Capacity
+	}
+
+	wasRecurring := existingEvent.IsRecurring()
+	previousExDates := map[string]bool{}
+	for _, exdate := range existingEvent.ExDates {
+		previousExDates[database.OccurrenceKey(exdate)] = true
+	}
+	previousRule := ""
+	if wasRecurring {
+		previousRule = *existingEvent.RRule
+	}
+	if updateData.RRule != nil {
+		existingEvent.RRule = updateData.RRule
+		// a series turned back into a one-off event has nothing to exclude
+		if *updateData.RRule == "" && updateData.ExDates == nil {
+			existingEvent.ExDates = []time.Time{}
+		}
+	}
+	if updateData.ExDates != nil {
+		existingEvent.ExDates = updateData.ExDates
+		updatedFields["exdates"] = updateData.ExDates
+	}
+	if problems := checkSchedule(existingEvent); len(problems) > 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule", "detail": strings.Join(problems, "; ")})
+		return
+	}
+	ruleChanged := false
+	if updateData.RRule != nil {
+		ruleChanged = *existingEvent.RRule != previousRule
+		updatedFields["rrule"] = *existingEvent.RRule
+	}
+
+	// Registrations of a recurring event point at occurrences by their start,
+	// so the schedule cannot move underneath them. The time zone counts too:
+	// the rule repeats at the same wall-clock time in it.
+	rescheduled := ruleChanged || ((updateData.StartsAt != nil || zoneChanged) && (wasRecurring || existingEvent.IsRecurring()))
+	if rescheduled {
+		registered, err := h.Models.Attendees.HasRegistrations(id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check registrations", "detail": err.Error()})
+			return
+		}
+		if registered {
+			c.JSON(http.StatusConflict, gin.H{"error": "This event has registrations, so its schedule cannot change; edit or cancel occurrences instead"})
+			return
+		}
+	}
 
 	if len(updatedFields) == 0 {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields provided to update"})
 		return
 	}
 
-	if err := h.Models.Events.Update(existingEvent); err != nil {
+	// newly excluded occurrences are cancelled, registrations and all, and
+	// seats freed by a larger capacity go to the waitlist
+	change := database.EventUpdate{Rescheduled: rescheduled, ClearCapacity: clearCapacity, FillWaitlist: capacityRaised}
+	if updateData.ExDates != nil && existingEvent.IsRecurring() {
+		for _, exdate := range existingEvent.ExDates {
+			if !previousExDates[database.OccurrenceKey(exdate)] {
+				change.Excluded = append(change.Excluded, exdate)
+			}
+		}
+	}
+
+	cancelled, promoted, err := h.Models.Events.Update(existingEvent, change)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event", "detail": err.Error()})
 		return
 	}
@@ -191,11 +485,28 @@ func (h *EventHandler) UpdateEvent(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status":       "ok",
 		"updatedEvent": updatedFields,
+		"promotedFromWaitlist": promoted,
+		"cancelledRegistrations": cancelled,
 	})
 }
 
 
 
+// sentNull reports whether the JSON body bound with ShouldBindBodyWith set a
+// field to null, which binding alone cannot tell apart from leaving it out
+func sentNull(c *gin.Context, field string) bool {
+	body, ok := c.Get(gin.BodyBytesKey)
+	if !ok {
+		return false
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body.([]byte), &fields); err != nil {
+		return false
+	}
+	value, ok := fields[field]
+	return ok && string(value) == "null"
+}
+
 // delete event by Id
 
 // DeleteEvent deletes an existing event
@@ -228,6 +539,12 @@ func (h *EventHandler) DeleteEvent(c *gin.Context) {
 		return
 	}
 
+	contextUser := utils.RetrieveUserFromContext(c)
+	if !canManageEvent(contextUser, existingEvent) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the owner of the event"})
+		return
+	}
+
 	if err := h.Models.Events.Delete(id); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete event"})
 		return
@@ -240,11 +557,22 @@ func (h *EventHandler) DeleteEvent(c *gin.Context) {
 			"id":          existingEvent.Id,
 			"name":        existingEvent.Name,
 			"description": existingEvent.Description,
-			"date":        existingEvent.Date.Format(time.RFC3339),
+			"startsAt":    existingEvent.StartsAt.Format(time.RFC3339),
+			"endsAt":      existingEvent.EndsAt.Format(time.RFC3339),
+			"timezone":    existingEvent.Timezone,
 			"location":    existingEvent.Location,
 			"ownerId":     existingEvent.OwnerId,
+			"status":      existingEvent.Status,
 		},
 		"deletedAt": time.Now().Format(time.RFC3339),
 		// "deletedBy": c.GetString("userID"), 
 	})
-}
\ No newline at end of file
+}
+
+// canManageEvent reports whether the user owns the event or may manage any event
+func canManageEvent(user *database.User, event *database.Event) bool {
+	if user.HasPermission(database.PermEventsManageAny) {
+		return true
+	}
+	return event.OwnerId != nil && *event.OwnerId == user.ID
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+func TestUpdateEventCapacity(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+
+	name, description, location, zone := "Party", "Cake and music", "Hall", "UTC"
+	start := time.Date(2026, 5, 1, 18, 0, 0, 0, time.UTC)
+	end := start.Add(4 * time.Hour)
+	capacity := 1
+	event := &database.Event{Name: &name, Description: &description, Location: &location, StartsAt: &start, EndsAt: &end,
+		Timezone: &zone, OwnerId: &owner.ID, Capacity: &capacity, Status: database.EventPublished}
+	if err := models.Events.Insert(event); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		guest := insertUser(t, models, fmt.Sprintf("guest%d", i))
+		if _, err := models.Attendees.Register(event.Id, "", guest.ID, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := &EventHandler{Models: models}
+	router := gin.New()
+	router.PUT("/events/:id", func(c *gin.Context) { c.Set("user", owner) }, h.UpdateEvent)
+
+	one, two := 1, 2
+	tests := []struct {
+		name         string
+		body         string
+		wantPromoted int
+		wantCapacity *int
+	}{
+		{"raised", `{"capacity": 2}`, 1, &two},
+		{"left out", `{"name": "Big party"}`, 0, &two},
+		{"lowered", `{"capacity": 1}`, 0, &one},
+		{"null lifts the limit", `{"capacity": null}`, 2, nil},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPut, fmt.Sprintf("/events/%d", event.Id), strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: got %d: %s", tt.name, rec.Code, rec.Body.String())
+		}
+
+		var response struct {
+			Promoted []*database.Attendee `json:"promotedFromWaitlist"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if len(response.Promoted) != tt.wantPromoted {
+			t.Errorf("%s: promoted %d, want %d", tt.name, len(response.Promoted), tt.wantPromoted)
+		}
+
+		saved, err := models.Events.GET(event.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (saved.Capacity == nil) != (tt.wantCapacity == nil) || (saved.Capacity != nil && *saved.Capacity != *tt.wantCapacity) {
+			t.Errorf("%s: capacity = %v, want %v", tt.name, saved.Capacity, tt.wantCapacity)
+		}
+	}
+}
+
+func TestCreateEventTimeZones(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+
+	h := &EventHandler{Models: models}
+	router := gin.New()
+	router.POST("/events", func(c *gin.Context) { c.Set("user", owner) }, h.CreateEvent)
+
+	tests := []struct {
+		name       string
+		timezone   string
+		startsAt   string
+		endsAt     string
+		wantCode   int
+		wantDetail string
+		// wantStored is starts_at as written to the database
+		wantStored time.Time
+	}{
+		{"offset kept as an instant", "Europe/Berlin", "2026-07-01T09:00:00+02:00", "2026-07-01T11:00:00+02:00", http.StatusOK, "", time.Date(2026, 7, 1, 7, 0, 0, 0, time.UTC)},
+		{"given in another offset", "Europe/Berlin", "2026-07-01T03:00:00-04:00", "2026-07-01T05:00:00-04:00", http.StatusOK, "", time.Date(2026, 7, 1, 7, 0, 0, 0, time.UTC)},
+		{"no zone means UTC", "", "2026-07-01T09:00:00Z", "2026-07-01T10:00:00Z", http.StatusOK, "", time.Date(2026, 7, 1, 9, 0, 0, 0, time.UTC)},
+		{"unknown zone", "Mars/Olympus_Mons", "2026-07-01T09:00:00Z", "2026-07-01T10:00:00Z", http.StatusBadRequest, "timezone", time.Time{}},
+		{"server local zone", "Local", "2026-07-01T09:00:00Z", "2026-07-01T10:00:00Z", http.StatusBadRequest, "timezone", time.Time{}},
+		{"ends before it starts", "UTC", "2026-07-01T09:00:00Z", "2026-07-01T08:00:00Z", http.StatusBadRequest, "endsAt must be after startsAt", time.Time{}},
+		{"ends as it starts", "UTC", "2026-07-01T09:00:00Z", "2026-07-01T09:00:00Z", http.StatusBadRequest, "endsAt must be after startsAt", time.Time{}},
+	}
+
+	for i, tt := range tests {
+		body := fmt.Sprintf(`{"name": "event %d", "description": "d", "location": "l", "timezone": %q, "startsAt": %q, "endsAt": %q}`,
+			i, tt.timezone, tt.startsAt, tt.endsAt)
+		req := httptest.NewRequest(http.MethodPost, "/events", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.wantCode, rec.Body.String())
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			if !strings.Contains(rec.Body.String(), tt.wantDetail) {
+				t.Errorf("%s: %s does not mention %q", tt.name, rec.Body.String(), tt.wantDetail)
+			}
+			continue
+		}
+
+		var response struct {
+			Event database.Event `json:"createdEvent"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		var stored time.Time
+		if err := models.Events.DB.QueryRow("SELECT starts_at FROM events WHERE id = ?", response.Event.Id).Scan(&stored); err != nil {
+			t.Fatal(err)
+		}
+		if !stored.Equal(tt.wantStored) || stored.Location() != time.UTC {
+			t.Errorf("%s: stored %s, want %s", tt.name, stored, tt.wantStored)
+		}
+	}
+}
+
+func TestGetEventShowsRequestedZone(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+
+	name, description, location, zone := "Concert", "Live", "Park", "Europe/Berlin"
+	start := time.Date(2026, 7, 1, 18, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	event := &database.Event{Name: &name, Description: &description, Location: &location, StartsAt: &start, EndsAt: &end,
+		Timezone: &zone, OwnerId: &owner.ID, Status: database.EventPublished}
+	if err := models.Events.Insert(event); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &EventHandler{Models: models}
+	router := gin.New()
+	router.GET("/events/:id", h.GetEvent)
+
+	tests := []struct {
+		name      string
+		query     string
+		wantCode  int
+		wantStart string
+	}{
+		{"the event's own zone", "", http.StatusOK, "2026-07-01T20:00:00+02:00"},
+		{"the caller's zone", "?tz=America/New_York", http.StatusOK, "2026-07-01T14:00:00-04:00"},
+		{"UTC", "?tz=UTC", http.StatusOK, "2026-07-01T18:00:00Z"},
+		{"unknown zone", "?tz=Nowhere/Special", http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, fmt.Sprintf("/events/%d%s", event.Id, tt.query), nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.wantCode, rec.Body.String())
+			continue
+		}
+		if tt.wantCode != http.StatusOK {
+			continue
+		}
+
+		var response struct {
+			Event struct {
+				StartsAt string `json:"startsAt"`
+				Timezone string `json:"timezone"`
+			} `json:"event"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+			t.Fatal(err)
+		}
+		if response.Event.StartsAt != tt.wantStart || response.Event.Timezone != zone {
+			t.Errorf("%s: startsAt %s in %s, want %s in %s", tt.name, response.Event.StartsAt, response.Event.Timezone, tt.wantStart, zone)
+		}
+	}
+}
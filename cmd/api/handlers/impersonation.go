@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/session"
+)
+
+// impersonation tokens cannot be refreshed; support staff mint a new one when it runs out
+const impersonationTokenTTL = 15 * time.Minute
+
+type impersonateRequest struct {
+	Reason string `json:"reason" binding:"required,max=500"`
+}
+
+// ImpersonateUser mints an access token that acts as another user
+//
+//	@Summary		Impersonates a user
+//	@Description	Returns a short-lived access token for the user that also names the admin in an act claim. It cannot be refreshed, cannot change credentials, and every write made with it is audited. It runs in a session of the user's, so signing the user out ends it too.
+//	@Tags			admin
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"User ID"
+//	@Param			body	body		impersonateRequest	true	"Why the user is impersonated"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/admin/users/{id}/impersonate [post]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) ImpersonateUser(c *gin.Context) {
+	admin := utils.RetrieveUserFromContext(c)
+
+	var req impersonateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, ok := h.targetUser(c)
+	if !ok {
+		return
+	}
+	if user.ID == admin.ID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot impersonate yourself"})
+		return
+	}
+	if user.SuspendedAt != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Suspended users cannot be impersonated"})
+		return
+	}
+
+	if err := h.Models.Roles.LoadForUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user roles", "detail": err.Error()})
+		return
+	}
+	// impersonation must not hand out permissions the admin does not already have
+	for _, permission := range user.Permissions {
+		if !admin.HasPermission(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "You cannot impersonate a user with permissions you do not have", "permission": permission})
+			return
+		}
+	}
+
+	tokenID, err := utils.GenerateToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	sessionID, err := utils.GenerateToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	// no refresh token is ever issued for it, so this jti never matches one
+	refreshJTI, err := utils.GenerateToken(16)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	// The token belongs to a session of the user's, so a forced logout, the
+	// user revoking their sessions, or a password reset ends it
+	sess := &session.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		Device:     fmt.Sprintf("Impersonation by %s", admin.Email),
+		IP:         c.ClientIP(),
+		RefreshJTI: refreshJTI,
+	}
+	if err := h.Sessions.CreateExpiring(redisclient.Ctx, sess, impersonationTokenTTL); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start impersonation", "detail": err.Error()})
+		return
+	}
+
+	expiresAt := time.Now().Add(impersonationTokenTTL)
+	token, err := h.Keys.Sign(jwt.MapClaims{
+		"typ":     tokenTypeAccess,
+		"jti":     tokenID,
+		"sid":     sess.ID,
+		"user_id": user.ID,
+		"email":   user.Email,
+		"roles":   user.Roles,
+		"act": map[string]interface{}{
+			"user_id": admin.ID,
+			"email":   admin.Email,
+		},
+		"exp": expiresAt.Unix(),
+	})
+	if err != nil {
+		h.Sessions.Revoke(redisclient.Ctx, user.ID, sess.ID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token", "detail": err.Error()})
+		return
+	}
+
+	h.recordAudit(c, database.AuditImpersonationStarted, user, strings.TrimSpace(req.Reason))
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":    "ok",
+		"token":     token,
+		"expiresAt": expiresAt.UTC(),
+		"userId":    user.ID,
+		"actorId":   admin.ID,
+		"sessionId": sess.ID,
+	})
+}
+
+// StopImpersonation ends the impersonation the request is made with
+//
+//	@Summary		Stops impersonating
+//	@Description	Revokes the impersonation token the request is made with, and the session it runs in
+//	@Tags			admin
+//	@Produce		json
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/auth/impersonation/stop [post]
+//	@Security		BearerAuth
+
+func (h *AdminHandler) StopImpersonation(c *gin.Context) {
+	actor := utils.RetrieveActorFromContext(c)
+	if actor == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This request is not made with an impersonation token"})
+		return
+	}
+	user := utils.RetrieveUserFromContext(c)
+
+	tokenID, expiresAt := utils.RetrieveTokenFromContext(c)
+	if err := h.Sessions.DenyAccessToken(redisclient.Ctx, tokenID, expiresAt); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to stop impersonation", "detail": err.Error()})
+		return
+	}
+	if sessionID := utils.RetrieveSessionIDFromContext(c); sessionID != "" {
+		if err := h.Sessions.Revoke(redisclient.Ctx, user.ID, sessionID); err != nil {
+			log.Printf("failed to revoke impersonation session: %v", err)
+		}
+	}
+
+	// recordAudit would name the impersonated user as the actor
+	if err := h.Models.Audit.Record(&database.AuditEntry{
+		Action:       database.AuditImpersonationStopped,
+		ActorId:      &actor.ID,
+		TargetUserId: &user.ID,
+		IP:           c.ClientIP(),
+	}); err != nil {
+		log.Printf("failed to record audit entry: %v", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Impersonation stopped"})
+}
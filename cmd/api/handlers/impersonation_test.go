@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/middleware"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+	"github.com/muhamash/go-first-rest-api/internal/jwtkeys"
+	"github.com/muhamash/go-first-rest-api/internal/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// newImpersonationRouter serves the admin routes as an already signed-in
+// admin, and checks impersonation tokens at /whoami with the real middleware
+func newImpersonationRouter(t *testing.T) (router *gin.Engine, target *database.User) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	models := database.NewModels(dbtest.New(t))
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	keys, err := jwtkeys.Ephemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sessions := &session.Store{Redis: rdb, TTL: time.Hour}
+
+	admin := &database.User{Username: "admin", Email: "admin@example.com", Password: "x"}
+	target = &database.User{Username: "target", Email: "target@example.com", Password: "x"}
+	for _, user := range []*database.User{admin, target} {
+		if err := models.Users.Insert(user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := models.Roles.Grant(admin.ID, database.RoleAdmin); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Roles.Grant(target.ID, database.RoleMember); err != nil {
+		t.Fatal(err)
+	}
+	if err := models.Roles.LoadForUser(admin); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &AdminHandler{Models: models, Sessions: sessions, Keys: keys}
+	auth := &middleware.AuthMiddleware{Models: models, Keys: keys, Redis: rdb, Sessions: sessions}
+
+	router = gin.New()
+	asAdmin := router.Group("/admin", func(c *gin.Context) { c.Set("user", admin) })
+	asAdmin.POST("/users/:id/impersonate", h.ImpersonateUser)
+	asAdmin.POST("/users/:id/logout", h.LogoutUser)
+
+	router.GET("/whoami", auth.RequireAuth(), func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.POST("/impersonation/stop", auth.RequireAuth(middleware.RejectAPIKeys()), h.StopImpersonation)
+	return router, target
+}
+
+func impersonate(t *testing.T, router *gin.Engine, target *database.User) string {
+	t.Helper()
+
+	rec := postJSON(t, router, fmt.Sprintf("/admin/users/%d/impersonate", target.ID), gin.H{"reason": "support ticket"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("impersonate: got %d: %s", rec.Code, rec.Body.String())
+	}
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || response.Token == "" {
+		t.Fatalf("impersonate: no token: %s", rec.Body.String())
+	}
+	return response.Token
+}
+
+func withToken(t *testing.T, router *gin.Engine, method, path, token string) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestImpersonationEnds(t *testing.T) {
+	tests := []struct {
+		name string
+		end  func(t *testing.T, router *gin.Engine, target *database.User, token string)
+	}{
+		{"forced logout", func(t *testing.T, router *gin.Engine, target *database.User, token string) {
+			rec := postJSON(t, router, fmt.Sprintf("/admin/users/%d/logout", target.ID), gin.H{})
+			if rec.Code != http.StatusOK {
+				t.Fatalf("logout: got %d: %s", rec.Code, rec.Body.String())
+			}
+		}},
+		{"stopped", func(t *testing.T, router *gin.Engine, target *database.User, token string) {
+			if code := withToken(t, router, http.MethodPost, "/impersonation/stop", token); code != http.StatusOK {
+				t.Fatalf("stop: got %d", code)
+			}
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, target := newImpersonationRouter(t)
+			token := impersonate(t, router, target)
+
+			if code := withToken(t, router, http.MethodGet, "/whoami", token); code != http.StatusOK {
+				t.Fatalf("before: got %d, want 200", code)
+			}
+
+			tt.end(t, router, target, token)
+
+			if code := withToken(t, router, http.MethodGet, "/whoami", token); code != http.StatusUnauthorized {
+				t.Fatalf("after: got %d, want 401", code)
+			}
+		})
+	}
+}
@@ -0,0 +1,537 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/ical"
+)
+
+const (
+	// the largest file POST /events/import accepts
+	maxImportBytes = 5 << 20
+	// the most events one import may create
+	maxImportRows = 1000
+)
+
+// the columns an import CSV may have, in any order; the header row names them
+var importColumns = map[string]bool{
+	"name": true, "description": true, "starts_at": true, "ends_at": true, "timezone": true,
+	"location": true, "capacity": true, "rrule": true, "exdates": true,
+}
+
+// the columns every import CSV needs, since validateEvent requires them of every event
+var requiredImportColumns = []string{"name", "description", "starts_at", "ends_at", "location"}
+
+// importRow reports on one event of an import file
+type importRow struct {
+	// Line is where the row (CSV) or VEVENT (iCalendar) begins in the file
+	Line int    `json:"line"`
+	Name string `json:"name,omitempty"`
+	// Occurrence is set for a VEVENT that changes one occurrence of a series
+	Occurrence string   `json:"occurrence,omitempty"`
+	Id         int      `json:"id,omitempty"`
+	Skipped    string   `json:"skipped,omitempty"`
+	Errors     []string `json:"errors,omitempty"`
+
+	item *database.EventImport
+	// the VEVENT a row was read from, for iCalendar imports
+	source *ical.Event
+}
+
+func (r *importRow) fail(format string, args ...interface{}) {
+	r.Errors = append(r.Errors, fmt.Sprintf(format, args...))
+}
+
+// ImportEvents creates events in bulk from an iCalendar or CSV file
+//
+//	@Summary		Imports events
+//	@Description	Creates every event of an .ics or CSV file in one transaction, owned by the caller. Each row is checked like POST /events; if any row fails nothing is created. CSV files need a header row naming the columns: name, description, starts_at, ends_at and location are required, and timezone, capacity, rrule and exdates are optional. With dryRun=true the file is only checked.
+//	@Tags			events
+//	@Accept			mpfd
+//	@Accept			text/calendar
+//	@Accept			text/csv
+//	@Produce		json
+//	@Param			file	formData	file	false	"The file, when sent as multipart/form-data"
+//	@Param			format	query		string	false	"ics or csv; by default taken from the file name, content type or contents"
+//	@Param			dryRun	query		bool	false	"Only validate and report per-row errors"
+//	@Success		201		{object}	map[string]interface{}
+//	@Failure		422		{object}	map[string]interface{}
+//	@Router			/api/v1/events/import [post]
+//	@Security		BearerAuth
+
+func (h *EventHandler) ImportEvents(c *gin.Context) {
+	dryRun := false
+	if value := c.Query("dryRun"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "dryRun must be true or false"})
+			return
+		}
+		dryRun = parsed
+	}
+
+	data, filename, contentType, err := readImportFile(c)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Import files may be at most %d MB", maxImportBytes>>20)})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the upload", "detail": err.Error()})
+		return
+	}
+
+	format, err := importFormat(c.Query("format"), filename, contentType, data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var rows []*importRow
+	if format == "ics" {
+		rows, err = icsImportRows(data)
+	} else {
+		rows, err = csvImportRows(data)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Could not read the " + format + " file", "detail": err.Error()})
+		return
+	}
+	if len(rows) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The file contains no events"})
+		return
+	}
+	if len(rows) > maxImportRows {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d events can be imported at once", maxImportRows)})
+		return
+	}
+
+	// every imported event belongs to the caller, like one made with POST /events
+	owner := utils.RetrieveUserFromContext(c).ID
+	for _, row := range rows {
+		if row.item == nil {
+			continue
+		}
+		row.item.Event.OwnerId = &owner
+		row.Errors = append(row.Errors, validateEvent(row.item.Event)...)
+	}
+	attachOccurrences(rows)
+
+	if err := h.checkImportNames(rows); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check event names", "detail": err.Error()})
+		return
+	}
+
+	items := []*database.EventImport{}
+	invalid := []*importRow{}
+	for _, row := range rows {
+		if len(row.Errors) > 0 {
+			invalid = append(invalid, row)
+		} else if row.item != nil {
+			items = append(items, row.item)
+		}
+	}
+
+	if dryRun {
+		c.JSON(http.StatusOK, gin.H{
+			"status":  "ok",
+			"dryRun":  true,
+			"format":  format,
+			"total":   len(rows),
+			"valid":   len(rows) - len(invalid),
+			"invalid": len(invalid),
+			"rows":    rows,
+		})
+		return
+	}
+
+	if len(invalid) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"status":  "error",
+			"error":   "No events were imported; fix the rows below and try again",
+			"invalid": len(invalid),
+			"rows":    invalid,
+		})
+		return
+	}
+	if len(items) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The file contains no events to import", "rows": rows})
+		return
+	}
+
+	if err := h.Models.Events.Import(items); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import events", "detail": err.Error()})
+		return
+	}
+
+	for _, row := range rows {
+		if row.item != nil {
+			row.Id = row.item.Event.Id
+		}
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"status":   "ok",
+		"format":   format,
+		"imported": len(items),
+		"rows":     rows,
+	})
+}
+
+// readImportFile returns the uploaded file with its name and content type. It
+// is either the "file" field of a multipart form or the whole request body.
+func readImportFile(c *gin.Context) ([]byte, string, string, error) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxImportBytes)
+
+	if c.ContentType() != "multipart/form-data" {
+		data, err := io.ReadAll(c.Request.Body)
+		return data, "", c.ContentType(), err
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return nil, "", "", err
+	}
+	file, err := header.Open()
+	if err != nil {
+		return nil, "", "", err
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	return data, header.Filename, header.Header.Get("Content-Type"), err
+}
+
+// importFormat decides whether a file is iCalendar or CSV: ?format= wins, then
+// the file's extension, then its content type, then whether it looks like a calendar
+func importFormat(requested, filename, contentType string, data []byte) (string, error) {
+	switch strings.ToLower(requested) {
+	case "ics", "csv":
+		return strings.ToLower(requested), nil
+	case "":
+	default:
+		return "", errors.New("format must be ics or csv")
+	}
+
+	switch strings.ToLower(path.Ext(filename)) {
+	case ".ics", ".ical", ".ifb":
+		return "ics", nil
+	case ".csv":
+		return "csv", nil
+	}
+
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	switch strings.ToLower(strings.TrimSpace(mediaType)) {
+	case "text/calendar":
+		return "ics", nil
+	case "text/csv", "application/csv":
+		return "csv", nil
+	}
+
+	content := bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\ufeff")))
+	if bytes.HasPrefix(bytes.ToUpper(content), []byte("BEGIN:VCALENDAR")) {
+		return "ics", nil
+	}
+	return "csv", nil
+}
+
+// csvImportRows reads one event per record after the header row
+func csvImportRows(data []byte) ([]*importRow, error) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\ufeff"))))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("the file is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	columns := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !importColumns[name] {
+			return nil, fmt.Errorf("unknown column %q; columns are name, description, starts_at, ends_at, timezone, location, capacity, rrule and exdates", header[i])
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("column %q appears twice", name)
+		}
+		seen[name] = true
+		columns[i] = name
+	}
+	for _, name := range requiredImportColumns {
+		if !seen[name] {
+			return nil, fmt.Errorf("the header row has no %s column; name, description, starts_at, ends_at and location are required", name)
+		}
+	}
+
+	rows := []*importRow{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		row := &importRow{Line: line}
+		rows = append(rows, row)
+		if len(record) != len(columns) {
+			row.fail("has %d fields but the header has %d", len(record), len(columns))
+			continue
+		}
+
+		values := map[string]string{}
+		for i, column := range columns {
+			values[column] = strings.TrimSpace(record[i])
+		}
+
+		event := &database.Event{}
+		text := map[string]**string{"name": &event.Name, "description": &event.Description, "location": &event.Location,
+			"timezone": &event.Timezone, "rrule": &event.RRule}
+		for column, field := range text {
+			if value := values[column]; value != "" {
+				*field = &value
+			}
+		}
+		row.Name = values["name"]
+
+		// local times are read in the row's time zone; checkSchedule reports a bad one
+		loc := time.UTC
+		if event.Timezone != nil {
+			if zone, err := database.LoadLocation(*event.Timezone); err == nil {
+				loc = zone
+			}
+		}
+		for column, field := range map[string]**time.Time{"starts_at": &event.StartsAt, "ends_at": &event.EndsAt} {
+			if values[column] == "" {
+				continue
+			}
+			t, err := importTime(values[column], loc)
+			if err != nil {
+				row.fail("%s: %v", column, err)
+				continue
+			}
+			*field = &t
+		}
+
+		if value := values["capacity"]; value != "" {
+			capacity, err := strconv.Atoi(value)
+			if err != nil {
+				row.fail("capacity: %q is not a whole number", value)
+			} else {
+				event.Capacity = &capacity
+			}
+		}
+
+		// exdates are separated by spaces, commas or semicolons
+		for _, part := range strings.FieldsFunc(values["exdates"], func(r rune) bool { return r == ' ' || r == ';' || r == ',' }) {
+			exdate, err := time.Parse(time.RFC3339, part)
+			if err != nil {
+				row.fail("exdates: %q is not an RFC 3339 date-time", part)
+				continue
+			}
+			event.ExDates = append(event.ExDates, exdate)
+		}
+		row.item = &database.EventImport{Event: event}
+	}
+
+	return rows, nil
+}
+
+// the layouts a CSV time may have besides RFC 3339; they are local to the row's time zone
+var localTimeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02T15:04", "2006-01-02 15:04:05", "2006-01-02 15:04"}
+
+// importTime reads an RFC 3339 time, or a local one such as "2026-05-01 18:30" in loc
+func importTime(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	for _, layout := range localTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither an RFC 3339 time nor a local one like 2006-01-02 15:04", value)
+}
+
+// icsImportRows reads one event per VEVENT. Cancelled events are skipped, and
+// VEVENTs with a RECURRENCE-ID are kept aside for attachOccurrences.
+func icsImportRows(data []byte) ([]*importRow, error) {
+	vevents, err := ical.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []*importRow{}
+	for _, vevent := range vevents {
+		row := &importRow{Line: vevent.Line, Name: vevent.Summary, source: vevent}
+		rows = append(rows, row)
+		row.Errors = append(row.Errors, vevent.Errors...)
+
+		if vevent.RecurrenceID != nil {
+			row.Occurrence = database.OccurrenceKey(*vevent.RecurrenceID)
+			continue
+		}
+		if vevent.Status == "CANCELLED" {
+			row.Skipped = "the event is cancelled"
+			continue
+		}
+
+		event := &database.Event{ExDates: vevent.ExDates}
+		if vevent.Summary != "" {
+			event.Name = &vevent.Summary
+		}
+		if vevent.Description != "" {
+			event.Description = &vevent.Description
+		}
+		if vevent.Location != "" {
+			event.Location = &vevent.Location
+		}
+		if !vevent.Start.IsZero() {
+			start := vevent.Start
+			event.StartsAt = &start
+			event.EndsAt = vevent.End
+			// floating and UTC times both end up in UTC
+			zone := vevent.Start.Location().String()
+			event.Timezone = &zone
+		}
+		if vevent.RRule != "" {
+			event.RRule = &vevent.RRule
+		}
+		row.item = &database.EventImport{Event: event}
+	}
+
+	return rows, nil
+}
+
+// attachOccurrences turns the changed occurrences of an iCalendar import into
+// overrides of the series with the same UID, and cancelled ones into exdates
+func attachOccurrences(rows []*importRow) {
+	series := map[string]*importRow{}
+	for _, row := range rows {
+		if row.source == nil || row.source.RecurrenceID != nil {
+			continue
+		}
+		if first, ok := series[row.source.UID]; ok && row.source.UID != "" {
+			row.fail("UID %q is also used by the event on line %d", row.source.UID, first.Line)
+			continue
+		}
+		series[row.source.UID] = row
+	}
+
+	changed := map[string]int{}
+	for _, row := range rows {
+		if row.source == nil || row.source.RecurrenceID == nil {
+			continue
+		}
+		vevent := row.source
+
+		parent, ok := series[vevent.UID]
+		switch {
+		case !ok || vevent.UID == "":
+			row.fail("RECURRENCE-ID: no event in the file has UID %q", vevent.UID)
+			continue
+		case parent.Skipped != "":
+			row.Skipped = "its series is skipped"
+			continue
+		case len(parent.Errors) > 0:
+			row.fail("its series on line %d has errors", parent.Line)
+			continue
+		}
+
+		event := parent.item.Event
+		if !event.IsRecurring() {
+			row.fail("RECURRENCE-ID: the event on line %d does not repeat", parent.Line)
+			continue
+		}
+		rule, err := event.Rule()
+		if err != nil || !rule.Includes(event.FirstStart(), *vevent.RecurrenceID) {
+			row.fail("RECURRENCE-ID: %s is not an occurrence of the event on line %d", row.Occurrence, parent.Line)
+			continue
+		}
+		key := fmt.Sprintf("%d %s", parent.Line, row.Occurrence)
+		if line, ok := changed[key]; ok {
+			row.fail("RECURRENCE-ID: the occurrence is also changed on line %d", line)
+			continue
+		}
+		changed[key] = row.Line
+
+		if vevent.Status == "CANCELLED" {
+			event.ExDates = append(event.ExDates, *vevent.RecurrenceID)
+			continue
+		}
+
+		override := &database.OccurrenceOverride{Occurrence: row.Occurrence, Scope: database.OverrideThis}
+		if vevent.Summary != "" && vevent.Summary != *event.Name {
+			override.Name = &vevent.Summary
+		}
+		if vevent.Description != "" && vevent.Description != *event.Description {
+			override.Description = &vevent.Description
+		}
+		if vevent.Location != "" && vevent.Location != *event.Location {
+			override.Location = &vevent.Location
+		}
+		if !vevent.Start.IsZero() && !vevent.Start.Equal(*vevent.RecurrenceID) {
+			start := vevent.Start
+			override.StartsAt = &start
+		}
+		if vevent.End != nil && !vevent.Start.IsZero() && vevent.End.Sub(vevent.Start) != event.Duration() {
+			if !vevent.End.After(vevent.Start) {
+				row.fail("DTEND must be after DTSTART")
+				continue
+			}
+			override.EndsAt = vevent.End
+		}
+		if override.Name != nil || override.Description != nil || override.Location != nil || override.StartsAt != nil || override.EndsAt != nil {
+			parent.item.Overrides = append(parent.item.Overrides, override)
+		}
+	}
+}
+
+// checkImportNames fails rows whose name another row or an existing event already has
+func (h *EventHandler) checkImportNames(rows []*importRow) error {
+	names := []string{}
+	firstLine := map[string]int{}
+	for _, row := range rows {
+		if row.item == nil || row.item.Event.Name == nil {
+			continue
+		}
+		name := *row.item.Event.Name
+		if line, ok := firstLine[name]; ok {
+			row.fail("name is also used by the event on line %d", line)
+			continue
+		}
+		firstLine[name] = row.Line
+		names = append(names, name)
+	}
+
+	existing, err := h.Models.Events.ExistingNames(names)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row.item != nil && row.item.Event.Name != nil && existing[*row.item.Event.Name] {
+			row.fail("an event named %q already exists", *row.item.Event.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,253 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+const importHeader = "name,description,starts_at,ends_at,location"
+
+func TestCSVImportRows(t *testing.T) {
+	tests := []struct {
+		name    string
+		csv     string
+		wantErr string
+		// errors per row, after validateEvent
+		wantRows [][]string
+	}{
+		{
+			name:     "required columns only",
+			csv:      importHeader + "\nParty,Cake and music,2026-05-01T18:00:00Z,2026-05-01T22:00:00Z,Hall\n",
+			wantRows: [][]string{nil},
+		},
+		{
+			name: "columns in any order with optional ones",
+			csv: "location,ends_at,timezone,name,starts_at,description,capacity,exdates\n" +
+				"Hall,2026-05-01 22:00,Europe/Berlin,Party,2026-05-01 18:00,Cake and music,20,\n",
+			wantRows: [][]string{nil},
+		},
+		{
+			name:     "bad values are reported per row",
+			csv:      importHeader + ",capacity\nParty,Cake and music,tomorrow,2026-05-01T22:00:00Z,Hall,lots\n",
+			wantRows: [][]string{{`starts_at: "tomorrow" is neither an RFC 3339 time nor a local one like 2006-01-02 15:04`, `capacity: "lots" is not a whole number`, "startsAt is required"}},
+		},
+		{
+			name:     "short row",
+			csv:      importHeader + "\nParty,Cake and music\n",
+			wantRows: [][]string{{"has 2 fields but the header has 5"}},
+		},
+		{
+			name:    "header without a required column",
+			csv:     "name,starts_at,ends_at,location\nParty,2026-05-01T18:00:00Z,2026-05-01T22:00:00Z,Hall\n",
+			wantErr: "the header row has no description column; name, description, starts_at, ends_at and location are required",
+		},
+		{
+			name:    "unknown column",
+			csv:     importHeader + ",colour\n",
+			wantErr: `unknown column "colour"; columns are name, description, starts_at, ends_at, timezone, location, capacity, rrule and exdates`,
+		},
+		{
+			name:    "empty file",
+			csv:     "",
+			wantErr: "the file is empty",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := csvImportRows([]byte(tt.csv))
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(rows) != len(tt.wantRows) {
+				t.Fatalf("got %d rows, want %d", len(rows), len(tt.wantRows))
+			}
+
+			for i, row := range rows {
+				if row.item != nil {
+					row.Errors = append(row.Errors, validateEvent(row.item.Event)...)
+				}
+				if !slices.Equal(row.Errors, tt.wantRows[i]) {
+					t.Errorf("row %d errors = %q, want %q", i, row.Errors, tt.wantRows[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCSVImportLocalTimes(t *testing.T) {
+	rows, err := csvImportRows([]byte(importHeader + ",timezone\nParty,Cake and music,2026-05-01 18:00,2026-05-01 22:00,Hall,Europe/Berlin\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := time.Date(2026, 5, 1, 16, 0, 0, 0, time.UTC)
+	if got := rows[0].item.Event.StartsAt; got == nil || !got.Equal(want) {
+		t.Errorf("starts at %v, want %v", got, want)
+	}
+}
+
+func TestICSImportOverrides(t *testing.T) {
+	series := []string{
+		"BEGIN:VCALENDAR", "VERSION:2.0",
+		"BEGIN:VEVENT", "UID:standup", "SUMMARY:Standup", "DESCRIPTION:Daily sync", "LOCATION:Room 1",
+		"DTSTART:20260504T090000Z", "DTEND:20260504T091500Z", "RRULE:FREQ=DAILY;COUNT=5", "END:VEVENT",
+	}
+
+	tests := []struct {
+		name       string
+		occurrence []string
+		check      func(t *testing.T, item *database.EventImport)
+	}{
+		{
+			name:       "only the end changes",
+			occurrence: []string{"DTSTART:20260505T090000Z", "DTEND:20260505T100000Z"},
+			check: func(t *testing.T, item *database.EventImport) {
+				if len(item.Overrides) != 1 {
+					t.Fatalf("got %d overrides, want 1", len(item.Overrides))
+				}
+				want := time.Date(2026, 5, 5, 10, 0, 0, 0, time.UTC)
+				if o := item.Overrides[0]; o.EndsAt == nil || !o.EndsAt.Equal(want) || o.StartsAt != nil || o.Name != nil {
+					t.Errorf("override = %+v, want only the end at %v", o, want)
+				}
+			},
+		},
+		{
+			name:       "moved and renamed",
+			occurrence: []string{"SUMMARY:Long standup", "DTSTART:20260505T093000Z", "DTEND:20260505T094500Z"},
+			check: func(t *testing.T, item *database.EventImport) {
+				if len(item.Overrides) != 1 {
+					t.Fatalf("got %d overrides, want 1", len(item.Overrides))
+				}
+				o := item.Overrides[0]
+				if o.Name == nil || *o.Name != "Long standup" || o.StartsAt == nil || o.EndsAt != nil {
+					t.Errorf("override = %+v, want a new name and start with the usual length", o)
+				}
+			},
+		},
+		{
+			name:       "unchanged",
+			occurrence: []string{"DTSTART:20260505T090000Z", "DTEND:20260505T091500Z"},
+			check: func(t *testing.T, item *database.EventImport) {
+				if len(item.Overrides) != 0 {
+					t.Errorf("got %d overrides, want none", len(item.Overrides))
+				}
+			},
+		},
+		{
+			name:       "cancelled",
+			occurrence: []string{"STATUS:CANCELLED"},
+			check: func(t *testing.T, item *database.EventImport) {
+				want := time.Date(2026, 5, 5, 9, 0, 0, 0, time.UTC)
+				if len(item.Overrides) != 0 || len(item.Event.ExDates) != 1 || !item.Event.ExDates[0].Equal(want) {
+					t.Errorf("overrides %d, exdates %v; want only the exdate %v", len(item.Overrides), item.Event.ExDates, want)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := append(slices.Clone(series), "BEGIN:VEVENT", "UID:standup", "RECURRENCE-ID:20260505T090000Z")
+			lines = append(lines, tt.occurrence...)
+			lines = append(lines, "END:VEVENT", "END:VCALENDAR")
+
+			rows, err := icsImportRows([]byte(strings.Join(lines, "\r\n")))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, row := range rows {
+				if row.item != nil {
+					row.Errors = append(row.Errors, validateEvent(row.item.Event)...)
+				}
+			}
+			attachOccurrences(rows)
+
+			for _, row := range rows {
+				if len(row.Errors) > 0 {
+					t.Fatalf("line %d: %q", row.Line, row.Errors)
+				}
+			}
+			tt.check(t, rows[0].item)
+		})
+	}
+}
+
+func TestImportDryRunAndCommit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	owner := &database.User{Username: "importer", Email: "importer@example.com", Password: "x"}
+	if err := models.Users.Insert(owner); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &EventHandler{Models: models}
+	router := gin.New()
+	router.POST("/events/import", func(c *gin.Context) { c.Set("user", owner) }, h.ImportEvents)
+
+	valid := importHeader + "\nParty,Cake and music,2026-05-01T18:00:00Z,2026-05-01T22:00:00Z,Hall\n" +
+		"Picnic,Sandwiches,2026-06-01T12:00:00Z,2026-06-01T15:00:00Z,Park\n"
+	invalid := importHeader + "\nQuiz,Questions,2026-07-01T19:00:00Z,2026-07-01T18:00:00Z,Pub\n"
+
+	tests := []struct {
+		name      string
+		query     string
+		body      string
+		wantCode  int
+		wantSaved []string
+	}{
+		{"dry run saves nothing", "?dryRun=true", valid, http.StatusOK, nil},
+		{"dry run reports bad rows", "?dryRun=true", invalid, http.StatusOK, nil},
+		{"bad rows save nothing", "", invalid, http.StatusUnprocessableEntity, nil},
+		{"commit saves every row", "", valid, http.StatusCreated, []string{"Party", "Picnic"}},
+		{"names are taken now", "", valid, http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tt := range tests {
+		before, err := models.Events.ExistingNames([]string{"Party", "Picnic", "Quiz"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		req := httptest.NewRequest(http.MethodPost, "/events/import"+tt.query, strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "text/csv")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Fatalf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.wantCode, rec.Body.String())
+		}
+
+		var response struct {
+			Rows []importRow `json:"rows"`
+		}
+		if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil || len(response.Rows) == 0 {
+			t.Fatalf("%s: no rows in %s", tt.name, rec.Body.String())
+		}
+
+		after, err := models.Events.ExistingNames([]string{"Party", "Picnic", "Quiz"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"Party", "Picnic", "Quiz"} {
+			want := before[name] || slices.Contains(tt.wantSaved, name)
+			if after[name] != want {
+				t.Errorf("%s: event %q saved = %v, want %v", tt.name, name, after[name], want)
+			}
+		}
+	}
+}
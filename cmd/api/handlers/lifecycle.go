@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/mailer"
+)
+
+// PublishEvent makes a draft event public
+//
+//	@Summary		Publishes an event
+//	@Description	Moves a draft event to published, so everyone can see it and register
+//	@Tags			events
+//	@Produce		json
+//	@Param			id	path		int	true	"Event ID"
+//	@Success		200	{object}	database.Event
+//	@Router			/api/v1/events/{id}/publish [post]
+//	@Security		BearerAuth
+
+func (h *EventHandler) PublishEvent(c *gin.Context) {
+	event, ok := h.lifecycleEvent(c)
+	if !ok {
+		return
+	}
+	if !h.setEventStatus(c, event, database.EventPublished) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Event published", "event": event})
+}
+
+type cancelEventRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// CancelEvent cancels an event and tells its attendees
+//
+//	@Summary		Cancels an event
+//	@Description	Moves a draft or published event to cancelled. Registrations are kept but no new ones are taken, and everyone registered who has not declined is emailed, with the reason when one is given.
+//	@Tags			events
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"Event ID"
+//	@Param			body	body		cancelEventRequest	false	"Why the event is cancelled"
+//	@Success		200		{object}	database.Event
+//	@Router			/api/v1/events/{id}/cancel [post]
+//	@Security		BearerAuth
+
+func (h *EventHandler) CancelEvent(c *gin.Context) {
+	var request cancelEventRequest
+	if err := c.ShouldBindJSON(&request); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request", "detail": err.Error()})
+		return
+	}
+
+	event, ok := h.lifecycleEvent(c)
+	if !ok {
+		return
+	}
+	if !h.setEventStatus(c, event, database.EventCancelled) {
+		return
+	}
+
+	notified, err := h.notifyCancellation(c, event, strings.TrimSpace(request.Reason))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Event cancelled but failed to notify attendees", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":            "ok",
+		"message":           "Event cancelled",
+		"event":             event,
+		"notifiedAttendees": notified,
+	})
+}
+
+// CompleteEvent marks an event that has taken place as completed
+//
+//	@Summary		Completes an event
+//	@Description	Moves a published event that has started to completed
+//	@Tags			events
+//	@Produce		json
+//	@Param			id	path		int	true	"Event ID"
+//	@Success		200	{object}	database.Event
+//	@Router			/api/v1/events/{id}/complete [post]
+//	@Security		BearerAuth
+
+func (h *EventHandler) CompleteEvent(c *gin.Context) {
+	event, ok := h.lifecycleEvent(c)
+	if !ok {
+		return
+	}
+	if event.StartsAt == nil || event.StartsAt.After(time.Now()) {
+		c.JSON(http.StatusConflict, gin.H{"error": "An event cannot be completed before it starts"})
+		return
+	}
+	if !h.setEventStatus(c, event, database.EventCompleted) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Event completed", "event": event})
+}
+
+// lifecycleEvent loads the event a status change is for, which the user must be able to manage
+func (h *EventHandler) lifecycleEvent(c *gin.Context) (*database.Event, bool) {
+	id, err := eventParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID", "detail": err.Error()})
+		return nil, false
+	}
+
+	event, err := h.Models.Events.GET(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event", "detail": err.Error()})
+		return nil, false
+	}
+	contextUser := utils.RetrieveUserFromContext(c)
+	if event == nil || !event.VisibleTo(contextUser) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return nil, false
+	}
+	if !canManageEvent(contextUser, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the owner of the event"})
+		return nil, false
+	}
+
+	return event, true
+}
+
+// setEventStatus moves the event along its lifecycle, answering 409 when the
+// lifecycle does not allow it
+func (h *EventHandler) setEventStatus(c *gin.Context, event *database.Event, status string) bool {
+	if !database.CanTransitionEvent(event.Status, status) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("A %s event cannot be %s", event.Status, status)})
+		return false
+	}
+
+	if err := h.Models.Events.SetStatus(event, status); err != nil {
+		if errors.Is(err, database.ErrEventStatusChanged) {
+			c.JSON(http.StatusConflict, gin.H{"error": "The event's status was changed by someone else; try again"})
+			return false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update event status", "detail": err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// notifyCancellation emails everyone still expecting to attend a cancelled
+// event and returns how many were sent. The event is cancelled either way,
+// so an email that fails is logged rather than reported.
+func (h *EventHandler) notifyCancellation(c *gin.Context, event *database.Event, reason string) (int, error) {
+	attendees, err := h.Models.Attendees.GetAttendeesToNotify(event.Id)
+	if err != nil {
+		return 0, err
+	}
+
+	name := ""
+	if event.Name != nil {
+		name = *event.Name
+	}
+	what := fmt.Sprintf("%q, starting %s,", name, event.StartsAt.Format("Monday 2 January 2006 at 15:04 MST"))
+	if event.IsRecurring() {
+		what = fmt.Sprintf("Every occurrence of %q", name)
+	}
+	body := fmt.Sprintf("%s has been cancelled by its organizer.\n", what)
+	if reason != "" {
+		body += fmt.Sprintf("\nReason: %s\n", reason)
+	}
+	body += "\nThere is nothing you need to do.\n"
+
+	sent := 0
+	for _, user := range attendees {
+		err := h.Mailer.Send(c.Request.Context(), mailer.Message{
+			To:      user.Email,
+			Subject: fmt.Sprintf("Cancelled: %s", name),
+			Body:    fmt.Sprintf("Hi %s,\n\n%s", user.Username, body),
+		})
+		if err != nil {
+			log.Printf("failed to send cancellation notice for event %d to user %d: %v", event.Id, user.ID, err)
+			continue
+		}
+		sent++
+	}
+
+	return sent, nil
+}
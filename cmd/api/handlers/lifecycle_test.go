@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+func newLifecycleRouter(models database.Models, caller *database.User, mail *outbox) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+
+	h := &EventHandler{Models: models, Mailer: mail}
+	router := gin.New()
+	events := router.Group("/events", func(c *gin.Context) { c.Set("user", caller) })
+	events.POST("/:id/publish", h.PublishEvent)
+	events.POST("/:id/cancel", h.CancelEvent)
+	events.POST("/:id/complete", h.CompleteEvent)
+	return router
+}
+
+func TestEventLifecycle(t *testing.T) {
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+	router := newLifecycleRouter(models, owner, &outbox{})
+
+	started, upcoming := time.Now().Add(-time.Hour), time.Now().Add(24*time.Hour)
+	tests := []struct {
+		from       string
+		start      time.Time
+		action     string
+		wantCode   int
+		wantStatus string
+	}{
+		{database.EventDraft, upcoming, "publish", http.StatusOK, database.EventPublished},
+		{database.EventDraft, upcoming, "cancel", http.StatusOK, database.EventCancelled},
+		{database.EventDraft, started, "complete", http.StatusConflict, database.EventDraft},
+		{database.EventPublished, upcoming, "publish", http.StatusConflict, database.EventPublished},
+		{database.EventPublished, upcoming, "cancel", http.StatusOK, database.EventCancelled},
+		{database.EventPublished, started, "complete", http.StatusOK, database.EventCompleted},
+		// an event that has not started yet cannot be over
+		{database.EventPublished, upcoming, "complete", http.StatusConflict, database.EventPublished},
+		{database.EventCancelled, upcoming, "publish", http.StatusConflict, database.EventCancelled},
+		{database.EventCancelled, started, "complete", http.StatusConflict, database.EventCancelled},
+		{database.EventCompleted, started, "cancel", http.StatusConflict, database.EventCompleted},
+		{database.EventCompleted, started, "publish", http.StatusConflict, database.EventCompleted},
+	}
+
+	for i, tt := range tests {
+		name := fmt.Sprintf("%s %s", tt.action, tt.from)
+		event := insertEvent(t, models, owner, fmt.Sprintf("event %d", i), tt.from, tt.start)
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/events/%d/%s", event.Id, tt.action), nil))
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s: got %d, want %d: %s", name, rec.Code, tt.wantCode, rec.Body.String())
+		}
+
+		saved, err := models.Events.GET(event.Id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Status != tt.wantStatus {
+			t.Errorf("%s: status %s, want %s", name, saved.Status, tt.wantStatus)
+		}
+	}
+}
+
+func TestEventLifecycleNeedsOwner(t *testing.T) {
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+	stranger := insertUser(t, models, "stranger")
+	draft := insertEvent(t, models, owner, "draft", database.EventDraft, time.Now().Add(time.Hour))
+	published := insertEvent(t, models, owner, "published", database.EventPublished, time.Now().Add(time.Hour))
+
+	router := newLifecycleRouter(models, stranger, &outbox{})
+	tests := []struct {
+		name  string
+		event *database.Event
+		want  int
+	}{
+		// someone else's draft does not exist as far as they can tell
+		{"draft", draft, http.StatusNotFound},
+		{"published", published, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/events/%d/cancel", tt.event.Id), nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
+
+func TestSetEventStatusLosesRace(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+	event := insertEvent(t, models, owner, "Party", database.EventPublished, time.Now().Add(time.Hour))
+
+	// another request cancels the event after this one loaded it
+	stale := *event
+	if err := models.Events.SetStatus(event, database.EventCancelled); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &EventHandler{Models: models}
+	rec := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(rec)
+	if h.setEventStatus(c, &stale, database.EventCompleted) {
+		t.Fatal("status changed from a stale read")
+	}
+	if rec.Code != http.StatusConflict {
+		t.Errorf("got %d, want 409: %s", rec.Code, rec.Body.String())
+	}
+
+	saved, err := models.Events.GET(event.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Status != database.EventCancelled {
+		t.Errorf("status %s, want %s", saved.Status, database.EventCancelled)
+	}
+}
+
+func TestCancelEventNotifiesAttendees(t *testing.T) {
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+	event := insertEvent(t, models, owner, "Party", database.EventPublished, time.Now().Add(time.Hour))
+
+	going := insertUser(t, models, "going")
+	declined := insertUser(t, models, "declined")
+	for _, guest := range []*database.User{going, declined} {
+		if _, err := models.Attendees.Register(event.Id, "", guest.ID, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, _, err := models.Attendees.UpdateStatus(event.Id, "", declined.ID, database.StatusDeclined); err != nil {
+		t.Fatal(err)
+	}
+
+	mail := &outbox{}
+	router := newLifecycleRouter(models, owner, mail)
+	req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/events/%d/cancel", event.Id), strings.NewReader(`{"reason": "The hall flooded"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	var response struct {
+		Notified int `json:"notifiedAttendees"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Notified != 1 || len(mail.messages) != 1 {
+		t.Fatalf("notified %d, sent %d messages; want 1 to %s", response.Notified, len(mail.messages), going.Email)
+	}
+	msg := mail.messages[0]
+	if msg.To != going.Email || msg.Subject != "Cancelled: Party" || !strings.Contains(msg.Body, "Reason: The hall flooded") {
+		t.Errorf("message = %+v", msg)
+	}
+}
+
+func TestCancelEventWhenMailFails(t *testing.T) {
+	models := database.NewModels(dbtest.New(t))
+	owner := insertUser(t, models, "owner")
+	event := insertEvent(t, models, owner, "Party", database.EventPublished, time.Now().Add(time.Hour))
+	guest := insertUser(t, models, "guest")
+	if _, err := models.Attendees.Register(event.Id, "", guest.ID, false); err != nil {
+		t.Fatal(err)
+	}
+
+	// the event is cancelled all the same; the failed notice is only logged
+	router := newLifecycleRouter(models, owner, &outbox{err: errors.New("smtp down")})
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, fmt.Sprintf("/events/%d/cancel", event.Id), nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"notifiedAttendees":0`) {
+		t.Errorf("got %d: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/lockout"
+)
+
+// tooManyAttempts tells a locked-out client when it may try again
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error":      "Too many failed login attempts; try again later",
+		"retryAfter": seconds,
+	})
+}
+
+// loginFailed counts a wrong password (or unknown email) and answers it. user is
+// nil when no account has this email; it is locked out all the same, so the
+// response never reveals whether the account exists.
+func (h *AuthHandler) loginFailed(c *gin.Context, email string, user *database.User) {
+	lock := h.recordLoginFailure(c, email, user)
+	if lock == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		return
+	}
+
+	tooManyAttempts(c, lock.Duration)
+}
+
+// recordLoginFailure counts a wrong password or second-factor code against the
+// email and client IP, auditing the lockout it triggers, if any
+func (h *AuthHandler) recordLoginFailure(c *gin.Context, email string, user *database.User) *lockout.Lockout {
+	lock, err := h.LoginGuard.RecordFailure(redisclient.Ctx, email, c.ClientIP())
+	if err != nil {
+		log.Printf("failed to record login failure: %v", err)
+	}
+	if lock == nil {
+		return nil
+	}
+
+	entry := &database.AuditEntry{
+		Action: database.AuditLoginLockout,
+		IP:     c.ClientIP(),
+		Detail: fmt.Sprintf("%s locked out for %s after repeated failed logins for %s (lockout %d today)", lock.Subject, lock.Duration, email, lock.Count),
+	}
+	if user != nil {
+		entry.TargetUserId = &user.ID
+	}
+	if err := h.Models.Audit.Record(entry); err != nil {
+		log.Printf("failed to record audit entry: %v", err)
+	}
+
+	return lock
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+	"github.com/muhamash/go-first-rest-api/internal/jwtkeys"
+	"github.com/muhamash/go-first-rest-api/internal/lockout"
+	"github.com/muhamash/go-first-rest-api/internal/mailer"
+	"github.com/muhamash/go-first-rest-api/internal/session"
+	"github.com/redis/go-redis/v9"
+)
+
+// outbox keeps every message instead of sending it, or fails when err is set
+type outbox struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+	err      error
+}
+
+func (o *outbox) Send(ctx context.Context, msg mailer.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.err != nil {
+		return o.err
+	}
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+var magicLinkToken = regexp.MustCompile(`token=(\S+)`)
+
+func newMagicLinkRouter(t *testing.T, mail *outbox) (*gin.Engine, database.Models) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	models := database.NewModels(dbtest.New(t))
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	keys, err := jwtkeys.Ephemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &AuthHandler{
+		Models:     models,
+		Keys:       keys,
+		Redis:      rdb,
+		Sessions:   &session.Store{Redis: rdb, TTL: time.Hour},
+		LoginGuard: &lockout.Guard{Redis: rdb, Policy: lockout.Policy{MaxAttempts: 5, Window: time.Minute, LockoutBase: time.Minute, LockoutMax: time.Hour}},
+		Mailer:     mail,
+		AppURL:     "http://app.test",
+	}
+	router := gin.New()
+	router.POST("/magic-link", h.SendMagicLink)
+	router.POST("/magic-link/verify", h.MagicLinkLogin)
+
+	user := &database.User{Username: "linked", Email: "linked@example.com", Password: "x"}
+	if err := models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	return router, models
+}
+
+func TestMagicLinkWorksOnce(t *testing.T) {
+	mail := &outbox{}
+	router, _ := newMagicLinkRouter(t, mail)
+
+	rec := postJSON(t, router, "/magic-link", gin.H{"email": "linked@example.com"})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("request link: got %d: %s", rec.Code, rec.Body.String())
+	}
+	if len(mail.messages) != 1 {
+		t.Fatalf("sent %d messages, want 1", len(mail.messages))
+	}
+	match := magicLinkToken.FindStringSubmatch(mail.messages[0].Body)
+	if match == nil {
+		t.Fatalf("no token in %q", mail.messages[0].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rec = postJSON(t, router, "/magic-link/verify", gin.H{"token": token})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("first use: got %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = postJSON(t, router, "/magic-link/verify", gin.H{"token": token})
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("replay: got %d, want 401: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMagicLinkRateLimitPerEmail(t *testing.T) {
+	mail := &outbox{}
+	router, _ := newMagicLinkRouter(t, mail)
+
+	for _, email := range []string{"linked@example.com", "nobody@example.com"} {
+		for i := 1; i <= magicLinkLimit+1; i++ {
+			rec := postJSON(t, router, "/magic-link", gin.H{"email": email})
+			want := http.StatusOK
+			if i > magicLinkLimit {
+				want = http.StatusTooManyRequests
+			}
+			if rec.Code != want {
+				t.Fatalf("%s request %d: got %d, want %d: %s", email, i, rec.Code, want, rec.Body.String())
+			}
+			if want == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+				t.Errorf("%s request %d: no Retry-After header", email, i)
+			}
+		}
+	}
+
+	if len(mail.messages) != magicLinkLimit {
+		t.Errorf("sent %d messages, want %d", len(mail.messages), magicLinkLimit)
+	}
+}
+
+func TestMagicLinkSameResponseForUnknownEmail(t *testing.T) {
+	tests := []struct {
+		name    string
+		mailErr error
+	}{
+		{"mail delivered", nil},
+		{"mail failed", errors.New("smtp: connection refused")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router, _ := newMagicLinkRouter(t, &outbox{err: tt.mailErr})
+
+			known := postJSON(t, router, "/magic-link", gin.H{"email": "linked@example.com"})
+			unknown := postJSON(t, router, "/magic-link", gin.H{"email": "nobody@example.com"})
+
+			if known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+				t.Errorf("registered: %d %s; unknown: %d %s", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,361 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/totp"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	mfaEnrollmentTTL   = 10 * time.Minute
+	mfaChallengeTTL    = 5 * time.Minute
+	mfaChallengeTries  = 5
+	recoveryCodeCount  = 10
+	recoveryCodeLength = 8
+)
+
+type mfaCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type mfaVerifyRequest struct {
+	MFAToken string `json:"mfa_token" binding:"required"`
+	// Code is a code from the authenticator app or one of the recovery codes
+	Code string `json:"code" binding:"required"`
+}
+
+type mfaChallengeResponse struct {
+	Status    string `json:"status"`
+	MFAToken  string `json:"mfa_token"`
+	ExpiresIn int    `json:"expiresIn"`
+}
+
+func mfaChallengeKey(token string) string {
+	return fmt.Sprintf("mfa_challenge:%s", utils.HashToken(token))
+}
+
+// startMFAChallenge answers a correct password with a short-lived challenge
+// instead of tokens; the session only starts once a second factor is shown
+func (h *AuthHandler) startMFAChallenge(c *gin.Context, user *database.User, device string) {
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor challenge"})
+		return
+	}
+
+	key := mfaChallengeKey(token)
+	pipe := h.Redis.TxPipeline()
+	pipe.HSet(redisclient.Ctx, key, "user_id", user.ID, "device", device, "attempts", 0)
+	pipe.Expire(redisclient.Ctx, key, mfaChallengeTTL)
+	if _, err := pipe.Exec(redisclient.Ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start two-factor challenge"})
+		return
+	}
+
+	c.JSON(http.StatusOK, mfaChallengeResponse{
+		Status:    "mfa_required",
+		MFAToken:  token,
+		ExpiresIn: int(mfaChallengeTTL.Seconds()),
+	})
+}
+
+// VerifyMFA completes a login that answered with mfa_required
+//
+//	@Summary		Completes a two-factor login
+//	@Description	Exchanges the challenge token from login plus an authenticator or recovery code for the usual tokens
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		mfaVerifyRequest	true	"Challenge and code"
+//	@Success		200		{object}	loginResponse
+//	@Router			/api/v1/auth/mfa/verify [post]
+
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req mfaVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := mfaChallengeKey(req.MFAToken)
+	challenge, err := h.Redis.HGetAll(redisclient.Ctx, key).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load two-factor challenge"})
+		return
+	}
+	if len(challenge) == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor challenge is invalid or has expired; please log in again"})
+		return
+	}
+
+	// every guess counts, so the challenge cannot be used to brute force six digits
+	attempts, err := h.Redis.HIncrBy(redisclient.Ctx, key, "attempts", 1).Result()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load two-factor challenge"})
+		return
+	}
+	if attempts > mfaChallengeTries {
+		h.Redis.Del(redisclient.Ctx, key)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Too many incorrect codes; please log in again"})
+		return
+	}
+
+	var userID int
+	fmt.Sscan(challenge["user_id"], &userID)
+
+	user, err := h.Models.Users.Get(userID)
+	if err != nil || user == nil {
+		h.Redis.Del(redisclient.Ctx, key)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor challenge is invalid or has expired; please log in again"})
+		return
+	}
+
+	// a lockout earned on another challenge stops this one too
+	wait, err := h.LoginGuard.RetryAfter(redisclient.Ctx, user.Email, c.ClientIP())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check login attempts"})
+		return
+	}
+	if wait > 0 {
+		h.Redis.Del(redisclient.Ctx, key)
+		tooManyAttempts(c, wait)
+		return
+	}
+
+	ok, err := h.verifyMFACode(user.ID, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code", "detail": err.Error()})
+		return
+	}
+	if !ok {
+		// wrong codes count towards the same lockout as wrong passwords, so
+		// logging in again for a fresh challenge buys no extra guesses
+		if lock := h.recordLoginFailure(c, user.Email, user); lock != nil {
+			h.Redis.Del(redisclient.Ctx, key)
+			tooManyAttempts(c, lock.Duration)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	// one challenge, one session: a second request with the same token loses the race here
+	if deleted, err := h.Redis.Del(redisclient.Ctx, key).Result(); err != nil || deleted == 0 {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Two-factor challenge is invalid or has expired; please log in again"})
+		return
+	}
+
+	// the account may have been suspended while the code was being typed
+	h.completeLogin(c, user, challenge["device"], true)
+}
+
+// EnrollMFA starts two-factor enrollment
+//
+//	@Summary		Starts two-factor enrollment
+//	@Description	Generates a TOTP secret and otpauth URI; nothing changes until it is confirmed with a first code
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/auth/mfa/enroll [post]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) EnrollMFA(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	existing, err := h.Models.MFA.GetSecret(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check two-factor status", "detail": err.Error()})
+		return
+	}
+	if existing != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Two-factor authentication is already enabled"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate secret"})
+		return
+	}
+
+	key := fmt.Sprintf("mfa_enroll:user:%d", user.ID)
+	if err := h.Redis.Set(redisclient.Ctx, key, secret, mfaEnrollmentTTL).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start enrollment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":     "ok",
+		"secret":     secret,
+		"otpauthUri": totp.URI(h.MFAIssuer, user.Email, secret),
+		"expiresIn":  int(mfaEnrollmentTTL.Seconds()),
+	})
+}
+
+// ConfirmMFA finishes enrollment with the first code from the authenticator
+//
+//	@Summary		Confirms two-factor enrollment
+//	@Description	Turns two-factor authentication on and returns one-time recovery codes, shown only this once
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		mfaCodeRequest	true	"Code"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/auth/mfa/confirm [post]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) ConfirmMFA(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	key := fmt.Sprintf("mfa_enroll:user:%d", user.ID)
+	secret, err := h.Redis.Get(redisclient.Ctx, key).Result()
+	if err == redis.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No enrollment in progress; start again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load enrollment"})
+		return
+	}
+
+	if _, ok := totp.Validate(secret, req.Code, time.Now(), 1); !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	codes, stored, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate recovery codes"})
+		return
+	}
+
+	if err := h.Models.MFA.Enable(user.ID, secret, stored); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable two-factor authentication", "detail": err.Error()})
+		return
+	}
+	h.Redis.Del(redisclient.Ctx, key)
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":        "ok",
+		"message":       "Two-factor authentication enabled; store the recovery codes somewhere safe",
+		"recoveryCodes": codes,
+	})
+}
+
+// DisableMFA turns two-factor authentication off
+//
+//	@Summary		Disables two-factor authentication
+//	@Description	Requires a current authenticator or recovery code
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		mfaCodeRequest	true	"Code"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/auth/mfa [delete]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) DisableMFA(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	var req mfaCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ok, err := h.verifyMFACode(user.ID, req.Code)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify code", "detail": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid code"})
+		return
+	}
+
+	if err := h.Models.MFA.Disable(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable two-factor authentication", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Two-factor authentication disabled"})
+}
+
+// verifyMFACode accepts a current authenticator code or an unused recovery code.
+// It reports false, without an error, when the user has no second factor.
+func (h *AuthHandler) verifyMFACode(userID int, code string) (bool, error) {
+	secret, err := h.Models.MFA.GetSecret(userID)
+	if err != nil || secret == "" {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(secret, code, time.Now(), 1); ok {
+		// a code is only good once, even while it is still inside its window
+		key := fmt.Sprintf("mfa_used:user:%d:%d", userID, step)
+		fresh, err := h.Redis.SetNX(redisclient.Ctx, key, 1, 3*totp.Period).Result()
+		if err != nil {
+			return false, err
+		}
+		return fresh, nil
+	}
+
+	normalised := normaliseRecoveryCode(code)
+	if len(normalised) != recoveryCodeLength {
+		return false, nil
+	}
+
+	used, err := h.Models.MFA.UseRecoveryCode(userID, normalised)
+	if err != nil {
+		return false, err
+	}
+	if used {
+		if remaining, err := h.Models.MFA.RemainingRecoveryCodes(userID); err == nil && remaining <= 2 {
+			log.Printf("user %d has %d recovery codes left", userID, remaining)
+		}
+	}
+
+	return used, nil
+}
+
+// generateRecoveryCodes returns codes formatted for the user plus the normalised
+// codes the MFA model hashes and stores
+func generateRecoveryCodes() ([]string, []string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+
+	codes := make([]string, 0, recoveryCodeCount)
+	stored := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(encoding.EncodeToString(b))
+
+		codes = append(codes, code[:4]+"-"+code[4:])
+		stored = append(stored, code)
+	}
+
+	return codes, stored, nil
+}
+
+func normaliseRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+	"github.com/muhamash/go-first-rest-api/internal/lockout"
+	"github.com/muhamash/go-first-rest-api/internal/totp"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var testMFAKey = bytes.Repeat([]byte{7}, database.MFAKeySize)
+
+func postJSON(t *testing.T, router *gin.Engine, path string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestWrongMFACodesLockOutAcrossLogins(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	models.MFA.Key = testMFAKey
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	const email, password = "mfa@example.com", "correct horse battery"
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &database.User{Username: "mfa", Email: email, Password: string(hash)}
+	if err := models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.MFA.Enable(user.ID, secret, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a code from long ago is well outside the allowed clock skew
+	wrongCode, err := totp.Code(secret, totp.Step(time.Now())-1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &AuthHandler{
+		Models: models,
+		Redis:  rdb,
+		LoginGuard: &lockout.Guard{Redis: rdb, Policy: lockout.Policy{
+			MaxAttempts: 5,
+			Window:      15 * time.Minute,
+			LockoutBase: time.Minute,
+			LockoutMax:  time.Hour,
+		}},
+	}
+	router := gin.New()
+	router.POST("/login", h.LoginUser)
+	router.POST("/mfa/verify", h.VerifyMFA)
+
+	// log in afresh before every guess, which used to reset the count
+	for guess := 1; guess <= 5; guess++ {
+		rec := postJSON(t, router, "/login", gin.H{"email": email, "password": password})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("login before guess %d: got %d: %s", guess, rec.Code, rec.Body.String())
+		}
+		var challenge mfaChallengeResponse
+		if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil || challenge.MFAToken == "" {
+			t.Fatalf("login before guess %d: no challenge: %s", guess, rec.Body.String())
+		}
+
+		rec = postJSON(t, router, "/mfa/verify", gin.H{"mfa_token": challenge.MFAToken, "code": wrongCode})
+		want := http.StatusUnauthorized
+		if guess == 5 {
+			want = http.StatusTooManyRequests
+		}
+		if rec.Code != want {
+			t.Fatalf("guess %d: got %d, want %d: %s", guess, rec.Code, want, rec.Body.String())
+		}
+	}
+
+	rec := postJSON(t, router, "/login", gin.H{"email": email, "password": password})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("login after lockout: got %d, want 429: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestMFAVerifyRefusesSuspendedAccount(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	models.MFA.Key = testMFAKey
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+
+	const email, password = "suspended@example.com", "correct horse battery"
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user := &database.User{Username: "suspended", Email: email, Password: string(hash)}
+	if err := models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := models.MFA.Enable(user.ID, secret, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &AuthHandler{
+		Models:     models,
+		Redis:      rdb,
+		LoginGuard: &lockout.Guard{Redis: rdb, Policy: lockout.Policy{MaxAttempts: 5, Window: time.Minute, LockoutBase: time.Minute, LockoutMax: time.Hour}},
+	}
+	router := gin.New()
+	router.POST("/login", h.LoginUser)
+	router.POST("/mfa/verify", h.VerifyMFA)
+
+	rec := postJSON(t, router, "/login", gin.H{"email": email, "password": password})
+	var challenge mfaChallengeResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &challenge); err != nil || challenge.MFAToken == "" {
+		t.Fatalf("login: no challenge: %d %s", rec.Code, rec.Body.String())
+	}
+
+	// suspended between the password and the code
+	if err := models.Users.Suspend(user.ID, "abuse"); err != nil {
+		t.Fatal(err)
+	}
+
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = postJSON(t, router, "/mfa/verify", gin.H{"mfa_token": challenge.MFAToken, "code": code})
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("verify: got %d, want 403: %s", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,397 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/recurrence"
+)
+
+// how far ahead an event's occurrences are listed when no window is given
+const defaultOccurrenceWindow = 90 * 24 * time.Hour
+
+type updateOccurrenceRequest struct {
+	// Scope is "this" (the default) or "following" for this and every later occurrence
+	Scope       string     `json:"scope"`
+	Name        *string    `json:"name"`
+	Description *string    `json:"description"`
+	StartsAt    *time.Time `json:"startsAt"`
+	EndsAt      *time.Time `json:"endsAt"`
+	Location    *string    `json:"location"`
+	Capacity    *int       `json:"capacity" binding:"omitempty,min=1"`
+}
+
+// normalizeRecurrence validates an event's RRULE and EXDATEs and rewrites the
+// rule in canonical form
+func normalizeRecurrence(event *database.Event) error {
+	if !event.IsRecurring() {
+		if len(event.ExDates) > 0 {
+			return errors.New("exdates only apply to events with an rrule")
+		}
+		return nil
+	}
+	if event.StartsAt == nil {
+		return errors.New("a recurring event needs a start for its first occurrence")
+	}
+
+	rule, err := recurrence.Parse(*event.RRule, event.Zone())
+	if err != nil {
+		return err
+	}
+	canonical := rule.String()
+	event.RRule = &canonical
+
+	return nil
+}
+
+// eventParam reads the :id of an /events/:id/... route
+func eventParam(c *gin.Context) (int, error) {
+	return strconv.Atoi(c.Param("id"))
+}
+
+// occurrenceParam reads the ?occurrence= of a registration request. Recurring
+// events take registrations per occurrence, so it is required for them and
+// must name a scheduled occurrence; one-off events have no occurrences.
+func occurrenceParam(c *gin.Context, events *database.EventModel, event *database.Event) (string, bool) {
+	value := strings.TrimSpace(c.Query("occurrence"))
+	if !event.IsRecurring() {
+		if value != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "This event does not repeat; omit occurrence"})
+			return "", false
+		}
+		return "", true
+	}
+
+	if value == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "occurrence is required for a recurring event", "detail": "pass the occurrenceId of one of GET /events/{id}/occurrences"})
+		return "", false
+	}
+
+	occurrence, err := events.GetOccurrence(event, value)
+	if err != nil {
+		if errors.Is(err, database.ErrOccurrenceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Occurrence not found"})
+			return "", false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve occurrence", "detail": err.Error()})
+		return "", false
+	}
+
+	return occurrence.OccurrenceId, true
+}
+
+// occurrenceWindow reads the from/to query parameters of an expansion
+func occurrenceWindow(from, to string, defaultFrom time.Time, defaultLength time.Duration) (time.Time, time.Time, error) {
+	start := defaultFrom
+	if from != "" {
+		parsed, err := database.ParseFilterTime(from)
+		if err != nil {
+			return start, start, errors.New("from must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+		}
+		start = parsed
+	}
+
+	end := start.Add(defaultLength)
+	if to != "" {
+		parsed, err := database.ParseFilterTime(to)
+		if err != nil {
+			return start, end, errors.New("to must be a date (YYYY-MM-DD) or RFC 3339 timestamp")
+		}
+		end = parsed
+	}
+
+	if !end.After(start) {
+		return start, end, errors.New("to must be after from")
+	}
+	if end.Sub(start) > database.MaxOccurrenceWindow {
+		return start, end, fmt.Errorf("the window between from and to can be at most %d days", int(database.MaxOccurrenceWindow.Hours()/24))
+	}
+
+	return start, end, nil
+}
+
+// listOccurrences answers GET /events?expand=true: every occurrence of the
+// matching events between from and to, in start order
+func (h *EventHandler) listOccurrences(c *gin.Context, query database.ListQuery, zone *time.Location) {
+	if query.Filter("from") == "" || query.Filter("to") == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "from and to are required to expand recurring events"})
+		return
+	}
+	if query.Cursor != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "cursor pagination is not available with expand; use offset"})
+		return
+	}
+	if query.Sort != "" && query.Sort != "startsAt" {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": "expanded occurrences can only be sorted by startsAt"})
+		return
+	}
+
+	from, to, err := occurrenceWindow(query.Filter("from"), query.Filter("to"), time.Time{}, 0)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"status": "error", "error": err.Error()})
+		return
+	}
+
+	occurrences, page, err := h.Models.Events.ListOccurrences(query, from, to, utils.RetrieveUserFromContext(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"status": "error",
+			"error":  "Failed to retrieve events",
+			"detail": err.Error(),
+		})
+		return
+	}
+	if zone != nil {
+		for _, occurrence := range occurrences {
+			occurrence.ShowIn(zone)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":           "ok",
+		"totalOccurrences": page.Total,
+		"occurrences":      occurrences,
+		"page":             page,
+	})
+}
+
+// GetEventOccurrences lists the occurrences of an event
+//
+//	@Summary		Lists the occurrences of an event
+//	@Description	Expands a recurring event into its occurrences between from and to (default: the next 90 days), with per-occurrence edits applied
+//	@Tags			events
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int		true	"Event ID"
+//	@Param			from	query		string	false	"Start of the window (default now)"
+//	@Param			to		query		string	false	"End of the window, exclusive (at most 366 days after from)"
+//	@Param			tz		query		string	false	"IANA time zone to show times in (default: the event's own)"
+//	@Success		200		{object}	[]database.Occurrence
+//	@Router			/api/v1/events/{id}/occurrences [get]
+
+func (h *EventHandler) GetEventOccurrences(c *gin.Context) {
+	id, err := eventParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID", "detail": err.Error()})
+		return
+	}
+
+	event, err := h.Models.Events.GET(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event", "detail": err.Error()})
+		return
+	}
+	if event == nil || !event.VisibleTo(utils.RetrieveUserFromContext(c)) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return
+	}
+
+	from, to, err := occurrenceWindow(c.Query("from"), c.Query("to"), time.Now().UTC(), defaultOccurrenceWindow)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	zone, ok := displayZone(c)
+	if !ok {
+		return
+	}
+
+	occurrences, err := h.Models.Events.Occurrences(event, from, to)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to expand event", "detail": err.Error()})
+		return
+	}
+	if zone != nil {
+		for _, occurrence := range occurrences {
+			occurrence.ShowIn(zone)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "ok",
+		"eventId":     event.Id,
+		"rrule":       event.RRule,
+		"from":        from.Format(time.RFC3339),
+		"to":          to.Format(time.RFC3339),
+		"occurrences": occurrences,
+	})
+}
+
+// UpdateOccurrence edits one occurrence of a recurring event, or it and every later one
+//
+//	@Summary		Edits an occurrence of a recurring event
+//	@Description	Changes one occurrence (scope "this") or it and all following occurrences (scope "following"). startsAt moves the occurrence, keeping its length unless endsAt is given too; with "following" every later occurrence moves by the same amount and takes the same length.
+//	@Tags			events
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int						true	"Event ID"
+//	@Param			occurrence	path		string					true	"Occurrence ID (its original start, RFC 3339)"
+//	@Param			changes		body		updateOccurrenceRequest	true	"Fields to change"
+//	@Success		200			{object}	database.Occurrence
+//	@Router			/api/v1/events/{id}/occurrences/{occurrence} [patch]
+//	@Security		BearerAuth
+
+func (h *EventHandler) UpdateOccurrence(c *gin.Context) {
+	event, occurrence, ok := h.managedOccurrence(c)
+	if !ok {
+		return
+	}
+
+	var req updateOccurrenceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON", "detail": err.Error()})
+		return
+	}
+
+	scope := req.Scope
+	if scope == "" {
+		scope = database.OverrideThis
+	}
+	if scope != database.OverrideThis && scope != database.OverrideFollowing {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be this or following"})
+		return
+	}
+	if req.Name == nil && req.Description == nil && req.StartsAt == nil && req.EndsAt == nil && req.Location == nil && req.Capacity == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No fields provided to update"})
+		return
+	}
+
+	// a single occurrence that moves keeps its current length, even one set by an earlier edit
+	if req.StartsAt != nil && req.EndsAt == nil && scope == database.OverrideThis {
+		endsAt := req.StartsAt.Add(occurrence.Duration())
+		req.EndsAt = &endsAt
+	}
+	startsAt := occurrence.StartsAt
+	if req.StartsAt != nil {
+		startsAt = req.StartsAt
+	}
+	if req.EndsAt != nil && !req.EndsAt.After(*startsAt) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "endsAt must be after startsAt"})
+		return
+	}
+
+	override := &database.OccurrenceOverride{
+		Occurrence:  occurrence.OccurrenceId,
+		Scope:       scope,
+		Name:        req.Name,
+		Description: req.Description,
+		StartsAt:    req.StartsAt,
+		EndsAt:      req.EndsAt,
+		Location:    req.Location,
+		Capacity:    req.Capacity,
+	}
+	// Seats freed by a larger capacity go to the waitlist
+	promoted, err := h.Models.Events.OverrideOccurrence(event.Id, override)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update occurrence", "detail": err.Error()})
+		return
+	}
+
+	updated, err := h.Models.Events.GetOccurrence(event, occurrence.OccurrenceId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve occurrence", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":               "ok",
+		"scope":                scope,
+		"occurrence":           updated,
+		"promotedFromWaitlist": promoted,
+	})
+}
+
+// CancelOccurrence cancels one occurrence of a recurring event, or it and every later one
+//
+//	@Summary		Cancels an occurrence of a recurring event
+//	@Description	Scope "this" (the default) adds the occurrence to the event's exdates; "following" ends the series before it. Registrations for cancelled occurrences are removed.
+//	@Tags			events
+//	@Accept			json
+//	@Produce		json
+//	@Param			id			path		int		true	"Event ID"
+//	@Param			occurrence	path		string	true	"Occurrence ID (its original start, RFC 3339)"
+//	@Param			scope		query		string	false	"this or following"
+//	@Success		200
+//	@Router			/api/v1/events/{id}/occurrences/{occurrence} [delete]
+//	@Security		BearerAuth
+
+func (h *EventHandler) CancelOccurrence(c *gin.Context) {
+	event, occurrence, ok := h.managedOccurrence(c)
+	if !ok {
+		return
+	}
+
+	scope := c.DefaultQuery("scope", database.OverrideThis)
+	if scope != database.OverrideThis && scope != database.OverrideFollowing {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "scope must be this or following"})
+		return
+	}
+	if scope == database.OverrideFollowing && occurrence.OccurrenceId == database.OccurrenceKey(event.FirstStart()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This is the first occurrence; delete the event to cancel the whole series"})
+		return
+	}
+
+	removed, err := h.Models.Events.CancelOccurrence(event, occurrence.OccurrenceId, scope)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel occurrence", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":                 "ok",
+		"eventId":                event.Id,
+		"occurrenceId":           occurrence.OccurrenceId,
+		"scope":                  scope,
+		"cancelledRegistrations": removed,
+	})
+}
+
+// managedOccurrence loads the event and occurrence of an occurrence route and
+// checks the current user may manage the event
+func (h *EventHandler) managedOccurrence(c *gin.Context) (*database.Event, *database.Occurrence, bool) {
+	id, err := eventParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid event ID", "detail": err.Error()})
+		return nil, nil, false
+	}
+
+	event, err := h.Models.Events.GET(id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve event", "detail": err.Error()})
+		return nil, nil, false
+	}
+	if event == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Event not found"})
+		return nil, nil, false
+	}
+
+	contextUser := utils.RetrieveUserFromContext(c)
+	if !canManageEvent(contextUser, event) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "You are not the owner of the event"})
+		return nil, nil, false
+	}
+
+	if !event.IsRecurring() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This event does not repeat; change it with PUT /events/{id}"})
+		return nil, nil, false
+	}
+
+	occurrence, err := h.Models.Events.GetOccurrence(event, c.Param("occurrence"))
+	if err != nil {
+		if errors.Is(err, database.ErrOccurrenceNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Occurrence not found"})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve occurrence", "detail": err.Error()})
+		return nil, nil, false
+	}
+
+	return event, occurrence, true
+}
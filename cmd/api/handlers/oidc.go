@@ -0,0 +1,270 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/oidc"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	oidcStateTTL = 10 * time.Minute
+	// usernameMaxLength matches the limit on usernames chosen at registration
+	usernameMaxLength = 50
+	// maxUsernameSuffix bounds the search for a free username
+	maxUsernameSuffix = 100
+)
+
+// oidcState is what the login redirect remembers for its callback
+type oidcState struct {
+	Provider     string `json:"provider"`
+	Nonce        string `json:"nonce"`
+	CodeVerifier string `json:"codeVerifier"`
+	Device       string `json:"device"`
+}
+
+func oidcStateKey(state string) string {
+	return fmt.Sprintf("oidc_state:%s", utils.HashToken(state))
+}
+
+// OIDCLogin redirects to an external identity provider
+//
+//	@Summary		Starts an external login
+//	@Description	Redirects to the identity provider's sign-in page; it sends the user back to the callback
+//	@Tags			auth
+//	@Param			provider	path	string	true	"Provider name"
+//	@Param			device		query	string	false	"Label for the new session"
+//	@Success		302
+//	@Router			/api/v1/auth/oidc/{provider}/login [get]
+
+func (h *AuthHandler) OIDCLogin(c *gin.Context) {
+	provider, ok := h.OIDCProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	state, err := utils.GenerateToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	nonce, err := utils.GenerateToken(32)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+	verifier, err := utils.GenerateToken(48)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	raw, _ := json.Marshal(oidcState{
+		Provider:     provider.Name,
+		Nonce:        nonce,
+		CodeVerifier: verifier,
+		Device:       deviceLabel(c, c.Query("device")),
+	})
+	if err := h.Redis.Set(redisclient.Ctx, oidcStateKey(state), raw, oidcStateTTL).Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start login"})
+		return
+	}
+
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("oidc provider %s: %v", provider.Name, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Identity provider is unavailable"})
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback finishes an external login
+//
+//	@Summary		Completes an external login
+//	@Description	Handles the identity provider's redirect and answers like /auth/login, linking or creating the account on first use
+//	@Tags			auth
+//	@Produce		json
+//	@Param			provider	path		string	true	"Provider name"
+//	@Param			code		query		string	true	"Authorization code"
+//	@Param			state		query		string	true	"State"
+//	@Success		200			{object}	loginResponse
+//	@Router			/api/v1/auth/oidc/{provider}/callback [get]
+
+func (h *AuthHandler) OIDCCallback(c *gin.Context) {
+	provider, ok := h.OIDCProviders[c.Param("provider")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown identity provider"})
+		return
+	}
+
+	if providerError := c.Query("error"); providerError != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Sign-in was not completed", "detail": providerError})
+		return
+	}
+
+	// the state is single use: whoever presents it first gets the login
+	raw, err := h.Redis.GetDel(redisclient.Ctx, oidcStateKey(c.Query("state"))).Bytes()
+	if err == redis.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login attempt is invalid or has expired; please start again"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load login attempt"})
+		return
+	}
+
+	var state oidcState
+	if err := json.Unmarshal(raw, &state); err != nil || state.Provider != provider.Name {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Login attempt is invalid or has expired; please start again"})
+		return
+	}
+
+	claims, err := provider.Exchange(c.Request.Context(), c.Query("code"), state.CodeVerifier, state.Nonce)
+	if err != nil {
+		log.Printf("oidc provider %s: %v", provider.Name, err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Identity provider did not confirm the login"})
+		return
+	}
+
+	user, status, message := h.userForIdentity(provider.Name, claims)
+	if user == nil {
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	h.completeLogin(c, user, state.Device, false)
+}
+
+// userForIdentity finds the account an external identity signs in to. A new
+// identity is linked to an existing account only when the provider vouches for
+// the email address and the account has verified it too; when no account has
+// the address a new one is created for it.
+func (h *AuthHandler) userForIdentity(provider string, claims *oidc.Claims) (*database.User, int, string) {
+	userID, err := h.Models.Identities.GetUserId(provider, claims.Subject)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to look up identity"
+	}
+	if userID != 0 {
+		user, err := h.Models.Users.Get(userID)
+		if err != nil || user == nil {
+			return nil, http.StatusInternalServerError, "Failed to load user"
+		}
+		if err := h.Models.Identities.Touch(provider, claims.Subject, claims.Email); err != nil {
+			log.Printf("failed to update identity: %v", err)
+		}
+		return user, 0, ""
+	}
+
+	if claims.Email == "" {
+		return nil, http.StatusBadRequest, "The identity provider did not share an email address"
+	}
+
+	user, err := h.Models.Users.GetByEmail(claims.Email)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "Failed to retrieve user"
+	}
+	if user != nil && !claims.EmailVerified {
+		return nil, http.StatusConflict, "An account with this email already exists; log in with your password"
+	}
+	// whoever registered an address that was never verified may not own it,
+	// and linking would hand the provider's user an account they can get into
+	if user != nil && user.EmailVerifiedAt == nil {
+		return nil, http.StatusConflict, "An account with this email exists but its address was never verified; verify it and log in with your password"
+	}
+
+	if user == nil {
+		user, err = h.createExternalUser(claims)
+		if err != nil {
+			return nil, http.StatusInternalServerError, "Failed to create account"
+		}
+	}
+
+	if err := h.Models.Identities.Link(user.ID, provider, claims.Subject, claims.Email); err != nil {
+		return nil, http.StatusInternalServerError, "Failed to link identity"
+	}
+
+	return user, 0, ""
+}
+
+// externalUsername is the nth choice of username for a name from an identity
+// provider: the name itself, then "name 2", "name 3" and so on, cut to fit in
+// usernameMaxLength characters
+func externalUsername(name string, n int) string {
+	suffix := ""
+	if n > 1 {
+		suffix = fmt.Sprintf(" %d", n)
+	}
+
+	runes := []rune(name)
+	if limit := usernameMaxLength - len(suffix); len(runes) > limit {
+		runes = runes[:limit]
+	}
+	return string(runes) + suffix
+}
+
+// createExternalUser registers an account for someone who signed in elsewhere.
+// It gets a random password nobody knows; the reset flow can set a real one.
+func (h *AuthHandler) createExternalUser(claims *oidc.Claims) (*database.User, error) {
+	password, err := utils.GenerateToken(32)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	name := claims.Name
+	if name == "" {
+		name = strings.Split(claims.Email, "@")[0]
+	}
+
+	// names are not unique the way usernames are, so a taken one gets a number
+	user := &database.User{Password: string(hash), Email: claims.Email}
+	for n := 1; ; n++ {
+		if n > maxUsernameSuffix {
+			return nil, database.ErrUsernameTaken
+		}
+		user.Username = externalUsername(name, n)
+
+		existing, err := h.Models.Users.GetByUsername(user.Username)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			continue
+		}
+
+		// another sign-in can still take the name first
+		err = h.Models.Users.Insert(user, database.RoleMember)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, database.ErrUsernameTaken) {
+			return nil, err
+		}
+	}
+
+	if claims.EmailVerified {
+		if _, err := h.Models.Users.MarkEmailVerified(user.ID, user.Email); err != nil {
+			return nil, err
+		}
+		return h.Models.Users.Get(user.ID)
+	}
+
+	return user, nil
+}
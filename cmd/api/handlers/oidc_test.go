@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+	"github.com/muhamash/go-first-rest-api/internal/oidc"
+)
+
+func TestExternalUsername(t *testing.T) {
+	long := strings.Repeat("é", 60)
+
+	tests := []struct {
+		name string
+		n    int
+		want string
+	}{
+		{"John Smith", 1, "John Smith"},
+		{"John Smith", 2, "John Smith 2"},
+		{"John Smith", 12, "John Smith 12"},
+		{long, 1, strings.Repeat("é", 50)},
+		{long, 2, strings.Repeat("é", 48) + " 2"},
+		{long, 100, strings.Repeat("é", 46) + " 100"},
+	}
+
+	for _, tt := range tests {
+		got := externalUsername(tt.name, tt.n)
+		if got != tt.want {
+			t.Errorf("externalUsername(%q, %d) = %q, want %q", tt.name, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestCreateExternalUserWithTakenName(t *testing.T) {
+	h := &AuthHandler{Models: database.NewModels(dbtest.New(t))}
+
+	identities := []*oidc.Claims{
+		{Subject: "a", Email: "john@one.example", Name: "John Smith"},
+		{Subject: "b", Email: "john@two.example", Name: "John Smith"},
+		{Subject: "c", Email: "john@three.example"},
+		{Subject: "d", Email: "john@four.example"},
+	}
+	want := []string{"John Smith", "John Smith 2", "john", "john 2"}
+
+	for i, claims := range identities {
+		user, err := h.createExternalUser(claims)
+		if err != nil {
+			t.Fatalf("%s: %v", claims.Email, err)
+		}
+		if user.Username != want[i] {
+			t.Errorf("%s: username %q, want %q", claims.Email, user.Username, want[i])
+		}
+	}
+}
+
+func TestIdentityLinksOnlyToVerifiedAccounts(t *testing.T) {
+	models := database.NewModels(dbtest.New(t))
+	h := &AuthHandler{Models: models}
+
+	unverified := insertUser(t, models, "squatter")
+	verified := insertUser(t, models, "owner")
+	if _, err := models.Users.MarkEmailVerified(verified.ID, verified.Email); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		claims     *oidc.Claims
+		wantStatus int
+		wantUser   int
+	}{
+		{"account never verified", &oidc.Claims{Subject: "a", Email: unverified.Email, EmailVerified: true}, http.StatusConflict, 0},
+		{"provider did not verify", &oidc.Claims{Subject: "b", Email: verified.Email}, http.StatusConflict, 0},
+		{"both verified", &oidc.Claims{Subject: "c", Email: verified.Email, EmailVerified: true}, 0, verified.ID},
+	}
+
+	for _, tt := range tests {
+		user, status, message := h.userForIdentity("google", tt.claims)
+		if status != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, status, tt.wantStatus, message)
+		}
+		if (user == nil) != (tt.wantUser == 0) || (user != nil && user.ID != tt.wantUser) {
+			t.Errorf("%s: signed in as %v, want user %d", tt.name, user, tt.wantUser)
+		}
+
+		linked, err := models.Identities.GetUserId("google", tt.claims.Subject)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if linked != tt.wantUser {
+			t.Errorf("%s: identity linked to %d, want %d", tt.name, linked, tt.wantUser)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/mailer"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	passwordResetTTL = 30 * time.Minute
+	// how long an email sent after the response may take
+	backgroundMailTimeout = 30 * time.Second
+)
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// ForgotPassword emails a single-use password reset link
+//
+//	@Summary		Requests a password reset
+//	@Description	Emails a reset link if the address belongs to an account; the response is the same either way
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		forgotPasswordRequest	true	"Email"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/auth/password/forgot [post]
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req forgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Never reveal whether the email is registered
+	response := gin.H{"status": "ok", "message": "If that email is registered, a reset link has been sent"}
+
+	user, err := h.Models.Users.GetByEmail(req.Email)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process request"})
+		return
+	}
+	if user == nil {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// from here on a failure is logged, not reported: an error only a
+	// registered email can run into would give the account away
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		log.Printf("failed to generate password reset token for user %d: %v", user.ID, err)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	tokenKey := fmt.Sprintf("password_reset:%s", utils.HashToken(token))
+	userKey := fmt.Sprintf("password_reset:user:%d", user.ID)
+
+	// Only the newest link works: drop the token issued before this one
+	if previous, err := h.Redis.Get(redisclient.Ctx, userKey).Result(); err == nil {
+		h.Redis.Del(redisclient.Ctx, fmt.Sprintf("password_reset:%s", previous))
+	}
+
+	pipe := h.Redis.TxPipeline()
+	pipe.Set(redisclient.Ctx, tokenKey, user.ID, passwordResetTTL)
+	pipe.Set(redisclient.Ctx, userKey, utils.HashToken(token), passwordResetTTL)
+	if _, err := pipe.Exec(redisclient.Ctx); err != nil {
+		log.Printf("failed to store password reset token for user %d: %v", user.ID, err)
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	// sent after the response, so a registered email takes no longer to answer than an unknown one
+	link := fmt.Sprintf("%s/reset-password?token=%s", h.AppURL, url.QueryEscape(token))
+	h.sendInBackground(user.ID, "password reset email", mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %d minutes and works once.\n\n%s\n\nIf you did not ask for this, you can ignore this email.\n",
+			user.Username, int(passwordResetTTL.Minutes()), link),
+	})
+
+	c.JSON(http.StatusOK, response)
+}
+
+// sendInBackground sends an email without holding up the response. A failure
+// is only logged: nobody is waiting for the result.
+func (h *AuthHandler) sendInBackground(userID int, what string, msg mailer.Message) {
+	h.mail.Add(1)
+	go func() {
+		defer h.mail.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), backgroundMailTimeout)
+		defer cancel()
+		if err := h.Mailer.Send(ctx, msg); err != nil {
+			log.Printf("failed to send %s to user %d: %v", what, userID, err)
+		}
+	}()
+}
+
+// rejectWeakPassword answers with the password policy's field errors when the
+// password breaks it, and reports whether it did
+func (h *AuthHandler) rejectWeakPassword(c *gin.Context, field, password, username, email string) bool {
+	errs := h.PasswordPolicy.Check(field, password, username, email)
+	if len(errs) == 0 {
+		return false
+	}
+
+	c.JSON(http.StatusBadRequest, gin.H{"error": "Password does not meet the password policy", "fields": errs})
+	return true
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword
+//
+//	@Summary		Resets a password
+//	@Description	Consumes a reset token, sets the new password and signs the user out everywhere
+//	@Tags			auth
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		resetPasswordRequest	true	"Token and new password"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/auth/password/reset [post]
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req resetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// the token is only read here, so a password the policy rejects does not use it up
+	tokenKey := fmt.Sprintf("password_reset:%s", utils.HashToken(req.Token))
+	userID, err := h.Redis.Get(redisclient.Ctx, tokenKey).Int()
+	if err == redis.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify reset token"})
+		return
+	}
+
+	user, err := h.Models.Users.Get(userID)
+	if err != nil || user == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+		return
+	}
+
+	if h.rejectWeakPassword(c, "password", req.Password, user.Username, user.Email) {
+		return
+	}
+
+	// GETDEL makes the token single-use even under concurrent requests
+	if _, err := h.Redis.GetDel(redisclient.Ctx, tokenKey).Int(); err != nil {
+		if err == redis.Nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired reset token"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify reset token"})
+		return
+	}
+	h.Redis.Del(redisclient.Ctx, fmt.Sprintf("password_reset:user:%d", userID))
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := h.Models.Users.UpdatePassword(user.ID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password", "detail": err.Error()})
+		return
+	}
+
+	if err := h.revokeUserTokens(user.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password updated but failed to sign out existing sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Password has been reset; please log in again"})
+}
+
+// revokeUserTokens signs a user out of every session
+func (h *AuthHandler) revokeUserTokens(userID int) error {
+	return h.Sessions.RevokeAll(redisclient.Ctx, userID)
+}
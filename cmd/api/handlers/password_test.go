@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+	"github.com/muhamash/go-first-rest-api/internal/passwordpolicy"
+	"github.com/muhamash/go-first-rest-api/internal/session"
+	"github.com/redis/go-redis/v9"
+)
+
+const strongPassword = "Correct-Horse-42-battery"
+
+func newPasswordResetRouter(t *testing.T, mail *outbox) (*gin.Engine, *AuthHandler, *database.User) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	models := database.NewModels(dbtest.New(t))
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	h := &AuthHandler{
+		Models:         models,
+		Redis:          rdb,
+		Sessions:       &session.Store{Redis: rdb, TTL: time.Hour},
+		Mailer:         mail,
+		AppURL:         "http://app.test",
+		PasswordPolicy: &passwordpolicy.Policy{MinLength: 10, MinClasses: 2, Breached: passwordpolicy.DefaultBreachList()},
+	}
+	router := gin.New()
+	router.POST("/password/forgot", h.ForgotPassword)
+	router.POST("/password/reset", h.ResetPassword)
+
+	return router, h, insertUser(t, models, "forgetful")
+}
+
+// requestReset asks for a reset link and returns the token mailed for it
+func requestReset(t *testing.T, router *gin.Engine, h *AuthHandler, mail *outbox, email string) string {
+	t.Helper()
+
+	rec := postJSON(t, router, "/password/forgot", gin.H{"email": email})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("forgot: got %d: %s", rec.Code, rec.Body.String())
+	}
+	h.mail.Wait()
+
+	mail.mu.Lock()
+	defer mail.mu.Unlock()
+	if len(mail.messages) == 0 {
+		t.Fatal("no reset email sent")
+	}
+	match := magicLinkToken.FindStringSubmatch(mail.messages[len(mail.messages)-1].Body)
+	if match == nil {
+		t.Fatalf("no token in %q", mail.messages[len(mail.messages)-1].Body)
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRegisterReportsPasswordFieldErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	h := &AuthHandler{
+		Models:         models,
+		PasswordPolicy: &passwordpolicy.Policy{MinLength: 10, MinClasses: 2, Breached: passwordpolicy.DefaultBreachList()},
+	}
+	router := gin.New()
+	router.POST("/register", h.RegisterUser)
+
+	rec := postJSON(t, router, "/register", gin.H{"name": "weakling", "email": "weakling@example.com", "password": "weakling"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("got %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+
+	var response struct {
+		Error  string                      `json:"error"`
+		Fields []passwordpolicy.FieldError `json:"fields"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{passwordpolicy.CodeTooShort, passwordpolicy.CodeTooFewClasses, passwordpolicy.CodeContainsIdentity}
+	if len(response.Fields) != len(want) {
+		t.Fatalf("got fields %+v, want codes %v", response.Fields, want)
+	}
+	for i, field := range response.Fields {
+		if field.Field != "password" || field.Code != want[i] || field.Message == "" {
+			t.Errorf("field error %d = %+v, want a password %s error with a message", i, field, want[i])
+		}
+	}
+
+	if user, err := models.Users.GetByEmail("weakling@example.com"); err != nil || user != nil {
+		t.Errorf("rejected registration saved user %v (%v)", user, err)
+	}
+}
+
+func TestPasswordResetWorksOnce(t *testing.T) {
+	mail := &outbox{}
+	router, h, user := newPasswordResetRouter(t, mail)
+	ctx := context.Background()
+
+	if err := h.Sessions.Create(ctx, &session.Session{ID: "laptop", UserID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+	token := requestReset(t, router, h, mail, user.Email)
+
+	// a password the policy rejects leaves the token for another try
+	rec := postJSON(t, router, "/password/reset", gin.H{"token": token, "password": "short"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("weak password: got %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = postJSON(t, router, "/password/reset", gin.H{"token": token, "password": strongPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset: got %d: %s", rec.Code, rec.Body.String())
+	}
+	if _, err := h.Sessions.Get(ctx, "laptop"); !errors.Is(err, session.ErrNotFound) {
+		t.Errorf("session survived the reset: %v", err)
+	}
+
+	rec = postJSON(t, router, "/password/reset", gin.H{"token": token, "password": strongPassword + "!"})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("replay: got %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestPasswordResetOnlyNewestLinkWorks(t *testing.T) {
+	mail := &outbox{}
+	router, h, user := newPasswordResetRouter(t, mail)
+
+	first := requestReset(t, router, h, mail, user.Email)
+	second := requestReset(t, router, h, mail, user.Email)
+
+	rec := postJSON(t, router, "/password/reset", gin.H{"token": first, "password": strongPassword})
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("earlier link: got %d, want 400: %s", rec.Code, rec.Body.String())
+	}
+	rec = postJSON(t, router, "/password/reset", gin.H{"token": second, "password": strongPassword})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("newest link: got %d: %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestForgotPasswordSameResponseForUnknownEmail(t *testing.T) {
+	router, h, user := newPasswordResetRouter(t, &outbox{err: errors.New("smtp down")})
+
+	known := postJSON(t, router, "/password/forgot", gin.H{"email": user.Email})
+	unknown := postJSON(t, router, "/password/forgot", gin.H{"email": "nobody@example.com"})
+	h.mail.Wait()
+
+	if known.Code != unknown.Code || known.Body.String() != unknown.Body.String() {
+		t.Errorf("registered: %d %s; unknown: %d %s", known.Code, known.Body.String(), unknown.Code, unknown.Body.String())
+	}
+}
@@ -0,0 +1,367 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/mailer"
+	"github.com/muhamash/go-first-rest-api/internal/passwordpolicy"
+	"github.com/redis/go-redis/v9"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+type profileResponse struct {
+	ID            int      `json:"id"`
+	Username      string   `json:"username"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"emailVerified"`
+	PendingEmail  string   `json:"pendingEmail,omitempty"`
+	DisplayName   string   `json:"displayName"`
+	Bio           string   `json:"bio"`
+	AvatarURL     string   `json:"avatarUrl"`
+	Timezone      string   `json:"timezone"`
+	Roles         []string `json:"roles"`
+	// set while the account waits to be deleted
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`
+}
+
+// fields left out of the request are not changed
+type updateProfileRequest struct {
+	// checked by UpdateMe once surrounding spaces are trimmed
+	Username    *string `json:"username"`
+	Email       *string `json:"email" binding:"omitempty,email,max=100"`
+	DisplayName *string `json:"displayName" binding:"omitempty,max=100"`
+	Bio         *string `json:"bio" binding:"omitempty,max=500"`
+	AvatarURL   *string `json:"avatarUrl" binding:"omitempty,max=2048"`
+	Timezone    *string `json:"timezone" binding:"omitempty,max=64"`
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"currentPassword" binding:"required"`
+	NewPassword     string `json:"newPassword" binding:"required"`
+}
+
+// emailChange is what a confirmation link sent to the new address remembers
+type emailChange struct {
+	UserID   int    `json:"userId"`
+	OldEmail string `json:"oldEmail"`
+	NewEmail string `json:"newEmail"`
+}
+
+func emailChangeUserKey(userID int) string {
+	return fmt.Sprintf("email_change:user:%d", userID)
+}
+
+func (h *AuthHandler) profile(user *database.User) profileResponse {
+	pending := ""
+	if raw, err := h.Redis.Get(redisclient.Ctx, emailChangeUserKey(user.ID)).Bytes(); err == nil {
+		var change emailChange
+		if json.Unmarshal(raw, &change) == nil {
+			pending = change.NewEmail
+		}
+	}
+
+	roles := user.Roles
+	if roles == nil {
+		roles = []string{}
+	}
+
+	return profileResponse{
+		ID:            user.ID,
+		Username:      user.Username,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerifiedAt != nil,
+		PendingEmail:  pending,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarURL:     user.AvatarURL,
+		Timezone:      user.Timezone,
+		Roles:         roles,
+
+		DeletionScheduledAt: user.DeletionScheduledAt,
+	}
+}
+
+// GetMe returns the signed-in user's profile
+//
+//	@Summary		Returns the current user
+//	@Description	Returns the signed-in user's account and profile
+//	@Tags			me
+//	@Produce		json
+//	@Success		200	{object}	profileResponse
+//	@Router			/api/v1/me [get]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) GetMe(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "user": h.profile(user)})
+}
+
+// UpdateMe changes the signed-in user's profile
+//
+//	@Summary		Updates the current user
+//	@Description	Updates the username and profile fields. A new email only replaces the current one once it is confirmed from the link sent to it. If that link cannot be sent, the other changes are still saved and emailChangeError says why.
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		updateProfileRequest	true	"Fields to change"
+//	@Success		200		{object}	profileResponse
+//	@Router			/api/v1/me [patch]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) UpdateMe(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	var req updateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if length := utf8.RuneCountInString(username); length < 3 || length > 50 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "username must be between 3 and 50 characters"})
+			return
+		}
+		user.Username = username
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = strings.TrimSpace(*req.DisplayName)
+	}
+	if req.Bio != nil {
+		user.Bio = *req.Bio
+	}
+	if req.AvatarURL != nil {
+		avatar := strings.TrimSpace(*req.AvatarURL)
+		if avatar != "" {
+			parsed, err := url.Parse(avatar)
+			if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "avatarUrl must be an http or https URL"})
+				return
+			}
+		}
+		user.AvatarURL = avatar
+	}
+	if req.Timezone != nil {
+		if _, err := time.LoadLocation(*req.Timezone); err != nil || *req.Timezone == "" || *req.Timezone == "Local" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "timezone must be an IANA time zone such as Europe/Berlin"})
+			return
+		}
+		user.Timezone = *req.Timezone
+	}
+
+	changingEmail := req.Email != nil && !strings.EqualFold(*req.Email, user.Email)
+	if changingEmail {
+		existing, err := h.Models.Users.GetByEmail(*req.Email)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check email", "detail": err.Error()})
+			return
+		}
+		if existing != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrEmailTaken.Error()})
+			return
+		}
+	}
+
+	if err := h.Models.Users.UpdateProfile(user); err != nil {
+		if errors.Is(err, database.ErrUsernameTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile", "detail": err.Error()})
+		return
+	}
+
+	response := gin.H{"status": "ok", "message": "Profile updated"}
+	if changingEmail {
+		// the profile is already saved, so a failure here is reported next to it
+		// rather than as an error a client would retry the whole update for
+		if err := h.startEmailChange(c, user, *req.Email); err != nil {
+			log.Printf("failed to start email change for user %d: %v", user.ID, err)
+			response["message"] = "Profile updated, but the confirmation email could not be sent; try changing the email again"
+			response["emailChangeError"] = "The confirmation email could not be sent"
+		} else {
+			response["message"] = "Profile updated; confirm the new email address from the link we sent to it"
+		}
+	}
+	// built last, so it shows the email change just started
+	response["user"] = h.profile(user)
+
+	c.JSON(http.StatusOK, response)
+}
+
+// startEmailChange mails a confirmation link to the new address and lets the
+// old address know. Only the newest pending change can be confirmed.
+func (h *AuthHandler) startEmailChange(c *gin.Context, user *database.User, newEmail string) error {
+	token, err := utils.GenerateToken(32)
+	if err != nil {
+		return err
+	}
+
+	raw, _ := json.Marshal(emailChange{UserID: user.ID, OldEmail: user.Email, NewEmail: newEmail})
+	tokenKey := fmt.Sprintf("email_change:%s", utils.HashToken(token))
+
+	userKey := emailChangeUserKey(user.ID)
+
+	pipe := h.Redis.TxPipeline()
+	pipe.Set(redisclient.Ctx, tokenKey, raw, emailChangeTTL)
+	pipe.Set(redisclient.Ctx, userKey, raw, emailChangeTTL)
+	pipe.Set(redisclient.Ctx, userKey+":token", tokenKey, emailChangeTTL)
+	if _, err := pipe.Exec(redisclient.Ctx); err != nil {
+		return err
+	}
+
+	link := fmt.Sprintf("%s/api/v1/me/email/confirm?token=%s", h.APIURL, url.QueryEscape(token))
+	err = h.Mailer.Send(c.Request.Context(), mailer.Message{
+		To:      newEmail,
+		Subject: "Confirm your new email address",
+		Body: fmt.Sprintf("Hi %s,\n\nOpen the link below to use this address for your account. It expires in %d hours.\n\n%s\n",
+			user.Username, int(emailChangeTTL.Hours()), link),
+	})
+	if err != nil {
+		// nothing was sent, so nothing is pending
+		h.Redis.Del(redisclient.Ctx, tokenKey, userKey, userKey+":token")
+		return err
+	}
+
+	if err := h.Mailer.Send(c.Request.Context(), mailer.Message{
+		To:      user.Email,
+		Subject: "Your email address is being changed",
+		Body: fmt.Sprintf("Hi %s,\n\nSomeone asked to change the email address on your account to %s. If this was not you, change your password now.\n",
+			user.Username, newEmail),
+	}); err != nil {
+		log.Printf("failed to notify user %d about an email change: %v", user.ID, err)
+	}
+
+	return nil
+}
+
+// ConfirmEmailChange switches the account to the new address from the link sent to it
+//
+//	@Summary		Confirms an email change
+//	@Description	Consumes the token mailed to the new address and makes it the account's verified email
+//	@Tags			me
+//	@Produce		json
+//	@Param			token	query		string	true	"Confirmation token"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/me/email/confirm [get]
+
+func (h *AuthHandler) ConfirmEmailChange(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing confirmation token"})
+		return
+	}
+
+	tokenKey := fmt.Sprintf("email_change:%s", utils.HashToken(token))
+	raw, err := h.Redis.GetDel(redisclient.Ctx, tokenKey).Bytes()
+	if err == redis.Nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to confirm email change"})
+		return
+	}
+
+	var change emailChange
+	if err := json.Unmarshal(raw, &change); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid or expired confirmation link"})
+		return
+	}
+
+	// a later request replaced this one
+	latest, _ := h.Redis.Get(redisclient.Ctx, emailChangeUserKey(change.UserID)+":token").Result()
+	if latest != tokenKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "This link was replaced by a newer email change request"})
+		return
+	}
+
+	changed, err := h.Models.Users.ChangeEmail(change.UserID, change.OldEmail, change.NewEmail)
+	if err != nil {
+		if errors.Is(err, database.ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change email", "detail": err.Error()})
+		return
+	}
+	if !changed {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The account's email changed since this link was sent"})
+		return
+	}
+
+	h.Redis.Del(redisclient.Ctx, emailChangeUserKey(change.UserID), emailChangeUserKey(change.UserID)+":token")
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Email address changed", "email": change.NewEmail})
+}
+
+// ChangePassword changes the signed-in user's password
+//
+//	@Summary		Changes the password
+//	@Description	Requires the current password and signs out every other session
+//	@Tags			me
+//	@Accept			json
+//	@Produce		json
+//	@Param			body	body		changePasswordRequest	true	"Current and new password"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/me/password [post]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) ChangePassword(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	var req changePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.CurrentPassword)); err != nil {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	if h.rejectWeakPassword(c, "newPassword", req.NewPassword, user.Username, user.Email) {
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(req.NewPassword)) == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Password does not meet the password policy", "fields": []passwordpolicy.FieldError{{
+			Field: "newPassword", Code: passwordpolicy.CodeUnchanged, Message: "must differ from the current password",
+		}}})
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	if err := h.Models.Users.UpdatePassword(user.ID, string(hashedPassword)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update password", "detail": err.Error()})
+		return
+	}
+
+	// this device stays signed in; every other one has to log in with the new password
+	if err := h.Sessions.RevokeAll(redisclient.Ctx, user.ID, utils.RetrieveSessionIDFromContext(c)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Password updated but failed to sign out other sessions"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "message": "Password changed; other sessions were signed out"})
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+	"github.com/redis/go-redis/v9"
+)
+
+type updateMeResponse struct {
+	Message          string          `json:"message"`
+	EmailChangeError string          `json:"emailChangeError"`
+	User             profileResponse `json:"user"`
+}
+
+// patchMe updates a fresh account's profile, sending mail through mail
+func patchMe(t *testing.T, mail *outbox, body any) (*httptest.ResponseRecorder, updateMeResponse, *AuthHandler, *database.User) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	models := database.NewModels(dbtest.New(t))
+	user := &database.User{Username: "profile", Email: "profile@example.com", Password: "x"}
+	if err := models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &AuthHandler{
+		Models: models,
+		Redis:  redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}),
+		Mailer: mail,
+		APIURL: "http://api.test",
+	}
+	router := gin.New()
+	router.PATCH("/me", func(c *gin.Context) { c.Set("user", user) }, h.UpdateMe)
+
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPatch, "/me", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	var response updateMeResponse
+	json.Unmarshal(rec.Body.Bytes(), &response)
+	return rec, response, h, user
+}
+
+func TestUpdateMeUsernameIsTrimmedBeforeValidation(t *testing.T) {
+	tests := []struct {
+		username string
+		wantCode int
+		want     string
+	}{
+		{"   ", http.StatusBadRequest, "profile"},
+		{"  ab  ", http.StatusBadRequest, "profile"},
+		{"  newname  ", http.StatusOK, "newname"},
+	}
+
+	for _, tt := range tests {
+		rec, _, h, user := patchMe(t, &outbox{}, gin.H{"username": tt.username})
+		if rec.Code != tt.wantCode {
+			t.Errorf("%q: got %d, want %d: %s", tt.username, rec.Code, tt.wantCode, rec.Body.String())
+		}
+
+		saved, err := h.Models.Users.Get(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if saved.Username != tt.want {
+			t.Errorf("%q: saved username %q, want %q", tt.username, saved.Username, tt.want)
+		}
+	}
+}
+
+func TestUpdateMeEmailChange(t *testing.T) {
+	t.Run("sent", func(t *testing.T) {
+		rec, response, _, _ := patchMe(t, &outbox{}, gin.H{"email": "new@example.com"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", rec.Code, rec.Body.String())
+		}
+		if response.User.PendingEmail != "new@example.com" {
+			t.Errorf("pendingEmail = %q, want the new address", response.User.PendingEmail)
+		}
+	})
+
+	t.Run("mail fails", func(t *testing.T) {
+		rec, response, h, user := patchMe(t, &outbox{err: errors.New("dial tcp 10.0.0.5:25: connection refused")}, gin.H{"email": "new@example.com"})
+		if rec.Code != http.StatusOK {
+			t.Fatalf("got %d: %s", rec.Code, rec.Body.String())
+		}
+		if response.EmailChangeError != "The confirmation email could not be sent" {
+			t.Errorf("emailChangeError = %q", response.EmailChangeError)
+		}
+		if response.User.PendingEmail != "" {
+			t.Errorf("pendingEmail = %q, want none", response.User.PendingEmail)
+		}
+		if pending := h.profile(user).PendingEmail; pending != "" {
+			t.Errorf("change still pending for %q", pending)
+		}
+	})
+}
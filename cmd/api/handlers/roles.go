@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+)
+
+type RoleHandler struct {
+	Models database.Models
+}
+
+type grantRoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+// GetAllRoles returns every role with its permissions
+//
+//	@Summary		Returns all roles
+//	@Description	Returns all roles with the permissions they grant
+//	@Tags			roles
+//	@Produce		json
+//	@Success		200	{object}	[]database.Role
+//	@Router			/api/v1/admin/roles [get]
+//	@Security		BearerAuth
+
+func (h *RoleHandler) GetAllRoles(c *gin.Context) {
+	roles, err := h.Models.Roles.GetAll()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve roles", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status": "ok",
+		"roles":  roles,
+	})
+}
+
+// GetUserRoles returns the roles assigned to a user
+//
+//	@Summary		Returns the roles of a user
+//	@Description	Returns the roles of a user
+//	@Tags			roles
+//	@Produce		json
+//	@Param			id	path		int	true	"User ID"
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/admin/users/{id}/roles [get]
+//	@Security		BearerAuth
+
+func (h *RoleHandler) GetUserRoles(c *gin.Context) {
+	user, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	if err := h.Models.Roles.LoadForUser(user); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve roles", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":      "ok",
+		"userId":      user.ID,
+		"roles":       user.Roles,
+		"permissions": user.Permissions,
+	})
+}
+
+// GrantRole grants a role to a user
+//
+//	@Summary		Grants a role to a user
+//	@Description	Grants a role to a user
+//	@Tags			roles
+//	@Accept			json
+//	@Produce		json
+//	@Param			id		path		int					true	"User ID"
+//	@Param			role	body		grantRoleRequest	true	"Role"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/admin/users/{id}/roles [post]
+//	@Security		BearerAuth
+
+func (h *RoleHandler) GrantRole(c *gin.Context) {
+	user, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	var req grantRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.Models.Roles.Grant(user.ID, req.Role); err != nil {
+		if errors.Is(err, database.ErrRoleNotFound) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "role": req.Role})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to grant role", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ok",
+		"message": "Role granted successfully",
+		"userId":  user.ID,
+		"role":    req.Role,
+	})
+}
+
+// RevokeRole revokes a role from a user
+//
+//	@Summary		Revokes a role from a user
+//	@Description	Revokes a role from a user
+//	@Tags			roles
+//	@Produce		json
+//	@Param			id		path		int		true	"User ID"
+//	@Param			role	path		string	true	"Role name"
+//	@Success		200		{object}	gin.H
+//	@Router			/api/v1/admin/users/{id}/roles/{role} [delete]
+//	@Security		BearerAuth
+
+func (h *RoleHandler) RevokeRole(c *gin.Context) {
+	user, ok := h.userFromParam(c)
+	if !ok {
+		return
+	}
+
+	role := c.Param("role")
+
+	// Never leave the system without someone who can hand out roles
+	if err := h.Models.Roles.Revoke(user.ID, role); err != nil {
+		if errors.Is(err, database.ErrRoleNotFound) || errors.Is(err, database.ErrRoleNotAssigned) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "role": role})
+			return
+		}
+		if errors.Is(err, database.ErrLastRoleManager) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Cannot revoke the role of the last active admin"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke role", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ok",
+		"message": "Role revoked successfully",
+		"userId":  user.ID,
+		"role":    role,
+	})
+}
+
+// userFromParam resolves the :id path parameter to a user, writing the error response itself
+func (h *RoleHandler) userFromParam(c *gin.Context) (*database.User, bool) {
+	userId, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID", "detail": err.Error()})
+		return nil, false
+	}
+
+	user, err := h.Models.Users.Get(userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve user", "detail": err.Error()})
+		return nil, false
+	}
+	if user == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,47 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+func TestRevokeAdminRole(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	admin := insertUser(t, models, "admin", database.RoleAdmin)
+	member := insertUser(t, models, "member", database.RoleMember)
+	// a suspended admin cannot step in, so it does not count
+	suspended := insertUser(t, models, "suspended", database.RoleAdmin)
+	if err := models.Users.Suspend(suspended.ID, "test"); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &RoleHandler{Models: models}
+	router := gin.New()
+	router.DELETE("/users/:id/roles/:role", h.RevokeRole)
+
+	tests := []struct {
+		name   string
+		target *database.User
+		want   int
+	}{
+		// the user has no admin role to lose, so the last admin is not at stake
+		{"user without the role", member, http.StatusBadRequest},
+		{"last active admin", admin, http.StatusConflict},
+		{"suspended admin", suspended, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, fmt.Sprintf("/users/%d/roles/admin", tt.target.ID), nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s: got %d, want %d: %s", tt.name, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/session"
+)
+
+const (
+	accessTokenTTL = 15 * time.Minute
+
+	// both token kinds are signed with the same keys, so each says what it is
+	tokenTypeAccess  = "access"
+	tokenTypeRefresh = "refresh"
+
+	maxDeviceLabelLength = 200
+)
+
+type sessionResponse struct {
+	*session.Session
+	Current bool `json:"current"`
+}
+
+// deviceLabel names the session after what the client asked for, falling back to its user agent
+func deviceLabel(c *gin.Context, requested string) string {
+	label := requested
+	if label == "" {
+		label = c.Request.UserAgent()
+	}
+	if label == "" {
+		label = "unknown device"
+	}
+	if len(label) > maxDeviceLabelLength {
+		label = label[:maxDeviceLabelLength]
+	}
+	return label
+}
+
+// startSession opens a new session for the user and returns its first token pair
+func (h *AuthHandler) startSession(c *gin.Context, user *database.User, device string) (string, string, error) {
+	sessionID, err := utils.GenerateToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	refreshJTI, err := utils.GenerateToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	sess := &session.Session{
+		ID:         sessionID,
+		UserID:     user.ID,
+		Device:     deviceLabel(c, device),
+		IP:         c.ClientIP(),
+		RefreshJTI: refreshJTI,
+	}
+	if err := h.Sessions.Create(redisclient.Ctx, sess); err != nil {
+		return "", "", err
+	}
+
+	return h.issueTokens(user, sess)
+}
+
+// issueTokens signs an access token and a refresh token bound to the session's current jti
+func (h *AuthHandler) issueTokens(user *database.User, sess *session.Session) (string, string, error) {
+	// Roles may have changed since the session started
+	roles, err := h.Models.Roles.GetRolesForUser(user.ID)
+	if err != nil {
+		return "", "", err
+	}
+
+	// the jti lets a single access token be denylisted on logout
+	accessJTI, err := utils.GenerateToken(16)
+	if err != nil {
+		return "", "", err
+	}
+
+	accessString, err := h.Keys.Sign(jwt.MapClaims{
+		"typ":     tokenTypeAccess,
+		"jti":     accessJTI,
+		"user_id": user.ID,
+		"email":   user.Email,
+		"roles":   roles,
+		"sid":     sess.ID,
+		"exp":     time.Now().Add(accessTokenTTL).Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	refreshString, err := h.Keys.Sign(jwt.MapClaims{
+		"typ":     tokenTypeRefresh,
+		"user_id": user.ID,
+		"sid":     sess.ID,
+		"jti":     sess.RefreshJTI,
+		// A refresh token lives exactly as long as the session it rotates
+		"exp": time.Now().Add(h.Sessions.TTL).Unix(),
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return accessString, refreshString, nil
+}
+
+// GetSessions lists the devices the user is signed in on
+//
+//	@Summary		Lists the user's sessions
+//	@Description	Lists every device the user is signed in on, most recently used first
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/auth/sessions [get]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) GetSessions(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+	currentID := utils.RetrieveSessionIDFromContext(c)
+
+	sessions, err := h.Sessions.List(redisclient.Ctx, user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve sessions", "detail": err.Error()})
+		return
+	}
+
+	response := make([]sessionResponse, 0, len(sessions))
+	for _, sess := range sessions {
+		response = append(response, sessionResponse{Session: sess, Current: sess.ID == currentID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":   "ok",
+		"sessions": response,
+	})
+}
+
+// RevokeSession signs one of the user's devices out
+//
+//	@Summary		Revokes a session
+//	@Description	Signs out one of the user's own sessions; its refresh token stops working immediately
+//	@Tags			auth
+//	@Produce		json
+//	@Param			id	path		string	true	"Session ID"
+//	@Success		200	{object}	gin.H
+//	@Router			/api/v1/auth/sessions/{id} [delete]
+//	@Security		BearerAuth
+
+func (h *AuthHandler) RevokeSession(c *gin.Context) {
+	user := utils.RetrieveUserFromContext(c)
+
+	sess, err := h.Sessions.Get(redisclient.Ctx, c.Param("id"))
+	if err != nil && !errors.Is(err, session.ErrNotFound) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve session", "detail": err.Error()})
+		return
+	}
+	// Other users' sessions look exactly like missing ones
+	if sess == nil || sess.UserID != user.ID {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Session not found"})
+		return
+	}
+
+	if err := h.Sessions.Revoke(redisclient.Ctx, user.ID, sess.ID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to revoke session", "detail": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"status":  "ok",
+		"message": "Session revoked",
+		"current": sess.ID == utils.RetrieveSessionIDFromContext(c),
+	})
+}
+
+// JWKS publishes the public keys tokens are signed with
+//
+//	@Summary		JSON Web Key Set
+//	@Description	Public keys for verifying access tokens; the token's kid header names the key
+//	@Tags			auth
+//	@Produce		json
+//	@Success		200	{object}	jwtkeys.JWKSet
+//	@Router			/.well-known/jwks.json [get]
+
+func (h *AuthHandler) JWKS(c *gin.Context) {
+	// verifiers usually refetch when they meet an unknown kid, so a short cache is safe
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.Keys.JWKS())
+}
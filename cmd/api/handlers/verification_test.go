@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestResendVerificationGivesNoAccountAway(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	models := database.NewModels(dbtest.New(t))
+	h := &AuthHandler{
+		Models: models,
+		Redis:  redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()}),
+		Mailer: &outbox{err: errors.New("smtp down")},
+		APIURL: "http://api.test",
+	}
+	router := gin.New()
+	router.POST("/verify/resend", h.ResendVerification)
+
+	unverified := insertUser(t, models, "unverified")
+	verified := insertUser(t, models, "verified")
+	if _, err := models.Users.MarkEmailVerified(verified.ID, verified.Email); err != nil {
+		t.Fatal(err)
+	}
+	emails := []string{unverified.Email, verified.Email, "nobody@example.com"}
+
+	var first, throttled []string
+	for _, email := range emails {
+		rec := postJSON(t, router, "/verify/resend", gin.H{"email": email})
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: got %d, want 200: %s", email, rec.Code, rec.Body.String())
+		}
+		first = append(first, rec.Body.String())
+
+		rec = postJSON(t, router, "/verify/resend", gin.H{"email": email})
+		if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s again: got %d with Retry-After %q, want 429 with one", email, rec.Code, rec.Header().Get("Retry-After"))
+		}
+		throttled = append(throttled, rec.Body.String())
+	}
+	h.mail.Wait()
+
+	for i := range emails[1:] {
+		if first[i+1] != first[0] || throttled[i+1] != throttled[0] {
+			t.Errorf("%s answered differently from %s", emails[i+1], emails[0])
+		}
+	}
+}
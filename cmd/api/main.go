@@ -1,17 +1,30 @@
 package main
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
+	"fmt"
 	"log"
+	"strings"
+	"time"
 
 	_ "github.com/joho/godotenv/autoload"
 	_ "github.com/mattn/go-sqlite3"
+	// profile time zones must resolve even on images without a zoneinfo database
+	_ "time/tzdata"
 	"github.com/muhamash/go-first-rest-api/cmd/api/handlers"
 	"github.com/muhamash/go-first-rest-api/cmd/api/middleware"
 	_ "github.com/muhamash/go-first-rest-api/docs"
 	redisclient "github.com/muhamash/go-first-rest-api/internal"
 	"github.com/muhamash/go-first-rest-api/internal/database"
 	"github.com/muhamash/go-first-rest-api/internal/env"
+	"github.com/muhamash/go-first-rest-api/internal/jwtkeys"
+	"github.com/muhamash/go-first-rest-api/internal/lockout"
+	"github.com/muhamash/go-first-rest-api/internal/mailer"
+	"github.com/muhamash/go-first-rest-api/internal/oidc"
+	"github.com/muhamash/go-first-rest-api/internal/passwordpolicy"
+	"github.com/muhamash/go-first-rest-api/internal/session"
 )
 
 // @title Go Gin Rest API
@@ -21,6 +34,10 @@ import (
 // @in header
 // @name Authorization
 // @description Enter your bearer token in the format **Bearer &lt;token&gt;**
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @description A personal API key created through /api/v1/auth/api-keys
 
 // Apply the security definition to your endpoints
 // @security BearerAuth
@@ -28,17 +45,21 @@ import (
 type application struct {
 	port int
 	models database.Models
-	jwtSecret string
 	auth *handlers.AuthHandler
 	event *handlers.EventHandler
 	attendee *handlers.AttendeeHandler
+	role *handlers.RoleHandler
+	admin *handlers.AdminHandler
 	authMiddleware *middleware.AuthMiddleware
+	requireVerifiedEmail bool
 	// utils *utils.RetrieveUserFromContext
 }
 
 func main() {
 
-	db, err := sql.Open("sqlite3", "./firstDatabase.db")
+	// Immediate transactions take the write lock up front, so the read-then-write
+	// registration transaction cannot interleave with another one
+	db, err := sql.Open("sqlite3", "./firstDatabase.db?_txlock=immediate&_busy_timeout=5000")
 	if err != nil {
 		log.Fatalf("Failed to connect to the database: %v", err)
 	}
@@ -46,26 +67,219 @@ func main() {
 	// Load Redis
 	redisURL := env.GetEnvString("REDIS_URL", "redis://localhost:6379/0")
 	redisClient := redisclient.NewClient(redisURL)
+	sessions := &session.Store{Redis: redisClient, TTL: 7 * 24 * time.Hour}
+	loginGuard := &lockout.Guard{
+		Redis: redisClient,
+		Policy: lockout.Policy{
+			MaxAttempts:      env.GetEnvInt("LOGIN_MAX_ATTEMPTS", 5),
+			MaxAttemptsPerIP: env.GetEnvInt("LOGIN_MAX_ATTEMPTS_PER_IP", 50),
+			Window:           env.GetEnvDuration("LOGIN_ATTEMPT_WINDOW", 15*time.Minute),
+			LockoutBase:      env.GetEnvDuration("LOGIN_LOCKOUT_BASE", time.Minute),
+			LockoutMax:       env.GetEnvDuration("LOGIN_LOCKOUT_MAX", time.Hour),
+		},
+	}
+
+	mail, err := mailer.New(
+		env.GetEnvString("MAILER", "log"),
+		env.GetEnvString("MAILER_DIR", "./tmp/mail"),
+		env.GetEnvString("SMTP_ADDR", ""),
+		env.GetEnvString("SMTP_USERNAME", ""),
+		env.GetEnvString("SMTP_PASSWORD", ""),
+		env.GetEnvString("MAIL_FROM", "no-reply@localhost"),
+	)
+	if err != nil {
+		log.Fatalf("Failed to configure mailer: %v", err)
+	}
 	
 	defer db.Close()
 
+	keys, err := loadSigningKeys(
+		env.GetEnvString("JWT_PRIVATE_KEY_FILE", ""),
+		env.GetEnvString("JWT_PUBLIC_KEY_FILES", ""),
+	)
+	if err != nil {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	oidcProviders, err := loadOIDCProviders(env.GetEnvString("OIDC_PROVIDERS", ""), env.GetEnvString("API_URL", "http://localhost:8080"))
+	if err != nil {
+		log.Fatalf("Failed to configure identity providers: %v", err)
+	}
+
+	passwordPolicy, err := loadPasswordPolicy(env.GetEnvBool("PASSWORD_CHECK_BREACHED", true), env.GetEnvString("PASSWORD_BREACH_LIST", ""))
+	if err != nil {
+		log.Fatalf("Failed to load the breached password list: %v", err)
+	}
+
+	mfaKeySetting := env.GetEnvString("MFA_ENCRYPTION_KEY", "")
+	mfaKey, err := loadMFAKey(mfaKeySetting)
+	if err != nil {
+		log.Fatalf("Failed to load the two-factor encryption key: %v", err)
+	}
+
+	purgeInterval := env.GetEnvDuration("ACCOUNT_PURGE_INTERVAL", time.Hour)
+	if purgeInterval <= 0 {
+		log.Fatalf("ACCOUNT_PURGE_INTERVAL must be a positive duration, got %s", purgeInterval)
+	}
+
 	models := database.NewModels(db)
+	models.MFA.Key = mfaKey
+	// secrets are only rewritten with a key that outlives this process
+	if mfaKeySetting != "" {
+		encrypted, err := models.MFA.EncryptStoredSecrets()
+		if err != nil {
+			log.Fatalf("Failed to encrypt stored two-factor secrets: %v", err)
+		}
+		if encrypted > 0 {
+			log.Printf("Encrypted %d two-factor secrets stored in plain text", encrypted)
+		}
+	}
 	app := &application{
 		port:      env.GetEnvInt("PORT", 8080),
 		models:    models,
-		jwtSecret: env.GetEnvString("JWT_SECRET", "muhamash_secret"),
 		auth: &handlers.AuthHandler{
 			Models:    models,
+			Keys:      keys,
 			Redis:     redisClient,
+			Sessions:  sessions,
+			LoginGuard: loginGuard,
+			Mailer:    mail,
+			AppURL:    env.GetEnvString("APP_URL", "http://localhost:3000"),
+			APIURL:    env.GetEnvString("API_URL", "http://localhost:8080"),
+			MFAIssuer: env.GetEnvString("MFA_ISSUER", "Go Gin Rest API"),
+			OIDCProviders: oidcProviders,
+			DeletionGrace: env.GetEnvDuration("ACCOUNT_DELETION_GRACE", 30*24*time.Hour),
+			PasswordPolicy: passwordPolicy,
 		},
-		event: 	   &handlers.EventHandler{Models: models},
+		event: 	   &handlers.EventHandler{Models: models, Mailer: mail, APIURL: env.GetEnvString("API_URL", "http://localhost:8080")},
 		attendee:  &handlers.AttendeeHandler{Models: models},
-		authMiddleware:  &middleware.AuthMiddleware{Models: models},
+		role:      &handlers.RoleHandler{Models: models},
+		admin:     &handlers.AdminHandler{Models: models, LoginGuard: loginGuard, Sessions: sessions, Keys: keys},
+		authMiddleware:  &middleware.AuthMiddleware{Models: models, Keys: keys, Redis: redisClient, Sessions: sessions},
+		requireVerifiedEmail: env.GetEnvBool("REQUIRE_VERIFIED_EMAIL", true),
 
 		// utils : &ut
 	}
 
+	if err := bootstrapAdmin(models, env.GetEnvString("ADMIN_EMAIL", "")); err != nil {
+		log.Fatalf("Failed to bootstrap admin: %v", err)
+	}
+
+	go app.purgeDeletedAccounts(purgeInterval)
+
 	if err := app.serve(); err != nil {
 		log.Fatalf("Failed to start the server: %v", err)
 	} 
-}
\ No newline at end of file
+}
+
+// bootstrapAdmin grants the admin role to the account registered with ADMIN_EMAIL,
+// so a fresh install has someone who can hand out roles through the API.
+func bootstrapAdmin(models database.Models, email string) error {
+	if email == "" {
+		return nil
+	}
+
+	user, err := models.Users.GetByEmail(email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		log.Printf("ADMIN_EMAIL %s is not registered yet; skipping admin bootstrap", email)
+		return nil
+	}
+
+	return models.Roles.Grant(user.ID, database.RoleAdmin)
+}
+
+// loadSigningKeys reads the JWT signing key and the comma-separated list of
+// older public keys still accepted during a rotation. Without a signing key a
+// throwaway one is generated, which signs everyone out on every restart.
+func loadSigningKeys(signingKeyFile, verificationKeyFiles string) (*jwtkeys.Manager, error) {
+	if signingKeyFile == "" {
+		log.Println("JWT_PRIVATE_KEY_FILE is not set; using an ephemeral signing key, tokens will not survive a restart")
+		return jwtkeys.Ephemeral()
+	}
+
+	files := []string{}
+	for _, file := range strings.Split(verificationKeyFiles, ",") {
+		if file = strings.TrimSpace(file); file != "" {
+			files = append(files, file)
+		}
+	}
+
+	return jwtkeys.Load(signingKeyFile, files)
+}
+
+// loadMFAKey decodes the base64 key that encrypts TOTP secrets, e.g. from
+// `openssl rand -base64 32`
+func loadMFAKey(encoded string) ([]byte, error) {
+	if encoded == "" {
+		log.Println("MFA_ENCRYPTION_KEY is not set; using an ephemeral key, two-factor enrollments will not survive a restart")
+		key := make([]byte, database.MFAKeySize)
+		_, err := rand.Read(key)
+		return key, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY is not base64: %w", err)
+	}
+	if len(key) != database.MFAKeySize {
+		return nil, fmt.Errorf("MFA_ENCRYPTION_KEY is %d bytes, want %d", len(key), database.MFAKeySize)
+	}
+	return key, nil
+}
+
+// loadPasswordPolicy reads the PASSWORD_* limits. The bundled breached password
+// list can be extended with a file of SHA-1 hashes, such as a Pwned Passwords download.
+func loadPasswordPolicy(checkBreached bool, breachListFile string) (*passwordpolicy.Policy, error) {
+	policy := &passwordpolicy.Policy{
+		MinLength:  env.GetEnvInt("PASSWORD_MIN_LENGTH", 10),
+		MaxBytes:   env.GetEnvInt("PASSWORD_MAX_BYTES", passwordpolicy.BcryptMaxBytes),
+		MinClasses: env.GetEnvInt("PASSWORD_MIN_CLASSES", 2),
+	}
+	if !checkBreached {
+		return policy, nil
+	}
+
+	if breachListFile == "" {
+		policy.Breached = passwordpolicy.DefaultBreachList()
+		return policy, nil
+	}
+
+	breached, err := passwordpolicy.LoadBreachList(breachListFile)
+	if err != nil {
+		return nil, err
+	}
+	policy.Breached = breached
+	return policy, nil
+}
+
+// loadOIDCProviders reads each provider named in the comma-separated list from
+// OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET, _SCOPES and, to point at a
+// local mock provider, _DISCOVERY_URL
+func loadOIDCProviders(names, apiURL string) (map[string]*oidc.Provider, error) {
+	providers := map[string]*oidc.Provider{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(name) + "_"
+		provider := &oidc.Provider{
+			Name:         name,
+			Issuer:       env.GetEnvString(prefix+"ISSUER", ""),
+			ClientID:     env.GetEnvString(prefix+"CLIENT_ID", ""),
+			ClientSecret: env.GetEnvString(prefix+"CLIENT_SECRET", ""),
+			DiscoveryURL: env.GetEnvString(prefix+"DISCOVERY_URL", ""),
+			Scopes:       strings.Fields(env.GetEnvString(prefix+"SCOPES", "openid email profile")),
+			RedirectURL:  strings.TrimSuffix(apiURL, "/") + "/api/v1/auth/oidc/" + name + "/callback",
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return nil, fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+		providers[name] = provider
+	}
+	return providers, nil
+}
@@ -1,82 +1,349 @@
 package middleware
 
 import (
+	"context"
+	"fmt"
+	"log"
 	"net/http"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/redis/go-redis/v9"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
 	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/jwtkeys"
+	"github.com/muhamash/go-first-rest-api/internal/session"
 )
 
 type AuthMiddleware struct {
 	Models    database.Models
-	JwtSecret string
+	Keys      *jwtkeys.Manager
 	Redis     *redis.Client
+	Sessions  *session.Store
 }
 
-func (a *AuthMiddleware) RequireAuth() gin.HandlerFunc {
+// how long RequireAuth waits on Redis before treating it as down
+const revocationCheckTimeout = time.Second
+
+type authConfig struct {
+	rejectUnverified bool
+	rejectAPIKeys    bool
+	allowAPIKeyWrites bool
+	rejectImpersonatedWrites bool
+	allowAnonymous   bool
+}
+
+// AuthOption tunes what RequireAuth accepts
+type AuthOption func(*authConfig)
+
+// RejectUnverified makes RequireAuth refuse write requests (anything but
+// GET, HEAD and OPTIONS) from users who have not verified their email yet
+func RejectUnverified() AuthOption {
+	return func(cfg *authConfig) {
+		cfg.rejectUnverified = true
+	}
+}
+
+// RejectAPIKeys limits a route to interactive logins, for endpoints that manage
+// credentials and sessions, which a leaked API key must not be able to touch
+func RejectAPIKeys() AuthOption {
+	return func(cfg *authConfig) {
+		cfg.rejectAPIKeys = true
+	}
+}
+
+// AllowAPIKeys lets API keys make changes through a route group; without it a
+// key can only read. Every write route in the group must run RequirePermission,
+// since that is where the key's scopes are enforced.
+func AllowAPIKeys() AuthOption {
+	return func(cfg *authConfig) {
+		cfg.allowAPIKeyWrites = true
+	}
+}
+
+// RejectImpersonatedWrites keeps impersonation tokens read-only on a route, for
+// endpoints that change credentials: support staff may look at what a user
+// sees there but not change their password, email, keys or sessions
+func RejectImpersonatedWrites() AuthOption {
+	return func(cfg *authConfig) {
+		cfg.rejectImpersonatedWrites = true
+	}
+}
+
+// AllowAnonymous lets requests without credentials through with no user in
+// the context, for public routes that show signed-in users more, such as
+// their own draft events. Credentials that are sent must still be valid.
+func AllowAnonymous() AuthOption {
+	return func(cfg *authConfig) {
+		cfg.allowAnonymous = true
+	}
+}
+
+// RequireAuth accepts either an "Authorization: Bearer <jwt>" access token or an
+// X-API-Key header, and puts the resolved *database.User in the context. A key's
+// scopes are enforced by RequirePermission, so a key may only make changes
+// on groups opened with AllowAPIKeys.
+func (a *AuthMiddleware) RequireAuth(options ...AuthOption) gin.HandlerFunc {
+	var cfg authConfig
+	for _, option := range options {
+		option(&cfg)
+	}
+
 	return func(c *gin.Context) {
-		authHeader := c.GetHeader("Authorization")
-		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header"})
-			c.Abort()
+		if cfg.allowAnonymous && c.GetHeader("Authorization") == "" && c.GetHeader("X-API-Key") == "" {
+			c.Next()
 			return
 		}
 
-		tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+		var user *database.User
+		var ok bool
+		if c.GetHeader("X-API-Key") != "" && c.GetHeader("Authorization") == "" {
+			if cfg.rejectAPIKeys || (!isSafeMethod(c.Request.Method) && !cfg.allowAPIKeyWrites) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "API keys cannot be used here; log in instead"})
+				c.Abort()
+				return
+			}
+			user, ok = a.authenticateAPIKey(c)
+		} else {
+			user, ok = a.authenticateToken(c)
+		}
+		if !ok {
 			c.Abort()
 			return
 		}
 
-		token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-				return nil, jwt.ErrSignatureInvalid
-			}
-			return []byte(a.JwtSecret), nil
-		})
-
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		if user.SuspendedAt != nil {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This account is suspended"})
 			c.Abort()
 			return
 		}
 
-		claims, ok := token.Claims.(jwt.MapClaims)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to parse claims"})
+		if cfg.rejectUnverified && user.EmailVerifiedAt == nil && !isSafeMethod(c.Request.Method) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Please verify your email address before making changes"})
 			c.Abort()
 			return
 		}
 
-		userIDFloat, ok := claims["user_id"].(float64)
-		if !ok {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID claim is missing or invalid"})
+		actor := utils.RetrieveActorFromContext(c)
+		if actor != nil && cfg.rejectImpersonatedWrites && !isSafeMethod(c.Request.Method) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "This cannot be changed while impersonating a user"})
 			c.Abort()
 			return
 		}
-		userID := int(userIDFloat)
-
-		user, err := a.Models.Users.Get(userID)
-		if err != nil {
-			c.JSON(http.StatusUnauthorized, gin.H{
-				"error":   "User not found in database",
-				"details": err.Error(),
-			})
+
+		if err := a.Models.Roles.LoadForUser(user); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user roles", "details": err.Error()})
 			c.Abort()
 			return
 		}
 
-		if user == nil {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found; Nil user", "id": userID})
-			c.Abort()
-			return
+		// a key can only use the permissions it was scoped to, and only while its owner still holds them
+		if scopes, exists := c.Get("api_key_scopes"); exists {
+			user.Permissions = intersect(user.Permissions, scopes.([]string))
 		}
 
 		c.Set("user", user)
 		c.Next()
+
+		if actor != nil && !isSafeMethod(c.Request.Method) {
+			a.auditImpersonatedWrite(c, actor, user)
+		}
+	}
+}
+
+// authenticateToken resolves an "Authorization: Bearer <jwt>" access token to its user
+func (a *AuthMiddleware) authenticateToken(c *gin.Context) (*database.User, bool) {
+	authHeader := c.GetHeader("Authorization")
+	if authHeader == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing Authorization header"})
+		return nil, false
+	}
+
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+	if tokenString == authHeader {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token format"})
+		return nil, false
+	}
+
+	token, err := a.Keys.Parse(tokenString)
+	if err != nil || !token.Valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		return nil, false
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Failed to parse claims"})
+		return nil, false
+	}
+
+	// refresh tokens are signed with the same keys but only work at /auth/refresh
+	if claims["typ"] != "access" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Not an access token"})
+		return nil, false
+	}
+
+	userIDFloat, ok := claims["user_id"].(float64)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User ID claim is missing or invalid"})
+		return nil, false
+	}
+	userID := int(userIDFloat)
+
+	tokenID, _ := claims["jti"].(string)
+	if tokenID == "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token cannot be revoked; please log in again"})
+		return nil, false
 	}
+	sessionID, _ := claims["sid"].(string)
+
+	if !a.checkRevocation(c, tokenID, sessionID) {
+		return nil, false
+	}
+
+	user, err := a.Models.Users.Get(userID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{
+			"error":   "User not found in database",
+			"details": err.Error(),
+		})
+		return nil, false
+	}
+
+	if user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not found; Nil user", "id": userID})
+		return nil, false
+	}
+
+	// impersonation tokens also name the admin acting as the user
+	if act, ok := claims["act"].(map[string]interface{}); ok {
+		actor, ok := a.authenticateActor(c, act)
+		if !ok {
+			return nil, false
+		}
+		c.Set("actor", actor)
+	}
+
+	c.Set("session_id", sessionID)
+	c.Set("token_id", tokenID)
+	if exp, ok := claims["exp"].(float64); ok {
+		c.Set("token_expires_at", time.Unix(int64(exp), 0))
+	}
+
+	return user, true
+}
+
+// authenticateActor checks the admin behind an impersonation token. The token
+// stops working as soon as that admin is suspended, deleted or loses the
+// permission to impersonate.
+func (a *AuthMiddleware) authenticateActor(c *gin.Context, act map[string]interface{}) (*database.User, bool) {
+	actorIDFloat, ok := act["user_id"].(float64)
+	if !ok {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Actor claim is invalid"})
+		return nil, false
+	}
+
+	actor, err := a.Models.Users.Get(int(actorIDFloat))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load impersonating user", "details": err.Error()})
+		return nil, false
+	}
+	if actor == nil || actor.SuspendedAt != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Impersonation is no longer allowed"})
+		return nil, false
+	}
+
+	if err := a.Models.Roles.LoadForUser(actor); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load user roles", "details": err.Error()})
+		return nil, false
+	}
+	if !actor.HasPermission(database.PermUsersImpersonate) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Impersonation is no longer allowed"})
+		return nil, false
+	}
+
+	return actor, true
+}
+
+// auditImpersonatedWrite records a change made while an admin acted as a user
+func (a *AuthMiddleware) auditImpersonatedWrite(c *gin.Context, actor, user *database.User) {
+	if err := a.Models.Audit.Record(&database.AuditEntry{
+		Action:       database.AuditImpersonatedWrite,
+		ActorId:      &actor.ID,
+		TargetUserId: &user.ID,
+		IP:           c.ClientIP(),
+		Detail:       fmt.Sprintf("%s %s -> %d", c.Request.Method, c.Request.URL.Path, c.Writer.Status()),
+	}); err != nil {
+		log.Printf("failed to record audit entry: %v", err)
+	}
+}
+
+// authenticateAPIKey resolves an X-API-Key header to the key's owner
+func (a *AuthMiddleware) authenticateAPIKey(c *gin.Context) (*database.User, bool) {
+	key, err := a.Models.APIKeys.GetByHash(utils.HashToken(c.GetHeader("X-API-Key")))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check API key", "details": err.Error()})
+		return nil, false
+	}
+	if key == nil || !key.Active(time.Now()) {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+		return nil, false
+	}
+
+	user, err := a.Models.Users.Get(key.UserId)
+	if err != nil || user == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid, expired or revoked API key"})
+		return nil, false
+	}
+
+	if err := a.Models.APIKeys.Touch(key.Id); err != nil {
+		log.Printf("failed to record api key use: %v", err)
+	}
+
+	c.Set("api_key_id", key.Id)
+	c.Set("api_key_scopes", key.Scopes)
+	return user, true
+}
+
+func intersect(values, allowed []string) []string {
+	result := []string{}
+	for _, value := range values {
+		if slices.Contains(allowed, value) {
+			result = append(result, value)
+		}
+	}
+	return result
+}
+
+// checkRevocation rejects tokens that were logged out or whose session was revoked.
+// When Redis cannot be reached the check fails open for reads, so browsing keeps
+// working during an outage, and fails closed for writes, since a stolen token
+// that was already revoked must not be able to change anything.
+func (a *AuthMiddleware) checkRevocation(c *gin.Context, tokenID, sessionID string) bool {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), revocationCheckTimeout)
+	defer cancel()
+
+	revoked, err := a.Sessions.AccessTokenRevoked(ctx, tokenID, sessionID)
+	if err != nil {
+		log.Printf("token revocation check unavailable: %v", err)
+		if isSafeMethod(c.Request.Method) {
+			return true
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Cannot verify your session right now; please try again shortly"})
+		return false
+	}
+
+	if revoked {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Token has been revoked"})
+		return false
+	}
+
+	return true
+}
+
+func isSafeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
 }
@@ -0,0 +1,38 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRequestLoggerRedactsFeedTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var out bytes.Buffer
+	defaultWriter := gin.DefaultWriter
+	gin.DefaultWriter = &out
+	t.Cleanup(func() { gin.DefaultWriter = defaultWriter })
+
+	router := gin.New()
+	router.Use(RequestLogger())
+	router.GET("/api/v1/calendar/:token", func(c *gin.Context) { c.Status(http.StatusOK) })
+	router.GET("/api/v1/events", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	for _, path := range []string{"/api/v1/calendar/s3cr3t-feed-token.ics?x=1", "/api/v1/events"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	logged := out.String()
+	if strings.Contains(logged, "s3cr3t") {
+		t.Errorf("feed token in the log: %s", logged)
+	}
+	for _, want := range []string{`"/api/v1/calendar/[redacted]"`, `"/api/v1/events"`} {
+		if !strings.Contains(logged, want) {
+			t.Errorf("log lacks %s: %s", want, logged)
+		}
+	}
+}
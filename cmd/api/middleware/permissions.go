@@ -0,0 +1,36 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/utils"
+)
+
+// RequirePermission rejects the request unless the authenticated user holds every
+// listed permission. Roles are read from the database by RequireAuth rather than
+// trusted from the token claims, so a revoked role takes effect immediately; it
+// must therefore run after RequireAuth.
+func (a *AuthMiddleware) RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := utils.RetrieveUserFromContext(c)
+		if user.ID == 0 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			c.Abort()
+			return
+		}
+
+		for _, permission := range permissions {
+			if !user.HasPermission(permission) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error":      "You do not have permission to perform this action",
+					"permission": permission,
+				})
+				c.Abort()
+				return
+			}
+		}
+
+		c.Next()
+	}
+}
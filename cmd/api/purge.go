@@ -0,0 +1,57 @@
+package main
+
+import (
+	"log"
+	"time"
+
+	redisclient "github.com/muhamash/go-first-rest-api/internal"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+)
+
+// purgeDeletedAccounts deletes, every interval, the accounts whose deletion
+// grace period has ended. It runs for the lifetime of the server.
+func (app *application) purgeDeletedAccounts(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		app.purgeDueAccounts(time.Now())
+		<-ticker.C
+	}
+}
+
+func (app *application) purgeDueAccounts(now time.Time) {
+	ids, err := app.models.Users.DueForDeletion(now)
+	if err != nil {
+		log.Printf("account purge: failed to find accounts due for deletion: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		// checked again as it is deleted, in case the user restored the account since
+		deleted, err := app.models.Users.PurgeDue(id, now)
+		if err != nil {
+			log.Printf("account purge: failed to delete user %d: %v", id, err)
+			continue
+		}
+		if !deleted {
+			continue
+		}
+
+		// the user signed out when they asked for deletion, but a restore and
+		// a new login may have happened before the next purge picked it up
+		if err := app.auth.Sessions.RevokeAll(redisclient.Ctx, id); err != nil {
+			log.Printf("account purge: failed to revoke sessions of user %d: %v", id, err)
+		}
+
+		userId := id
+		if err := app.models.Audit.Record(&database.AuditEntry{
+			Action:       database.AuditAccountDeleted,
+			TargetUserId: &userId,
+			Detail:       "deletion grace period ended",
+		}); err != nil {
+			log.Printf("account purge: failed to record audit entry: %v", err)
+		}
+		log.Printf("account purge: deleted user %d", id)
+	}
+}
@@ -5,49 +5,143 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/cmd/api/middleware"
+	"github.com/muhamash/go-first-rest-api/internal/database"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
 func (app *application) routes() http.Handler {
 
-	g := gin.Default()
+	// gin.Default's logger would write calendar feed tokens to the access log
+	g := gin.New()
+	g.Use(middleware.RequestLogger(), gin.Recovery())
 	g.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"http://localhost:8088", "http://localhost:3000"},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "X-API-Key"},
 		ExposeHeaders:    []string{"Content-Length"},
 		AllowCredentials: true,
 	}))
 	
 
+	// Other services verify our tokens against these public keys
+	g.GET("/.well-known/jwks.json", app.auth.JWKS)
+
 	// Define the versions of the routes for the application
 	v1 := g.Group("/api/v1")
 	{
 		
-		v1.GET("/events", app.event.GetAllEvent)
-		v1.GET("/events/:id", app.event.GetEvent)
+		v1.GET("/calendar/:token", app.event.GetCalendarFeed)
 
 
 		v1.POST("/auth/register", app.auth.RegisterUser)
-		v1.GET("/auth/users", app.auth.GetAllUsers)
 		v1.POST("/auth/login", app.auth.LoginUser)
+		v1.POST("/auth/refresh", app.auth.RefreshToken)
+		v1.POST("/auth/mfa/verify", app.auth.VerifyMFA)
+		v1.POST("/auth/magic-link", app.auth.SendMagicLink)
+		v1.POST("/auth/magic-link/verify", app.auth.MagicLinkLogin)
+		v1.GET("/me/email/confirm", app.auth.ConfirmEmailChange)
+		v1.GET("/auth/oidc/:provider/login", app.auth.OIDCLogin)
+		v1.GET("/auth/oidc/:provider/callback", app.auth.OIDCCallback)
+		v1.POST("/auth/password/forgot", app.auth.ForgotPassword)
+		v1.POST("/auth/password/reset", app.auth.ResetPassword)
+		v1.GET("/auth/verify", app.auth.VerifyEmail)
+		v1.POST("/auth/verify/resend", app.auth.ResendVerification)
+	}
+
+	// Events are public, but signed-in owners also see their drafts
+	publicEventGroup := v1.Group("/")
+	publicEventGroup.Use(app.authMiddleware.RequireAuth(middleware.AllowAnonymous()))
+	{
+		publicEventGroup.GET("/events", app.event.GetAllEvent)
+		publicEventGroup.GET("/events/search", app.event.SearchEvents)
+		publicEventGroup.GET("/events/:id", app.event.GetEvent)
+		publicEventGroup.GET("/events/:id/occurrences", app.event.GetEventOccurrences)
+		publicEventGroup.GET("/events/:id/ics", app.event.GetEventICS)
+	}
+
+	// Event and attendee writes can be limited to verified accounts. API keys may
+	// write here, so every write route must check a permission.
+	eventAuthOptions := []middleware.AuthOption{middleware.AllowAPIKeys()}
+	if app.requireVerifiedEmail {
+		eventAuthOptions = append(eventAuthOptions, middleware.RejectUnverified())
+	}
+
+	eventGroup := v1.Group("/")
+	eventGroup.Use(app.authMiddleware.RequireAuth(eventAuthOptions...))
+	{
+		eventGroup.POST("/events", app.authMiddleware.RequirePermission(database.PermEventsCreate), app.event.CreateEvent)
+		eventGroup.POST("/events/import", app.authMiddleware.RequirePermission(database.PermEventsCreate), app.event.ImportEvents)
+		eventGroup.PUT("/events/:id", app.authMiddleware.RequirePermission(database.PermEventsUpdate), app.event.UpdateEvent)
+		eventGroup.DELETE("/events/:id", app.authMiddleware.RequirePermission(database.PermEventsDelete), app.event.DeleteEvent)
+		eventGroup.POST("/events/:id/publish", app.authMiddleware.RequirePermission(database.PermEventsUpdate), app.event.PublishEvent)
+		eventGroup.POST("/events/:id/cancel", app.authMiddleware.RequirePermission(database.PermEventsUpdate), app.event.CancelEvent)
+		eventGroup.POST("/events/:id/complete", app.authMiddleware.RequirePermission(database.PermEventsUpdate), app.event.CompleteEvent)
+		eventGroup.PATCH("/events/:id/occurrences/:occurrence", app.authMiddleware.RequirePermission(database.PermEventsUpdate), app.event.UpdateOccurrence)
+		eventGroup.DELETE("/events/:id/occurrences/:occurrence", app.authMiddleware.RequirePermission(database.PermEventsUpdate), app.event.CancelOccurrence)
+		eventGroup.POST("/events/:id/attendees/:userId", app.authMiddleware.RequirePermission(database.PermAttendeesRegister), app.attendee.RegisterAttendeeToEvent)
+		eventGroup.PATCH("/events/:id/attendees/:userId", app.authMiddleware.RequirePermission(database.PermAttendeesRegister), app.attendee.UpdateAttendeeStatus)
+		eventGroup.GET("/events/attendees/:id", app.attendee.GetAttendeesForEvent)
+		eventGroup.GET("/attendees/events/:userId", app.attendee.GetEventsByAttendee)
+		eventGroup.DELETE("/events/attendees/:id/:userId", app.authMiddleware.RequirePermission(database.PermAttendeesRegister), app.attendee.DeleteAttendeeFromEvent)
 	}
 
 	authGroup := v1.Group("/")
-	authGroup.Use(app.authMiddleware.RequireAuth())
+	// credentials and sessions are only managed from an interactive login
+	authGroup.Use(app.authMiddleware.RequireAuth(middleware.RejectAPIKeys(), middleware.RejectImpersonatedWrites()))
 	{
-		authGroup.POST("/events", app.event.CreateEvent)
-		authGroup.PUT("/events/:id", app.event.UpdateEvent)
-		authGroup.DELETE("/events/:id", app.event.DeleteEvent)
-		authGroup.POST("/events/:eventId/attendees/:userId", app.attendee.RegisterAttendeeToEvent)
-		authGroup.GET("/events/attendees/:eventId", app.attendee.GetAttendeesForEvent)
-		authGroup.GET("/attendees/events/:userId", app.attendee.GetEventsByAttendee)
-		authGroup.DELETE("/events/attendees/:eventId/:userId", app.attendee.DeleteAttendeeFromEvent)
-
-		authGroup.POST("/auth/refresh", app.auth.RefreshToken)
 		authGroup.POST("/auth/logout/:id", app.auth.LogoutUser)
+		authGroup.GET("/auth/sessions", app.auth.GetSessions)
+		authGroup.DELETE("/auth/sessions/:id", app.auth.RevokeSession)
+		authGroup.POST("/auth/mfa/enroll", app.auth.EnrollMFA)
+		authGroup.POST("/auth/mfa/confirm", app.auth.ConfirmMFA)
+		authGroup.DELETE("/auth/mfa", app.auth.DisableMFA)
+		authGroup.GET("/auth/api-keys", app.auth.GetAPIKeys)
+		authGroup.POST("/auth/api-keys", app.auth.CreateAPIKey)
+		authGroup.DELETE("/auth/api-keys/:id", app.auth.RevokeAPIKey)
+
+	}
+
+	// the only write an impersonation token can make to its own credentials is ending itself
+	impersonationGroup := v1.Group("/auth/impersonation")
+	impersonationGroup.Use(app.authMiddleware.RequireAuth(middleware.RejectAPIKeys()))
+	{
+		impersonationGroup.POST("/stop", app.admin.StopImpersonation)
+	}
+
+	meGroup := v1.Group("/me")
+	meGroup.Use(app.authMiddleware.RequireAuth(middleware.RejectAPIKeys(), middleware.RejectImpersonatedWrites()))
+	{
+		meGroup.GET("", app.auth.GetMe)
+		meGroup.PATCH("", app.auth.UpdateMe)
+		meGroup.DELETE("", app.auth.DeleteMe)
+		meGroup.GET("/export", app.auth.ExportMe)
+		meGroup.POST("/restore", app.auth.RestoreMe)
+		meGroup.POST("/password", app.auth.ChangePassword)
+		meGroup.GET("/calendar-feed", app.auth.GetCalendarFeedStatus)
+		meGroup.POST("/calendar-feed", app.auth.CreateCalendarFeed)
+		meGroup.DELETE("/calendar-feed", app.auth.RevokeCalendarFeed)
+	}
+
+	adminGroup := v1.Group("/admin")
+	adminGroup.Use(app.authMiddleware.RequireAuth(middleware.RejectAPIKeys(), middleware.RejectImpersonatedWrites()))
+	{
+		manageRoles := app.authMiddleware.RequirePermission(database.PermRolesManage)
+		adminGroup.GET("/roles", manageRoles, app.role.GetAllRoles)
+		adminGroup.GET("/users/:id/roles", manageRoles, app.role.GetUserRoles)
+		adminGroup.POST("/users/:id/roles", manageRoles, app.role.GrantRole)
+		adminGroup.DELETE("/users/:id/roles/:role", manageRoles, app.role.RevokeRole)
 
+		manageUsers := app.authMiddleware.RequirePermission(database.PermUsersManage)
+		adminGroup.GET("/users", manageUsers, app.admin.ListUsers)
+		adminGroup.GET("/users/:id", manageUsers, app.admin.GetUser)
+		adminGroup.DELETE("/users/:id", manageUsers, app.admin.DeleteUser)
+		adminGroup.POST("/users/:id/suspend", manageUsers, app.admin.SuspendUser)
+		adminGroup.POST("/users/:id/unsuspend", manageUsers, app.admin.UnsuspendUser)
+		adminGroup.POST("/users/:id/logout", manageUsers, app.admin.LogoutUser)
+		adminGroup.POST("/users/:id/unlock", manageUsers, app.admin.UnlockUser)
+		adminGroup.POST("/users/:id/impersonate", app.authMiddleware.RequirePermission(database.PermUsersImpersonate), app.admin.ImpersonateUser)
 	}
 
 	g.GET("/swagger/*any", func(c *gin.Context) {
@@ -1,6 +1,8 @@
 package utils
 
 import (
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"github.com/muhamash/go-first-rest-api/internal/database"
 )
@@ -18,4 +20,25 @@ func RetrieveUserFromContext(c *gin.Context) *database.User {
 	}
 
 	return user
-}
\ No newline at end of file
+}
+// RetrieveSessionIDFromContext returns the session the request's access token belongs to, or ""
+func RetrieveSessionIDFromContext(c *gin.Context) string {
+	return c.GetString("session_id")
+}
+
+// RetrieveTokenFromContext returns the id and expiry of the request's access token
+func RetrieveTokenFromContext(c *gin.Context) (string, time.Time) {
+	return c.GetString("token_id"), c.GetTime("token_expires_at")
+}
+
+// RetrieveActorFromContext returns the admin acting as the request's user when the
+// request was made with an impersonation token, or nil
+func RetrieveActorFromContext(c *gin.Context) *database.User {
+	actorAny, exists := c.Get("actor")
+	if !exists {
+		return nil
+	}
+
+	actor, _ := actorAny.(*database.User)
+	return actor
+}
@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/muhamash/go-first-rest-api/internal/database"
+)
+
+// ParseListQuery reads limit, offset, cursor, sort and the spec's filters from
+// the query string. sort takes a field name, prefixed with "-" for descending.
+func ParseListQuery(c *gin.Context, spec database.ListSpec) (database.ListQuery, error) {
+	q := database.ListQuery{
+		Limit:   database.DefaultPageSize,
+		Filters: map[string]string{},
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > database.MaxPageSize {
+			return q, fmt.Errorf("limit must be a number between 1 and %d", database.MaxPageSize)
+		}
+		q.Limit = limit
+	}
+
+	if value := c.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return q, fmt.Errorf("offset must be a non-negative number")
+		}
+		q.Offset = offset
+	}
+
+	q.Cursor = c.Query("cursor")
+	if q.Cursor != "" && q.Offset != 0 {
+		return q, fmt.Errorf("cursor and offset cannot be combined")
+	}
+
+	if value := c.Query("sort"); value != "" {
+		q.Desc = strings.HasPrefix(value, "-")
+		q.Sort = strings.TrimPrefix(value, "-")
+		if _, ok := spec.Sorts[q.Sort]; !ok {
+			return q, fmt.Errorf("cannot sort by %q; allowed fields: %s", q.Sort, strings.Join(sortNames(spec), ", "))
+		}
+	}
+
+	for name, kind := range spec.Filters {
+		value := strings.TrimSpace(c.Query(name))
+		if value == "" {
+			continue
+		}
+
+		switch kind {
+		case database.FilterInt:
+			if _, err := strconv.Atoi(value); err != nil {
+				return q, fmt.Errorf("filter %q must be a number", name)
+			}
+		case database.FilterTime:
+			if _, err := database.ParseFilterTime(value); err != nil {
+				return q, fmt.Errorf("filter %q must be a date (YYYY-MM-DD) or RFC 3339 timestamp", name)
+			}
+		}
+
+		if allowed, ok := spec.Allowed[name]; ok {
+			for _, part := range strings.Split(value, ",") {
+				if !slices.Contains(allowed, strings.TrimSpace(part)) {
+					return q, fmt.Errorf("filter %q must be one of: %s", name, strings.Join(allowed, ", "))
+				}
+			}
+		}
+		q.Filters[name] = value
+	}
+
+	return q, nil
+}
+
+func sortNames(spec database.ListSpec) []string {
+	names := make([]string, 0, len(spec.Sorts))
+	for name := range spec.Sorts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,60 @@
+CREATE TABLE IF NOT EXISTS roles (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(50) NOT NULL UNIQUE,
+    description TEXT NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name VARCHAR(100) NOT NULL UNIQUE
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_id INT NOT NULL,
+    permission_id INT NOT NULL,
+    PRIMARY KEY (role_id, permission_id),
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+    FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+    user_id INT NOT NULL,
+    role_id INT NOT NULL,
+    granted_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, role_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE
+);
+
+INSERT INTO roles (name, description) VALUES
+    ('admin', 'Full access to every event, attendee list and role assignment'),
+    ('organizer', 'Can create events and manage their own events and attendee lists'),
+    ('member', 'Can browse events and register for them');
+
+INSERT INTO permissions (name) VALUES
+    ('events:create'),
+    ('events:update'),
+    ('events:delete'),
+    ('events:manage_any'),
+    ('attendees:register'),
+    ('attendees:manage'),
+    ('roles:manage');
+
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin';
+
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r, permissions p
+    WHERE r.name = 'organizer'
+      AND p.name IN ('events:create', 'events:update', 'events:delete', 'attendees:register', 'attendees:manage');
+
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r, permissions p
+    WHERE r.name = 'member' AND p.name IN ('attendees:register');
+
+-- every existing account becomes a member, and anyone who already owns an event keeps organizer rights
+INSERT INTO user_roles (user_id, role_id)
+    SELECT u.id, r.id FROM users u, roles r WHERE r.name = 'member';
+
+INSERT INTO user_roles (user_id, role_id)
+    SELECT DISTINCT e.owner_id, r.id FROM events e, roles r WHERE r.name = 'organizer';
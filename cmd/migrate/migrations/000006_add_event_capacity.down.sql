@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_attendees_event_registration;
+ALTER TABLE attendees DROP COLUMN registered_at;
+ALTER TABLE attendees DROP COLUMN registration_status;
+ALTER TABLE events DROP COLUMN capacity;
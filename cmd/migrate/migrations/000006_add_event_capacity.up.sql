@@ -0,0 +1,8 @@
+-- NULL capacity means the event admits any number of attendees
+ALTER TABLE events ADD COLUMN capacity INT CHECK (capacity IS NULL OR capacity > 0);
+
+ALTER TABLE attendees ADD COLUMN registration_status VARCHAR(20) NOT NULL DEFAULT 'confirmed'
+    CHECK (registration_status IN ('confirmed', 'waitlisted'));
+ALTER TABLE attendees ADD COLUMN registered_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_attendees_event_registration ON attendees (event_id, registration_status, id);
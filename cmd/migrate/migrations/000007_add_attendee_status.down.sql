@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_attendees_user_status;
+ALTER TABLE attendees DROP COLUMN status_updated_at;
+ALTER TABLE attendees DROP COLUMN status;
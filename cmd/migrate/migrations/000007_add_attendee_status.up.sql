@@ -0,0 +1,8 @@
+ALTER TABLE attendees ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'going'
+    CHECK (status IN ('invited', 'pending', 'going', 'maybe', 'declined', 'checked_in'));
+ALTER TABLE attendees ADD COLUMN status_updated_at DATETIME;
+
+-- waitlisted registrations are still waiting for a seat
+UPDATE attendees SET status = 'pending' WHERE registration_status = 'waitlisted';
+
+CREATE INDEX IF NOT EXISTS idx_attendees_user_status ON attendees (user_id, status);
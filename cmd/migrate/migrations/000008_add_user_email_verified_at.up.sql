@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN email_verified_at DATETIME;
+
+-- accounts created before verification existed are trusted as they are
+UPDATE users SET email_verified_at = CURRENT_TIMESTAMP;
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_mfa_recovery_codes_user;
+DROP TABLE IF EXISTS mfa_recovery_codes;
+DROP TABLE IF EXISTS user_mfa;
@@ -0,0 +1,6 @@
+DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE name = 'users:manage');
+DELETE FROM permissions WHERE name = 'users:manage';
+
+DROP INDEX IF EXISTS idx_audit_log_action;
+DROP INDEX IF EXISTS idx_audit_log_target;
+DROP TABLE IF EXISTS audit_log;
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    action VARCHAR(100) NOT NULL,
+    -- who did it; NULL for the system or an anonymous client
+    actor_id INT,
+    target_user_id INT,
+    ip VARCHAR(64) NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_target ON audit_log (target_user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log (action, created_at);
+
+INSERT INTO permissions (name) VALUES ('users:manage');
+
+INSERT INTO role_permissions (role_id, permission_id)
+    SELECT r.id, p.id FROM roles r, permissions p WHERE r.name = 'admin' AND p.name = 'users:manage';
@@ -0,0 +1,4 @@
+ALTER TABLE users DROP COLUMN timezone;
+ALTER TABLE users DROP COLUMN avatar_url;
+ALTER TABLE users DROP COLUMN bio;
+ALTER TABLE users DROP COLUMN display_name;
@@ -0,0 +1,4 @@
+-- set while an account waits out its deletion grace period
+ALTER TABLE users ADD COLUMN deletion_scheduled_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_users_deletion_scheduled_at ON users (deletion_scheduled_at);
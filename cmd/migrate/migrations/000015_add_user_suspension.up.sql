@@ -0,0 +1,3 @@
+-- suspended users cannot sign in or use existing tokens and API keys
+ALTER TABLE users ADD COLUMN suspended_at DATETIME;
+ALTER TABLE users ADD COLUMN suspension_reason TEXT NOT NULL DEFAULT '';
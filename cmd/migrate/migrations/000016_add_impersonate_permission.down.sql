@@ -0,0 +1,2 @@
+DELETE FROM role_permissions WHERE permission_id IN (SELECT id FROM permissions WHERE name = 'users:impersonate');
+DELETE FROM permissions WHERE name = 'users:impersonate';
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_attendees_event_registration;
+ALTER TABLE attendees DROP COLUMN occurrence;
+CREATE INDEX IF NOT EXISTS idx_attendees_event_registration ON attendees (event_id, registration_status, id);
+
+DROP TABLE IF EXISTS event_occurrence_overrides;
+ALTER TABLE events DROP COLUMN exdates;
+ALTER TABLE events DROP COLUMN rrule;
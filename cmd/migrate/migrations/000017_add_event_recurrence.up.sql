@@ -0,0 +1,27 @@
+-- an RFC 5545 RRULE for recurring events; empty for one-off events
+ALTER TABLE events ADD COLUMN rrule TEXT NOT NULL DEFAULT '';
+-- comma-separated UTC starts (RFC 3339) of occurrences removed from the series
+ALTER TABLE events ADD COLUMN exdates TEXT NOT NULL DEFAULT '';
+
+-- edits to occurrences of a recurring event. occurrence is the UTC start the
+-- rule gives the occurrence; scope 'this' changes only that occurrence and
+-- 'following' changes it and every later one. NULL fields keep the series value.
+CREATE TABLE IF NOT EXISTS event_occurrence_overrides (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_id INT NOT NULL,
+    occurrence VARCHAR(20) NOT NULL,
+    scope VARCHAR(10) NOT NULL CHECK (scope IN ('this', 'following')),
+    name VARCHAR(50),
+    description TEXT,
+    date DATETIME,
+    location TEXT,
+    capacity INT CHECK (capacity IS NULL OR capacity > 0),
+    UNIQUE (event_id, occurrence, scope),
+    FOREIGN KEY (event_id) REFERENCES events(id) ON DELETE CASCADE
+);
+
+-- registrations for a recurring event belong to one occurrence; '' for one-off events
+ALTER TABLE attendees ADD COLUMN occurrence VARCHAR(20) NOT NULL DEFAULT '';
+
+DROP INDEX IF EXISTS idx_attendees_event_registration;
+CREATE INDEX IF NOT EXISTS idx_attendees_event_registration ON attendees (event_id, occurrence, registration_status, id);
@@ -0,0 +1,8 @@
+-- the secret token in a user's calendar feed URL; one per user, stored hashed
+CREATE TABLE IF NOT EXISTS calendar_feeds (
+    user_id INT PRIMARY KEY,
+    token_hash TEXT NOT NULL UNIQUE,
+    created_at DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
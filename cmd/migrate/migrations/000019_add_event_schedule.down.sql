@@ -0,0 +1,10 @@
+ALTER TABLE event_occurrence_overrides DROP COLUMN ends_at;
+ALTER TABLE event_occurrence_overrides RENAME COLUMN starts_at TO date;
+
+DROP INDEX IF EXISTS idx_events_starts_at;
+ALTER TABLE events ADD COLUMN date DATE NOT NULL DEFAULT '1970-01-01';
+UPDATE events SET date = starts_at WHERE starts_at IS NOT NULL;
+
+ALTER TABLE events DROP COLUMN timezone;
+ALTER TABLE events DROP COLUMN ends_at;
+ALTER TABLE events DROP COLUMN starts_at;
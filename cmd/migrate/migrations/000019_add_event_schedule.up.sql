@@ -0,0 +1,16 @@
+-- events get a start, an end and the IANA time zone they are scheduled in;
+-- both times are stored in UTC
+ALTER TABLE events ADD COLUMN starts_at DATETIME;
+ALTER TABLE events ADD COLUMN ends_at DATETIME;
+ALTER TABLE events ADD COLUMN timezone VARCHAR(64) NOT NULL DEFAULT 'UTC';
+
+-- dates were stored with whatever offset they were sent in, so only the instant
+-- is known: keep it in UTC and give every existing event one hour
+UPDATE events SET starts_at = datetime(date), ends_at = datetime(date, '+1 hour');
+
+ALTER TABLE events DROP COLUMN date;
+CREATE INDEX IF NOT EXISTS idx_events_starts_at ON events (starts_at);
+
+ALTER TABLE event_occurrence_overrides RENAME COLUMN date TO starts_at;
+UPDATE event_occurrence_overrides SET starts_at = datetime(starts_at) WHERE starts_at IS NOT NULL;
+ALTER TABLE event_occurrence_overrides ADD COLUMN ends_at DATETIME;
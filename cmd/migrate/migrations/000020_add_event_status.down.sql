@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_events_status_owner;
+ALTER TABLE events DROP COLUMN status_changed_at;
+ALTER TABLE events DROP COLUMN status;
@@ -0,0 +1,6 @@
+-- events created before drafts existed were public, so they stay published
+ALTER TABLE events ADD COLUMN status VARCHAR(20) NOT NULL DEFAULT 'published'
+    CHECK (status IN ('draft', 'published', 'cancelled', 'completed'));
+ALTER TABLE events ADD COLUMN status_changed_at DATETIME;
+
+CREATE INDEX IF NOT EXISTS idx_events_status_owner ON events (status, owner_id);
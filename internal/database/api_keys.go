@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+type APIKey struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"userId"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expiresAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+// Active reports whether the key can still authenticate
+func (k *APIKey) Active(now time.Time) bool {
+	if k.RevokedAt != nil {
+		return false
+	}
+	return k.ExpiresAt == nil || now.Before(*k.ExpiresAt)
+}
+
+const apiKeyColumns = "id, user_id, name, prefix, scopes, expires_at, last_used_at, created_at, revoked_at"
+
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	if err := row.Scan(&key.Id, &key.UserId, &key.Name, &key.Prefix, &scopes, &key.ExpiresAt, &key.LastUsedAt, &key.CreatedAt, &key.RevokedAt); err != nil {
+		return nil, err
+	}
+
+	key.Scopes = []string{}
+	for _, scope := range strings.Split(scopes, ",") {
+		if scope != "" {
+			key.Scopes = append(key.Scopes, scope)
+		}
+	}
+
+	return &key, nil
+}
+
+// store a new key; only its hash is kept
+func (m *APIKeyModel) Insert(key *APIKey, keyHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	key.CreatedAt = time.Now().UTC()
+	query := "INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at, created_at) VALUES ($1, $2, $3, $4, $5, $6, $7)"
+	result, err := m.DB.ExecContext(ctx, query, key.UserId, key.Name, key.Prefix, keyHash, strings.Join(key.Scopes, ","), key.ExpiresAt, key.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	key.Id = int(id)
+
+	return nil
+}
+
+// look a key up by the hash of the value the client sent
+func (m *APIKeyModel) GetByHash(keyHash string) (*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE key_hash = $1"
+	key, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, keyHash))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	return key, err
+}
+
+// list a user's keys that have not been revoked
+func (m *APIKeyModel) ListForUser(userId int) ([]*APIKey, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + apiKeyColumns + " FROM api_keys WHERE user_id = $1 AND revoked_at IS NULL ORDER BY created_at DESC"
+	rows, err := m.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
+
+// revoke one of a user's keys
+func (m *APIKeyModel) Revoke(userId, id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE api_keys SET revoked_at = $1 WHERE id = $2 AND user_id = $3 AND revoked_at IS NULL"
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), id, userId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// record that a key was used, at most once a minute so busy keys do not write on every request
+func (m *APIKeyModel) Touch(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	query := "UPDATE api_keys SET last_used_at = $1 WHERE id = $2 AND (last_used_at IS NULL OR last_used_at < $3)"
+	_, err := m.DB.ExecContext(ctx, query, now, id, now.Add(-time.Minute))
+	return err
+}
@@ -3,6 +3,8 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"strings"
 	"time"
 )
 
@@ -11,33 +13,204 @@ type AttendeeModel struct {
 }
 
 type Attendee struct {
-	Id      int `json:"id"`
-	UserId  int `json:"userId"`
-	EventId int `json:"eventId"`
+	Id                 int        `json:"id"`
+	UserId             int        `json:"userId"`
+	EventId            int        `json:"eventId"`
+	// Occurrence is the occurrence id (see OccurrenceKey) of a recurring event; empty for one-off events
+	Occurrence         string     `json:"occurrence,omitempty"`
+	RegistrationStatus string     `json:"registrationStatus"`
+	RegisteredAt       *time.Time `json:"registeredAt"`
+	Status             string     `json:"status"`
+	StatusUpdatedAt    *time.Time `json:"statusUpdatedAt"`
 }
 
-func (m *AttendeeModel) Insert(attendee *Attendee) (*Attendee, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// registration statuses; waitlisted attendees are promoted in registration order
+const (
+	RegistrationConfirmed  = "confirmed"
+	RegistrationWaitlisted = "waitlisted"
+)
+
+// RSVP statuses
+const (
+	StatusInvited   = "invited"
+	StatusPending   = "pending"
+	StatusGoing     = "going"
+	StatusMaybe     = "maybe"
+	StatusDeclined  = "declined"
+	StatusCheckedIn = "checked_in"
+)
+
+// AttendeeStatuses lists every RSVP status, in lifecycle order
+var AttendeeStatuses = []string{StatusInvited, StatusPending, StatusGoing, StatusMaybe, StatusDeclined, StatusCheckedIn}
+
+// statusTransitions is the RSVP state machine. pending only becomes going when
+// the attendee is promoted off the waitlist, and checked_in is final.
+var statusTransitions = map[string][]string{
+	StatusInvited:   {StatusGoing, StatusMaybe, StatusDeclined},
+	StatusPending:   {StatusDeclined},
+	StatusGoing:     {StatusMaybe, StatusDeclined, StatusCheckedIn},
+	StatusMaybe:     {StatusGoing, StatusDeclined, StatusCheckedIn},
+	StatusDeclined:  {StatusGoing, StatusMaybe},
+	StatusCheckedIn: {},
+}
+
+var (
+	ErrEventNotFound      = errors.New("event not found")
+	ErrAlreadyRegistered  = errors.New("user is already registered for this event")
+	ErrAttendeeNotFound   = errors.New("user is not registered for this event")
+	ErrInvalidStatus      = errors.New("unknown attendee status")
+	ErrInvalidTransition  = errors.New("attendee status cannot change this way")
+	ErrAttendeeWaitlisted = errors.New("attendee is waitlisted and has no seat yet")
+	ErrEventNotOpen       = errors.New("event is not open for registration")
+)
+
+// CanTransitionStatus reports whether the RSVP state machine allows from -> to
+func CanTransitionStatus(from, to string) bool {
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidAttendeeStatus reports whether status is a known RSVP status
+func IsValidAttendeeStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+const attendeeColumns = "a.id, a.user_id, a.event_id, a.occurrence, a.registration_status, a.registered_at, a.status, a.status_updated_at"
+
+func scanAttendee(row rowScanner) (*Attendee, error) {
+	var attendee Attendee
+	err := row.Scan(&attendee.Id, &attendee.UserId, &attendee.EventId, &attendee.Occurrence, &attendee.RegistrationStatus, &attendee.RegisteredAt, &attendee.Status, &attendee.StatusUpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &attendee, nil
+}
+
+// eventOpen returns ErrEventNotOpen unless the event is published
+func eventOpen(ctx context.Context, tx *sql.Tx, eventId int) error {
+	var status string
+	err := tx.QueryRowContext(ctx, "SELECT status FROM events WHERE id = $1", eventId).Scan(&status)
+	if err == sql.ErrNoRows {
+		return ErrEventNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if status != EventPublished {
+		return ErrEventNotOpen
+	}
+	return nil
+}
+
+// Register adds a user to an event, confirming the seat while the event has
+// capacity left and waitlisting them once it is full. The capacity check and
+// the insert share one transaction so concurrent registrations cannot oversell.
+// Invited registrations start as invited; otherwise the attendee is going, or
+// pending while waitlisted. Registrations for a recurring event are per
+// occurrence, each with its own seats; occurrence is "" for one-off events.
+// Only published events take registrations (ErrEventNotOpen otherwise).
+func (m *AttendeeModel) Register(eventId int, occurrence string, userId int, invited bool) (*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := "INSERT INTO attendees (event_id, user_id) VALUES ($1, $2) RETURNING id"
-	err := m.DB.QueryRowContext(ctx, query, attendee.EventId, attendee.UserId).Scan(&attendee.Id)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
+	// checked again here so nobody registers while the event is being cancelled
+	if err := eventOpen(ctx, tx, eventId); err != nil {
+		return nil, err
+	}
+
+	capacity, err := occurrenceCapacity(ctx, tx, eventId, occurrence)
 	if err != nil {
 		return nil, err
 	}
 
+	var exists bool
+	query := "SELECT EXISTS(SELECT 1 FROM attendees WHERE event_id = $1 AND occurrence = $2 AND user_id = $3)"
+	err = tx.QueryRowContext(ctx, query, eventId, occurrence, userId).Scan(&exists)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		return nil, ErrAlreadyRegistered
+	}
+
+	registration, err := seatAvailability(ctx, tx, eventId, occurrence, capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	rsvp := StatusGoing
+	if invited {
+		rsvp = StatusInvited
+	} else if registration == RegistrationWaitlisted {
+		rsvp = StatusPending
+	}
+
+	now := time.Now().UTC()
+	attendee := &Attendee{
+		UserId:             userId,
+		EventId:            eventId,
+		Occurrence:         occurrence,
+		RegistrationStatus: registration,
+		RegisteredAt:       &now,
+		Status:             rsvp,
+		StatusUpdatedAt:    &now,
+	}
+
+	query = `INSERT INTO attendees (event_id, occurrence, user_id, registration_status, registered_at, status, status_updated_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`
+	if err := tx.QueryRowContext(ctx, query, eventId, occurrence, userId, registration, now, rsvp, now).Scan(&attendee.Id); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
 	return attendee, nil
 }
 
-func (m *AttendeeModel) GetByEventAndAttendee(eventId, userId int) (*Attendee, error) {
+// get every registration a user has made
+func (m *AttendeeModel) GetByUser(userId int) ([]*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := "SELECT " + attendeeColumns + " FROM attendees a WHERE a.user_id = $1 ORDER BY a.id"
+	rows, err := m.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attendees := []*Attendee{}
+	for rows.Next() {
+		attendee, err := scanAttendee(rows)
+		if err != nil {
+			return nil, err
+		}
+		attendees = append(attendees, attendee)
+	}
+
+	return attendees, rows.Err()
+}
+
+func (m *AttendeeModel) GetByEventAndAttendee(eventId int, occurrence string, userId int) (*Attendee, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := "SELECT * FROM attendees where event_id = $1 AND user_id = $2"
+	query := "SELECT " + attendeeColumns + " FROM attendees a WHERE a.event_id = $1 AND a.occurrence = $2 AND a.user_id = $3"
 
-	var attendee Attendee
-	err := m.DB.QueryRowContext(ctx, query, eventId, userId).Scan(&attendee.Id, &attendee.UserId, &attendee.EventId)
+	attendee, err := scanAttendee(m.DB.QueryRowContext(ctx, query, eventId, occurrence, userId))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -46,7 +219,211 @@ func (m *AttendeeModel) GetByEventAndAttendee(eventId, userId int) (*Attendee, e
 		return nil, err
 	}
 
-	return &attendee, nil
+	return attendee, nil
+}
+
+// WaitlistPosition returns the 1-based position of a waitlisted registration
+func (m *AttendeeModel) WaitlistPosition(attendee *Attendee) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*) FROM attendees
+		WHERE event_id = $1 AND occurrence = $2 AND registration_status = $3 AND id <= $4
+	`
+
+	var position int
+	err := m.DB.QueryRowContext(ctx, query, attendee.EventId, attendee.Occurrence, RegistrationWaitlisted, attendee.Id).Scan(&position)
+	return position, err
+}
+
+// fillFromWaitlist promotes waitlisted attendees, oldest first, until the
+// event is full again. It runs in the transaction that raises an event's or an
+// occurrence's capacity, and fills every occurrence of a recurring event that
+// has a waitlist.
+func fillFromWaitlist(ctx context.Context, tx *sql.Tx, eventId int) ([]*Attendee, error) {
+	query := "SELECT DISTINCT occurrence FROM attendees WHERE event_id = $1 AND registration_status = $2"
+	rows, err := tx.QueryContext(ctx, query, eventId, RegistrationWaitlisted)
+	if err != nil {
+		return nil, err
+	}
+	occurrences := []string{}
+	for rows.Next() {
+		var occurrence string
+		if err := rows.Scan(&occurrence); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		occurrences = append(occurrences, occurrence)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	promoted := []*Attendee{}
+	for _, occurrence := range occurrences {
+		filled, err := promoteWaitlisted(ctx, tx, eventId, occurrence)
+		if err != nil {
+			return nil, err
+		}
+		promoted = append(promoted, filled...)
+	}
+
+	return promoted, nil
+}
+
+// occurrenceCapacity returns the seats an occurrence has: the event's capacity
+// unless an occurrence override changed it
+func occurrenceCapacity(ctx context.Context, tx *sql.Tx, eventId int, occurrence string) (sql.NullInt64, error) {
+	var capacity sql.NullInt64
+	err := tx.QueryRowContext(ctx, "SELECT capacity FROM events WHERE id = $1", eventId).Scan(&capacity)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return capacity, ErrEventNotFound
+		}
+		return capacity, err
+	}
+	if occurrence == "" {
+		return capacity, nil
+	}
+
+	// an override of this occurrence wins over the latest "this and following" one
+	query := `
+		SELECT capacity FROM event_occurrence_overrides
+		WHERE event_id = $1 AND capacity IS NOT NULL
+		  AND ((scope = $2 AND occurrence = $3) OR (scope = $4 AND occurrence <= $3))
+		ORDER BY scope = $2 DESC, occurrence DESC
+		LIMIT 1
+	`
+	var override sql.NullInt64
+	err = tx.QueryRowContext(ctx, query, eventId, OverrideThis, occurrence, OverrideFollowing).Scan(&override)
+	if err == sql.ErrNoRows {
+		return capacity, nil
+	}
+	if err != nil {
+		return capacity, err
+	}
+	return override, nil
+}
+
+// countConfirmed counts the seats in use; declining an event gives the seat back
+func countConfirmed(ctx context.Context, tx *sql.Tx, eventId int, occurrence string) (int, error) {
+	var confirmed int
+	query := "SELECT COUNT(*) FROM attendees WHERE event_id = $1 AND occurrence = $2 AND registration_status = $3 AND status <> $4"
+	err := tx.QueryRowContext(ctx, query, eventId, occurrence, RegistrationConfirmed, StatusDeclined).Scan(&confirmed)
+	return confirmed, err
+}
+
+// seatAvailability decides whether one more attendee gets a seat or the waitlist
+func seatAvailability(ctx context.Context, tx *sql.Tx, eventId int, occurrence string, capacity sql.NullInt64) (string, error) {
+	if !capacity.Valid {
+		return RegistrationConfirmed, nil
+	}
+
+	confirmed, err := countConfirmed(ctx, tx, eventId, occurrence)
+	if err != nil {
+		return "", err
+	}
+	if int64(confirmed) >= capacity.Int64 {
+		return RegistrationWaitlisted, nil
+	}
+
+	return RegistrationConfirmed, nil
+}
+
+// promoteWaitlisted confirms the earliest waitlisted attendees while seats are free
+func promoteWaitlisted(ctx context.Context, tx *sql.Tx, eventId int, occurrence string) ([]*Attendee, error) {
+	capacity, err := occurrenceCapacity(ctx, tx, eventId, occurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	confirmed, err := countConfirmed(ctx, tx, eventId, occurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	free := -1 // unlimited
+	if capacity.Valid {
+		free = int(capacity.Int64) - confirmed
+		if free <= 0 {
+			return []*Attendee{}, nil
+		}
+	}
+
+	query := `SELECT ` + attendeeColumns + ` FROM attendees a
+			  WHERE a.event_id = $1 AND a.occurrence = $2 AND a.registration_status = $3 AND a.status <> $4
+			  ORDER BY a.id LIMIT $5`
+	rows, err := tx.QueryContext(ctx, query, eventId, occurrence, RegistrationWaitlisted, StatusDeclined, free)
+	if err != nil {
+		return nil, err
+	}
+
+	promoted := []*Attendee{}
+	for rows.Next() {
+		attendee, err := scanAttendee(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		promoted = append(promoted, attendee)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	for _, attendee := range promoted {
+		// a pending attendee was waiting for exactly this seat
+		status := attendee.Status
+		if status == StatusPending {
+			status = StatusGoing
+		}
+
+		query := "UPDATE attendees SET registration_status = $1, status = $2, status_updated_at = $3 WHERE id = $4"
+		_, err := tx.ExecContext(ctx, query, RegistrationConfirmed, status, now, attendee.Id)
+		if err != nil {
+			return nil, err
+		}
+		attendee.RegistrationStatus = RegistrationConfirmed
+		attendee.Status = status
+		attendee.StatusUpdatedAt = &now
+	}
+
+	return promoted, nil
+}
+
+// GetAttendeesToNotify returns each user registered for the event, or any
+// occurrence of it, who has not declined; once per user
+func (m *AttendeeModel) GetAttendeesToNotify(eventId int) ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT DISTINCT u.id, u.username, u.email
+		FROM users u
+		JOIN attendees a ON u.id = a.user_id
+		WHERE a.event_id = $1 AND a.status <> $2
+		ORDER BY u.id
+	`
+	rows, err := m.DB.QueryContext(ctx, query, eventId, StatusDeclined)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		var user User
+		if err := rows.Scan(&user.ID, &user.Username, &user.Email); err != nil {
+			return nil, err
+		}
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
 }
 
 func (m *AttendeeModel) GetAttendeesByEvent(eventId int) ([]*User, error) {
@@ -82,15 +459,18 @@ func (m *AttendeeModel) GetAttendeesByEvent(eventId int) ([]*User, error) {
 	return users, nil
 }
 
-func (m *AttendeeModel) GetEventsByAttendee(attendeeId int) ([]*Event, error) {
+// GetEventsByAttendee returns every registration a user has, with its event;
+// a recurring event appears once per registered occurrence
+func (m *AttendeeModel) GetEventsByAttendee(attendeeId int) ([]*AttendeeEvent, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	query := `
-		SELECT e.id, e.owner_id, e.name, e.description, e.date, e.location
+		SELECT a.occurrence, a.status, a.registration_status, ` + eventColumns + `
 		FROM events e
 		JOIN attendees a ON e.id = a.event_id
 		WHERE a.user_id = $1
+		ORDER BY e.id, a.occurrence
 	`
 	rows, err := m.DB.QueryContext(ctx, query, attendeeId)
 	if err != nil {
@@ -99,30 +479,283 @@ func (m *AttendeeModel) GetEventsByAttendee(attendeeId int) ([]*Event, error) {
 
 	defer rows.Close()
 
-	var events []*Event
+	events := []*AttendeeEvent{}
 	for rows.Next() {
-		var event Event
-		err := rows.Scan(&event.Id, &event.OwnerId, &event.Name, &event.Description, &event.Date, &event.Location)
+		var attending AttendeeEvent
+		event, err := scanEvent(rows, &attending.Occurrence, &attending.Status, &attending.RegistrationStatus)
 		if err != nil {
 			return nil, err
 		}
-		events = append(events, &event)
+		attending.Event = *event
+		attending.EventStatus = event.Status
+		events = append(events, &attending)
 	}
 
-	return events, nil
+	return events, rows.Err()
 
 }
 
-func (m *AttendeeModel) Delete(userId, eventId int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// EventAttendee is a user in an event's attendee list, with their RSVP
+type EventAttendee struct {
+	SafeUser
+	Status             string `json:"status"`
+	RegistrationStatus string `json:"registrationStatus"`
+}
+
+// AttendeeEvent is an event in a user's attendance list, with their RSVP.
+// A user registered for several occurrences of a recurring event gets one
+// entry per occurrence. Status is the RSVP, which hides the event's own
+// status, so that is repeated as EventStatus.
+type AttendeeEvent struct {
+	Event
+	Occurrence         string `json:"occurrence,omitempty"`
+	Status             string `json:"status"`
+	RegistrationStatus string `json:"registrationStatus"`
+	EventStatus        string `json:"eventStatus"`
+}
+
+// AttendeeListSpec is what an event's attendee list may be sorted and filtered by
+var AttendeeListSpec = ListSpec{
+	Sorts:       UserListSpec.Sorts,
+	DefaultSort: UserListSpec.DefaultSort,
+	Filters: map[string]FilterKind{
+		"username": FilterString,
+		"email":    FilterString,
+		"status":   FilterString,
+	},
+	Allowed: map[string][]string{
+		"status": AttendeeStatuses,
+	},
+}
+
+// AttendeeEventListSpec is what a user's attended events may be sorted and filtered by
+var AttendeeEventListSpec = ListSpec{
+	Sorts:       EventListSpec.Sorts,
+	DefaultSort: EventListSpec.DefaultSort,
+	Filters: map[string]FilterKind{
+		"from":     FilterTime,
+		"to":       FilterTime,
+		"location": FilterString,
+		"owner":    FilterInt,
+		"name":     FilterString,
+		"status":   FilterString,
+	},
+	Allowed: map[string][]string{
+		"status": AttendeeStatuses,
+	},
+}
+
+// statusFilter restricts a listing to a comma-separated set of RSVP statuses
+func statusFilter(q ListQuery, where []string, args []interface{}) ([]string, []interface{}) {
+	statuses := q.FilterList("status")
+	if len(statuses) == 0 {
+		return where, args
+	}
+
+	placeholders := make([]string, len(statuses))
+	for i, status := range statuses {
+		placeholders[i] = "?"
+		args = append(args, status)
+	}
+	where = append(where, "a.status IN ("+strings.Join(placeholders, ", ")+")")
+
+	return where, args
+}
+
+// list one page of the users attending an event, or one occurrence of a recurring event
+func (m *AttendeeModel) ListAttendeesByEvent(eventId int, occurrence string, q ListQuery) ([]*EventAttendee, *PageInfo, error) {
+	where, args := userFilters(q)
+	where = append([]string{"a.event_id = ?", "a.occurrence = ?"}, where...)
+	args = append([]interface{}{eventId, occurrence}, args...)
+	where, args = statusFilter(q, where, args)
+
+	attendees := []*EventAttendee{}
+	page, err := paginate(m.DB, AttendeeListSpec, q, pageQuery{
+		columns:  "u.id, u.username, u.email, a.status, a.registration_status",
+		from:     "users u JOIN attendees a ON u.id = a.user_id",
+		where:    where,
+		args:     args,
+		idColumn: "u.id",
+	}, func(rows *sql.Rows, key *sql.NullString) (int, error) {
+		var attendee EventAttendee
+		err := rows.Scan(key, &attendee.ID, &attendee.Username, &attendee.Email, &attendee.Status, &attendee.RegistrationStatus)
+		if err != nil {
+			return 0, err
+		}
+		attendees = append(attendees, &attendee)
+		return attendee.ID, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return attendees, page, nil
+}
+
+// list one page of the events a user attends
+func (m *AttendeeModel) ListEventsByAttendee(userId int, q ListQuery) ([]*AttendeeEvent, *PageInfo, error) {
+	where, args := eventFilters(q)
+	where = append([]string{"a.user_id = ?"}, where...)
+	args = append([]interface{}{userId}, args...)
+	where, args = statusFilter(q, where, args)
+
+	events := []*AttendeeEvent{}
+	page, err := paginate(m.DB, AttendeeEventListSpec, q, pageQuery{
+		columns:  "a.id, a.occurrence, a.status, a.registration_status, " + eventColumns,
+		from:     "events e JOIN attendees a ON e.id = a.event_id",
+		where:    where,
+		args:     args,
+		idColumn: "a.id",
+	}, func(rows *sql.Rows, key *sql.NullString) (int, error) {
+		var attending AttendeeEvent
+		var registrationId int
+		event, err := scanEvent(rows, key, &registrationId, &attending.Occurrence, &attending.Status, &attending.RegistrationStatus)
+		if err != nil {
+			return 0, err
+		}
+		attending.Event = *event
+		attending.EventStatus = event.Status
+		events = append(events, &attending)
+		// the registration, not the event, is unique once recurring events repeat in the list
+		return registrationId, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return events, page, nil
+}
+
+// UpdateStatus moves an attendee through the RSVP state machine. Declining
+// hands a confirmed seat to the waitlist; coming back from declined to a full
+// event puts the attendee on the waitlist as pending. The attendee after the
+// change is returned along with anyone promoted off the waitlist. Only a
+// published event takes RSVP changes (ErrEventNotOpen otherwise).
+func (m *AttendeeModel) UpdateStatus(eventId int, occurrence string, userId int, status string) (*Attendee, []*Attendee, error) {
+	if !IsValidAttendeeStatus(status) {
+		return nil, nil, ErrInvalidStatus
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := "DELETE FROM attendees WHERE user_id = $1 AND event_id = $2"
-	_, err := m.DB.ExecContext(ctx, query, userId, eventId)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
+	defer tx.Rollback()
 
-	return nil
+	if err := eventOpen(ctx, tx, eventId); err != nil {
+		return nil, nil, err
+	}
+
+	query := "SELECT " + attendeeColumns + " FROM attendees a WHERE a.event_id = $1 AND a.occurrence = $2 AND a.user_id = $3"
+	attendee, err := scanAttendee(tx.QueryRowContext(ctx, query, eventId, occurrence, userId))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, ErrAttendeeNotFound
+		}
+		return nil, nil, err
+	}
+
+	if !CanTransitionStatus(attendee.Status, status) {
+		return nil, nil, ErrInvalidTransition
+	}
+
+	registration := attendee.RegistrationStatus
+	switch {
+	case attendee.Status == StatusDeclined && registration == RegistrationConfirmed:
+		// the seat was released on decline, so it has to be claimed again
+		capacity, err := occurrenceCapacity(ctx, tx, eventId, occurrence)
+		if err != nil {
+			return nil, nil, err
+		}
+		registration, err = seatAvailability(ctx, tx, eventId, occurrence, capacity)
+		if err != nil {
+			return nil, nil, err
+		}
+		if registration == RegistrationWaitlisted {
+			status = StatusPending
+		}
+	case registration == RegistrationWaitlisted && (status == StatusGoing || status == StatusCheckedIn):
+		return nil, nil, ErrAttendeeWaitlisted
+	}
+
+	now := time.Now().UTC()
+	update := "UPDATE attendees SET status = $1, registration_status = $2, status_updated_at = $3 WHERE id = $4"
+	if _, err := tx.ExecContext(ctx, update, status, registration, now, attendee.Id); err != nil {
+		return nil, nil, err
+	}
+
+	freedSeat := status == StatusDeclined && attendee.RegistrationStatus == RegistrationConfirmed
+	attendee.Status = status
+	attendee.RegistrationStatus = registration
+	attendee.StatusUpdatedAt = &now
+
+	promoted := []*Attendee{}
+	if freedSeat {
+		promoted, err = promoteWaitlisted(ctx, tx, eventId, occurrence)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return attendee, promoted, nil
+}
+
+// Delete removes a registration. When that frees a confirmed seat the
+// earliest waitlisted attendee is promoted in the same transaction and returned.
+func (m *AttendeeModel) Delete(userId, eventId int, occurrence string) ([]*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
 
-}
\ No newline at end of file
+	var registration, status string
+	query := "SELECT registration_status, status FROM attendees WHERE user_id = $1 AND event_id = $2 AND occurrence = $3"
+	err = tx.QueryRowContext(ctx, query, userId, eventId, occurrence).Scan(&registration, &status)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrAttendeeNotFound
+		}
+		return nil, err
+	}
+
+	query = "DELETE FROM attendees WHERE user_id = $1 AND event_id = $2 AND occurrence = $3"
+	_, err = tx.ExecContext(ctx, query, userId, eventId, occurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	promoted := []*Attendee{}
+	if registration == RegistrationConfirmed && status != StatusDeclined {
+		promoted, err = promoteWaitlisted(ctx, tx, eventId, occurrence)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return promoted, nil
+
+}
+// HasRegistrations reports whether anyone is registered for the event, or any occurrence of it
+func (m *AttendeeModel) HasRegistrations(eventId int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM attendees WHERE event_id = $1)", eventId).Scan(&exists)
+	return exists, err
+}
@@ -0,0 +1,152 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+func TestCanTransitionStatus(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{StatusInvited, StatusGoing, true},
+		{StatusInvited, StatusDeclined, true},
+		{StatusGoing, StatusCheckedIn, true},
+		{StatusMaybe, StatusGoing, true},
+		{StatusDeclined, StatusMaybe, true},
+		{StatusPending, StatusDeclined, true},
+		// a pending attendee only goes once promoted off the waitlist
+		{StatusPending, StatusGoing, false},
+		{StatusInvited, StatusCheckedIn, false},
+		{StatusDeclined, StatusCheckedIn, false},
+		{StatusGoing, StatusInvited, false},
+		{StatusGoing, StatusGoing, false},
+		{StatusCheckedIn, StatusDeclined, false},
+		{StatusCheckedIn, StatusGoing, false},
+		{"unknown", StatusGoing, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransitionStatus(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransitionStatus(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
+
+// an event with one seat, taken by the first of the returned users while the
+// second waits for it
+func fullEvent(t *testing.T) (Models, *Event, []*User) {
+	t.Helper()
+	models := NewModels(dbtest.New(t))
+
+	var users []*User
+	for i := 0; i < 3; i++ {
+		user := &User{Username: fmt.Sprintf("user%d", i), Email: fmt.Sprintf("user%d@example.com", i), Password: "x"}
+		if err := models.Users.Insert(user); err != nil {
+			t.Fatal(err)
+		}
+		users = append(users, user)
+	}
+
+	name, description, location, zone := "Workshop", "Hands on", "Lab", "UTC"
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	capacity := 1
+	event := &Event{Name: &name, Description: &description, Location: &location, StartsAt: &start, EndsAt: &end,
+		Timezone: &zone, OwnerId: &users[2].ID, Capacity: &capacity, Status: EventPublished}
+	if err := models.Events.Insert(event); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range users[:2] {
+		if _, err := models.Attendees.Register(event.Id, "", user.ID, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return models, event, users
+}
+
+func TestUpdateStatusRejects(t *testing.T) {
+	models, event, users := fullEvent(t)
+	seated, waiting, owner := users[0], users[1], users[2]
+
+	tests := []struct {
+		name    string
+		userId  int
+		status  string
+		wantErr error
+	}{
+		{"unknown status", seated.ID, "busy", ErrInvalidStatus},
+		{"not registered", owner.ID, StatusGoing, ErrAttendeeNotFound},
+		{"transition the state machine forbids", seated.ID, StatusInvited, ErrInvalidTransition},
+		{"waitlisted going", waiting.ID, StatusGoing, ErrInvalidTransition},
+	}
+
+	for _, tt := range tests {
+		_, _, err := models.Attendees.UpdateStatus(event.Id, "", tt.userId, tt.status)
+		if !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// an invitation to a full event is waitlisted: the invitee may answer
+	// maybe, but going needs a seat
+	invitee := &User{Username: "invitee", Email: "invitee@example.com", Password: "x"}
+	if err := models.Users.Insert(invitee); err != nil {
+		t.Fatal(err)
+	}
+	invited, err := models.Attendees.Register(event.Id, "", invitee.ID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invited.Status != StatusInvited || invited.RegistrationStatus != RegistrationWaitlisted {
+		t.Fatalf("invitation = %s/%s, want invited/waitlisted", invited.Status, invited.RegistrationStatus)
+	}
+	if _, _, err := models.Attendees.UpdateStatus(event.Id, "", invitee.ID, StatusGoing); !errors.Is(err, ErrAttendeeWaitlisted) {
+		t.Errorf("waitlisted invitee going: got %v, want %v", err, ErrAttendeeWaitlisted)
+	}
+	if _, _, err := models.Attendees.UpdateStatus(event.Id, "", invitee.ID, StatusMaybe); err != nil {
+		t.Errorf("waitlisted invitee maybe: %v", err)
+	}
+}
+
+func TestDeclineHandsSeatToWaitlist(t *testing.T) {
+	models, event, users := fullEvent(t)
+	seated, waiting := users[0], users[1]
+
+	declined, promoted, err := models.Attendees.UpdateStatus(event.Id, "", seated.ID, StatusDeclined)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if declined.Status != StatusDeclined {
+		t.Errorf("decliner status = %s, want %s", declined.Status, StatusDeclined)
+	}
+	if len(promoted) != 1 || promoted[0].UserId != waiting.ID {
+		t.Fatalf("promoted %+v, want user %d", promoted, waiting.ID)
+	}
+	if promoted[0].Status != StatusGoing || promoted[0].RegistrationStatus != RegistrationConfirmed {
+		t.Errorf("promoted attendee = %s/%s, want going/confirmed", promoted[0].Status, promoted[0].RegistrationStatus)
+	}
+
+	// coming back to a full event means waiting for a seat again
+	back, promoted, err := models.Attendees.UpdateStatus(event.Id, "", seated.ID, StatusGoing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if back.Status != StatusPending || back.RegistrationStatus != RegistrationWaitlisted || len(promoted) != 0 {
+		t.Errorf("returning attendee = %s/%s with %d promoted, want pending/waitlisted with none", back.Status, back.RegistrationStatus, len(promoted))
+	}
+
+	// checking in is final
+	if _, _, err := models.Attendees.UpdateStatus(event.Id, "", waiting.ID, StatusCheckedIn); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := models.Attendees.UpdateStatus(event.Id, "", waiting.ID, StatusDeclined); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("decline after check-in: got %v, want %v", err, ErrInvalidTransition)
+	}
+}
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// audit actions
+const (
+	AuditLoginLockout  = "auth.lockout"
+	AuditAccountUnlock = "auth.unlock"
+
+	AuditDeletionScheduled = "account.deletion_scheduled"
+	AuditDeletionCancelled = "account.deletion_cancelled"
+	AuditAccountDeleted    = "account.deleted"
+
+	AuditUserSuspended   = "admin.suspend"
+	AuditUserUnsuspended = "admin.unsuspend"
+	AuditForcedLogout    = "admin.force_logout"
+	AuditUserDeleted     = "admin.delete_user"
+
+	AuditImpersonationStarted = "admin.impersonate"
+	AuditImpersonationStopped = "admin.impersonate_stop"
+	AuditImpersonatedWrite    = "impersonation.write"
+)
+
+type AuditModel struct {
+	DB *sql.DB
+}
+
+type AuditEntry struct {
+	Id           int       `json:"id"`
+	Action       string    `json:"action"`
+	ActorId      *int      `json:"actorId"`
+	TargetUserId *int      `json:"targetUserId"`
+	IP           string    `json:"ip"`
+	Detail       string    `json:"detail"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// record an audit entry
+func (m *AuditModel) Record(entry *AuditEntry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	entry.CreatedAt = time.Now().UTC()
+	query := "INSERT INTO audit_log (action, actor_id, target_user_id, ip, detail, created_at) VALUES ($1, $2, $3, $4, $5, $6)"
+	result, err := m.DB.ExecContext(ctx, query, entry.Action, entry.ActorId, entry.TargetUserId, entry.IP, entry.Detail, entry.CreatedAt)
+	if err != nil {
+		return err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	entry.Id = int(id)
+
+	return nil
+}
+
+// list the audit entries a user caused or was the subject of, oldest first
+func (m *AuditModel) ListForUser(userId int) ([]*AuditEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT id, action, actor_id, target_user_id, ip, detail, created_at FROM audit_log
+			  WHERE actor_id = $1 OR target_user_id = $1 ORDER BY id`
+	rows, err := m.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		var entry AuditEntry
+		if err := rows.Scan(&entry.Id, &entry.Action, &entry.ActorId, &entry.TargetUserId, &entry.IP, &entry.Detail, &entry.CreatedAt); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type CalendarFeedModel struct {
+	DB *sql.DB
+}
+
+// CalendarFeed is a user's subscribable calendar; the token itself is only stored hashed
+type CalendarFeed struct {
+	UserId     int        `json:"userId"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+const calendarFeedColumns = "user_id, created_at, last_used_at"
+
+func scanCalendarFeed(row rowScanner) (*CalendarFeed, error) {
+	var feed CalendarFeed
+	err := row.Scan(&feed.UserId, &feed.CreatedAt, &feed.LastUsedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &feed, nil
+}
+
+// give the user a feed with a new token, replacing (and so revoking) any old one
+func (m *CalendarFeedModel) Rotate(userId int, tokenHash string) (*CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	feed := &CalendarFeed{UserId: userId, CreatedAt: time.Now().UTC()}
+	query := `
+		INSERT INTO calendar_feeds (user_id, token_hash, created_at) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET token_hash = excluded.token_hash, created_at = excluded.created_at, last_used_at = NULL
+	`
+	if _, err := m.DB.ExecContext(ctx, query, userId, tokenHash, feed.CreatedAt); err != nil {
+		return nil, err
+	}
+
+	return feed, nil
+}
+
+// get a user's feed, or nil when they have none
+func (m *CalendarFeedModel) Get(userId int) (*CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + calendarFeedColumns + " FROM calendar_feeds WHERE user_id = $1"
+	return scanCalendarFeed(m.DB.QueryRowContext(ctx, query, userId))
+}
+
+// look a feed up by the hash of the token in its URL
+func (m *CalendarFeedModel) GetByHash(tokenHash string) (*CalendarFeed, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT " + calendarFeedColumns + " FROM calendar_feeds WHERE token_hash = $1"
+	return scanCalendarFeed(m.DB.QueryRowContext(ctx, query, tokenHash))
+}
+
+// record that a calendar client fetched the feed. Calendar apps poll often, so
+// last_used_at is only written when it is more than an hour old.
+func (m *CalendarFeedModel) Touch(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	now := time.Now().UTC()
+	query := "UPDATE calendar_feeds SET last_used_at = $1 WHERE user_id = $2 AND (last_used_at IS NULL OR last_used_at < $3)"
+	_, err := m.DB.ExecContext(ctx, query, now, userId, now.Add(-time.Hour))
+	return err
+}
+
+// revoke a user's feed; false when they had none
+func (m *CalendarFeedModel) Revoke(userId int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, "DELETE FROM calendar_feeds WHERE user_id = $1", userId)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
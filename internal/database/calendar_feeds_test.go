@@ -0,0 +1,60 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+func TestCalendarFeedTouchIsThrottled(t *testing.T) {
+	models := database.NewModels(dbtest.New(t))
+	user := &database.User{Username: "subscriber", Email: "subscriber@example.com", Password: "x"}
+	if err := models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := models.CalendarFeeds.Rotate(user.ID, "hash"); err != nil {
+		t.Fatal(err)
+	}
+
+	lastUsed := func() time.Time {
+		t.Helper()
+		feed, err := models.CalendarFeeds.Get(user.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if feed.LastUsedAt == nil {
+			t.Fatal("last use was not recorded")
+		}
+		return *feed.LastUsedAt
+	}
+	setLastUsed := func(at time.Time) {
+		t.Helper()
+		if _, err := models.CalendarFeeds.DB.Exec("UPDATE calendar_feeds SET last_used_at = ? WHERE user_id = ?", at.UTC(), user.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := models.CalendarFeeds.Touch(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	lastUsed()
+
+	recent := time.Now().Add(-30 * time.Minute).Truncate(time.Second)
+	setLastUsed(recent)
+	if err := models.CalendarFeeds.Touch(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastUsed(); !got.Equal(recent) {
+		t.Errorf("a fetch within the hour moved last use from %v to %v", recent, got)
+	}
+
+	setLastUsed(time.Now().Add(-2 * time.Hour))
+	if err := models.CalendarFeeds.Touch(user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastUsed(); time.Since(got) > time.Minute {
+		t.Errorf("last use %v was not updated after more than an hour", got)
+	}
+}
@@ -0,0 +1,59 @@
+// Package dbtest gives tests a throwaway SQLite database with every migration applied.
+package dbtest
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// migrationsDir is cmd/migrate/migrations, found from this file so tests in any package can use it
+func migrationsDir() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..", "..", "cmd", "migrate", "migrations")
+}
+
+// New opens a new database in the test's temp directory, migrated up, with
+// the same connection options the API uses. Full-text search needs the
+// sqlite_fts5 build tag; without it the search migration is skipped.
+func New(t testing.TB) *sql.DB {
+	t.Helper()
+
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db")+"?_txlock=immediate&_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	fts5 := true
+	if _, err := db.Exec("CREATE VIRTUAL TABLE temp.fts5_probe USING fts5(x)"); err != nil {
+		fts5 = false
+	}
+
+	files, err := filepath.Glob(filepath.Join(migrationsDir(), "*.up.sql"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("find migrations: %v", err)
+	}
+	sort.Strings(files)
+
+	for _, file := range files {
+		body, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("read %s: %v", file, err)
+		}
+		if !fts5 && strings.Contains(string(body), "USING fts5") {
+			continue
+		}
+		if _, err := db.Exec(string(body)); err != nil {
+			t.Fatalf("apply %s: %v", filepath.Base(file), err)
+		}
+	}
+
+	return db
+}
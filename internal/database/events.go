@@ -3,9 +3,15 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"html"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/muhamash/go-first-rest-api/internal/recurrence"
 )
 
 
@@ -17,9 +23,293 @@ type Event struct {
 	Id          int    `json:"id"`
 	Name   		*string `json:"name" min:"3" max:"50"`
 	Description *string `json:"description" min:"3" max:"200"`
-	Date        *time.Time `json:"date"`
+	// StartsAt and EndsAt are stored in UTC and read back in the event's Timezone
+	StartsAt    *time.Time `json:"startsAt"`
+	EndsAt      *time.Time `json:"endsAt"`
+	// Timezone is the IANA time zone the event is scheduled in, e.g. "Europe/Berlin"
+	Timezone    *string    `json:"timezone"`
 	Location    *string `json:"location" min:"3" max:"100"`
 	OwnerId     *int    `json:"ownerId"`
+	Capacity    *int    `json:"capacity" binding:"omitempty,min=1"`
+	// RRule makes the event repeat (RFC 5545, e.g. "FREQ=WEEKLY;BYDAY=TU") in its
+	// Timezone; StartsAt and EndsAt are the first occurrence
+	RRule       *string     `json:"rrule,omitempty"`
+	// ExDates are the starts of occurrences removed from the series
+	ExDates     []time.Time `json:"exdates,omitempty"`
+	// Status is where the event is in its lifecycle; a draft is only shown to its owner
+	Status          string     `json:"status"`
+	StatusChangedAt *time.Time `json:"statusChangedAt,omitempty"`
+}
+
+// event lifecycle statuses
+const (
+	EventDraft     = "draft"
+	EventPublished = "published"
+	EventCancelled = "cancelled"
+	EventCompleted = "completed"
+)
+
+// EventStatuses lists every event status, in lifecycle order
+var EventStatuses = []string{EventDraft, EventPublished, EventCancelled, EventCompleted}
+
+// eventTransitions is the event lifecycle. A draft is either published or
+// dropped; cancelled and completed are final.
+var eventTransitions = map[string][]string{
+	EventDraft:     {EventPublished, EventCancelled},
+	EventPublished: {EventCancelled, EventCompleted},
+	EventCancelled: {},
+	EventCompleted: {},
+}
+
+var ErrEventStatusChanged = errors.New("event status changed in the meantime")
+
+// CanTransitionEvent reports whether the event lifecycle allows from -> to
+func CanTransitionEvent(from, to string) bool {
+	for _, allowed := range eventTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidEventStatus reports whether status is a known event status
+func IsValidEventStatus(status string) bool {
+	_, ok := eventTransitions[status]
+	return ok
+}
+
+// columns selected for an Event, in the order scanEvent expects
+const eventColumns = "e.id, e.name, e.owner_id, e.description, e.starts_at, e.ends_at, e.timezone, e.location, e.capacity, e.rrule, e.exdates, e.status, e.status_changed_at"
+
+// EventListSpec is what GET /events may be sorted and filtered by
+var EventListSpec = ListSpec{
+	Sorts: map[string]string{
+		"id":       "e.id",
+		"name":     "e.name",
+		"startsAt": "datetime(e.starts_at)",
+		"endsAt":   "datetime(e.ends_at)",
+		"location": "e.location",
+	},
+	DefaultSort: "id",
+	Filters: map[string]FilterKind{
+		"from":     FilterTime,
+		"to":       FilterTime,
+		"location": FilterString,
+		"owner":    FilterInt,
+		"name":     FilterString,
+		"status":   FilterString,
+	},
+	Allowed: map[string][]string{
+		"status": EventStatuses,
+	},
+}
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanEvent(row rowScanner, extra ...interface{}) (*Event, error) {
+	var event Event
+	var rrule, exdates string
+	dest := append(extra, &event.Id, &event.Name, &event.OwnerId, &event.Description, &event.StartsAt, &event.EndsAt, &event.Timezone,
+		&event.Location, &event.Capacity, &rrule, &exdates, &event.Status, &event.StatusChangedAt)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	// times come back in UTC; show them in the zone the event is scheduled in
+	event.ShowIn(event.Zone())
+
+	if rrule != "" {
+		event.RRule = &rrule
+	}
+	for _, value := range strings.Split(exdates, ",") {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			event.ExDates = append(event.ExDates, t.In(event.Zone()))
+		}
+	}
+	return &event, nil
+}
+
+// locations caches loaded time zones by name
+var locations sync.Map
+
+// LoadLocation loads an IANA time zone, caching it. "Local" is refused since
+// it means whatever zone the server runs in.
+func LoadLocation(name string) (*time.Location, error) {
+	if cached, ok := locations.Load(name); ok {
+		return cached.(*time.Location), nil
+	}
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// Zone is the time zone the event is scheduled in, UTC when it has none
+func (e *Event) Zone() *time.Location {
+	if e.Timezone == nil {
+		return time.UTC
+	}
+	loc, err := LoadLocation(*e.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Duration is how long the event (and each of its occurrences) lasts
+func (e *Event) Duration() time.Duration {
+	if e.StartsAt == nil || e.EndsAt == nil {
+		return 0
+	}
+	return e.EndsAt.Sub(*e.StartsAt)
+}
+
+// ShowIn converts the event's times to loc for display. The instants are
+// unchanged, but recurrence must still be expanded in the event's Zone.
+func (e *Event) ShowIn(loc *time.Location) {
+	if e.StartsAt != nil {
+		startsAt := e.StartsAt.In(loc)
+		e.StartsAt = &startsAt
+	}
+	if e.EndsAt != nil {
+		endsAt := e.EndsAt.In(loc)
+		e.EndsAt = &endsAt
+	}
+	if e.ExDates != nil {
+		exdates := make([]time.Time, len(e.ExDates))
+		for i, t := range e.ExDates {
+			exdates[i] = t.In(loc)
+		}
+		e.ExDates = exdates
+	}
+}
+
+// utc is how times are written to the database
+func utc(t *time.Time) interface{} {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+func timezoneValue(event *Event) string {
+	if event.Timezone == nil {
+		return "UTC"
+	}
+	return *event.Timezone
+}
+
+// IsRecurring reports whether the event repeats
+func (e *Event) IsRecurring() bool {
+	return e.RRule != nil && *e.RRule != ""
+}
+
+// Rule parses the event's RRULE in the event's time zone
+func (e *Event) Rule() (*recurrence.Rule, error) {
+	if !e.IsRecurring() {
+		return nil, fmt.Errorf("event %d does not repeat", e.Id)
+	}
+	return recurrence.Parse(*e.RRule, e.Zone())
+}
+
+// FirstStart is when the first occurrence starts, in the event's time zone,
+// which is what the recurrence rule counts from
+func (e *Event) FirstStart() time.Time {
+	if e.StartsAt == nil {
+		return time.Time{}
+	}
+	return e.StartsAt.In(e.Zone())
+}
+
+// OccurrenceKey is how an occurrence of a recurring event is identified: the
+// UTC start its rule gives it, in RFC 3339. Moving the occurrence keeps the key.
+func OccurrenceKey(start time.Time) string {
+	return start.UTC().Format(time.RFC3339)
+}
+
+func rruleValue(event *Event) string {
+	if event.RRule == nil {
+		return ""
+	}
+	return *event.RRule
+}
+
+func exdatesValue(exdates []time.Time) string {
+	keys := make([]string, len(exdates))
+	for i, t := range exdates {
+		keys[i] = OccurrenceKey(t)
+	}
+	sort.Strings(keys)
+	return strings.Join(keys, ",")
+}
+
+// eventFilters translates the EventListSpec filters into WHERE conditions
+func eventFilters(q ListQuery) ([]string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+
+	if from, ok := q.FilterTime("from"); ok {
+		where = append(where, "datetime(e.starts_at) >= ?")
+		args = append(args, from)
+	}
+	if to, ok := q.FilterTime("to"); ok {
+		where = append(where, "datetime(e.starts_at) <= ?")
+		args = append(args, to)
+	}
+	if location := q.Filter("location"); location != "" {
+		where = append(where, `e.location LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(location))
+	}
+	if owner := q.Filter("owner"); owner != "" {
+		where = append(where, "e.owner_id = ?")
+		args = append(args, owner)
+	}
+	if name := q.Filter("name"); name != "" {
+		where = append(where, `e.name LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(name))
+	}
+
+	return where, args
+}
+
+// VisibleTo reports whether user may see the event. Once published anyone
+// may, but a draft is only seen by its owner and those who manage any event.
+func (e *Event) VisibleTo(user *User) bool {
+	if e.Status != EventDraft || user.HasPermission(PermEventsManageAny) {
+		return true
+	}
+	return e.OwnerId != nil && *e.OwnerId == user.ID
+}
+
+// visibleTo hides the drafts viewer may not see from a listing, and applies
+// the EventListSpec status filter
+func visibleTo(viewer *User, q ListQuery, where []string, args []interface{}) ([]string, []interface{}) {
+	if !viewer.HasPermission(PermEventsManageAny) {
+		where = append(where, "(e.status <> ? OR e.owner_id = ?)")
+		args = append(args, EventDraft, viewer.ID)
+	}
+
+	statuses := q.FilterList("status")
+	if len(statuses) == 0 {
+		return where, args
+	}
+	placeholders := make([]string, len(statuses))
+	for i, status := range statuses {
+		placeholders[i] = "?"
+		args = append(args, status)
+	}
+	where = append(where, "e.status IN ("+strings.Join(placeholders, ", ")+")")
+
+	return where, args
 }
 
 // craete a new event
@@ -27,20 +317,111 @@ func (m *EventModel) Insert(event *Event) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := `INSERT INTO events (name, description, date, location, owner_id)
-			  VALUES ($1, $2, $3, $4, $5) RETURNING id`
 	// _, err := m.DB.ExecContext(ctx, query, event.name, event.Description, event.Date, event.Location, event.OwnerId)
 	
-	return m.DB.QueryRowContext(ctx, query, event.Name, event.Description, event.Date, event.Location, event.OwnerId).Scan(&event.Id)
+	return m.DB.QueryRowContext(ctx, insertEventQuery, insertEventArgs(event)...).Scan(&event.Id)
 	
 }
 
+const insertEventQuery = `INSERT INTO events (name, description, starts_at, ends_at, timezone, location, owner_id, capacity, rrule, exdates, status)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) RETURNING id`
+
+// insertEventArgs are the values insertEventQuery takes; an event without a
+// status starts as a draft
+func insertEventArgs(event *Event) []interface{} {
+	if event.Status == "" {
+		event.Status = EventDraft
+	}
+	return []interface{}{event.Name, event.Description, utc(event.StartsAt), utc(event.EndsAt), timezoneValue(event), event.Location,
+		event.OwnerId, event.Capacity, rruleValue(event), exdatesValue(event.ExDates), event.Status}
+}
+
+// EventImport is one event of a bulk import, with the occurrences of its
+// series that were changed in the source calendar
+type EventImport struct {
+	Event     *Event
+	Overrides []*OccurrenceOverride
+}
+
+// insert every imported event in one transaction, so either all of them are
+// created or none are; sets each event's Id
+func (m *EventModel) Import(items []*EventImport) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	overrideQuery := `
+		INSERT INTO event_occurrence_overrides (event_id, occurrence, scope, name, description, starts_at, ends_at, location, capacity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+	`
+	for _, item := range items {
+		if err := tx.QueryRowContext(ctx, insertEventQuery, insertEventArgs(item.Event)...).Scan(&item.Event.Id); err != nil {
+			return err
+		}
+		for _, o := range item.Overrides {
+			if _, err := tx.ExecContext(ctx, overrideQuery, item.Event.Id, o.Occurrence, o.Scope, o.Name, o.Description, utc(o.StartsAt), utc(o.EndsAt), o.Location, o.Capacity); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// the names among these that events already have
+func (m *EventModel) ExistingNames(names []string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	existing := map[string]bool{}
+	// stay well under SQLite's limit on bound parameters
+	const chunk = 500
+	for start := 0; start < len(names); start += chunk {
+		end := start + chunk
+		if end > len(names) {
+			end = len(names)
+		}
+
+		placeholders := make([]string, 0, end-start)
+		args := make([]interface{}, 0, end-start)
+		for i, name := range names[start:end] {
+			placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+			args = append(args, name)
+		}
+
+		rows, err := m.DB.QueryContext(ctx, "SELECT name FROM events WHERE name IN ("+strings.Join(placeholders, ", ")+")", args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			existing[name] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return existing, nil
+}
+
 // get all events
 func (m *EventModel) GetAll() ([]*Event, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := `SELECT * FROM events`
+	query := `SELECT ` + eventColumns + ` FROM events e`
 	
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
@@ -50,11 +431,11 @@ func (m *EventModel) GetAll() ([]*Event, error) {
 
 	events := []*Event{}
 	for rows.Next() {
-		var event Event
-		if err := rows.Scan(&event.Id, &event.Name, &event.OwnerId, &event.Description, &event.Date, &event.Location); err != nil {
+		event, err := scanEvent(rows)
+		if err != nil {
 			return nil, err
 		}
-		events = append(events, &event)
+		events = append(events, event)
 	}
 
 	if err := rows.Err(); err != nil {
@@ -64,25 +445,65 @@ func (m *EventModel) GetAll() ([]*Event, error) {
 	return events, nil
 }
 
+// get every event a user owns
+func (m *EventModel) GetByOwner(ownerId int) ([]*Event, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT ` + eventColumns + ` FROM events e WHERE e.owner_id = $1 ORDER BY e.id`
+	rows, err := m.DB.QueryContext(ctx, query, ownerId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	return events, rows.Err()
+}
+
+// list one page of the events viewer may see matching the query filters
+func (m *EventModel) List(q ListQuery, viewer *User) ([]*Event, *PageInfo, error) {
+	where, args := eventFilters(q)
+	where, args = visibleTo(viewer, q, where, args)
+
+	events := []*Event{}
+	page, err := paginate(m.DB, EventListSpec, q, pageQuery{
+		columns:  eventColumns,
+		from:     "events e",
+		where:    where,
+		args:     args,
+		idColumn: "e.id",
+	}, func(rows *sql.Rows, key *sql.NullString) (int, error) {
+		event, err := scanEvent(rows, key)
+		if err != nil {
+			return 0, err
+		}
+		events = append(events, event)
+		return event.Id, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return events, page, nil
+}
+
 // get single event by Id
 func (m *EventModel) GET(Id int) (*Event, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := `SELECT * FROM events WHERE id = $1`
+	query := `SELECT ` + eventColumns + ` FROM events e WHERE e.id = $1`
 	
-	var event Event
-	err := m.DB.QueryRowContext(ctx, query, Id).Scan(
-		&event.Id,
-		&event.Name,
-		&event.OwnerId,
-		&event.Description,
-		&event.Date,
-		&event.Location,
-	)
-	
-	fmt.Println("Query is:", query, "Id is:", Id, err, event)
-
+	event, err := scanEvent(m.DB.QueryRowContext(ctx, query, Id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil 
@@ -90,11 +511,28 @@ func (m *EventModel) GET(Id int) (*Event, error) {
 		return nil, err
 	}
 
-	return &event, nil
+	return event, nil
 }
 
-// update event by Id
-func (m *EventModel) Update(event *Event) error {
+// EventUpdate is what an update does to the occurrences of a recurring event
+type EventUpdate struct {
+	// Rescheduled is set when the rule, first start or time zone changed;
+	// overrides of occurrences the new schedule no longer has are dropped
+	Rescheduled bool
+	// Excluded are the starts of occurrences newly removed by exdates; their
+	// registrations and overrides are deleted, as by CancelOccurrence
+	Excluded []time.Time
+	// ClearCapacity removes the event's capacity, leaving its seats unlimited
+	ClearCapacity bool
+	// FillWaitlist is set when the update adds seats; waitlisted attendees
+	// are promoted into them before the update commits
+	FillWaitlist bool
+}
+
+// update event by Id, in one transaction with the cleanup of the occurrences
+// the update removes and the promotion of waitlisted attendees into the seats
+// it adds; returns how many registrations were deleted and who was promoted
+func (m *EventModel) Update(event *Event, change EventUpdate) (int64, []*Attendee, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
@@ -112,9 +550,19 @@ func (m *EventModel) Update(event *Event) error {
 		args = append(args, *event.Description)
 		argID++
 	}
-	if event.Date != nil {
-		setClauses = append(setClauses, fmt.Sprintf("date = $%d", argID))
-		args = append(args, *event.Date)
+	if event.StartsAt != nil {
+		setClauses = append(setClauses, fmt.Sprintf("starts_at = $%d", argID))
+		args = append(args, event.StartsAt.UTC())
+		argID++
+	}
+	if event.EndsAt != nil {
+		setClauses = append(setClauses, fmt.Sprintf("ends_at = $%d", argID))
+		args = append(args, event.EndsAt.UTC())
+		argID++
+	}
+	if event.Timezone != nil {
+		setClauses = append(setClauses, fmt.Sprintf("timezone = $%d", argID))
+		args = append(args, *event.Timezone)
 		argID++
 	}
 	if event.Location != nil {
@@ -127,31 +575,262 @@ func (m *EventModel) Update(event *Event) error {
 		args = append(args, *event.OwnerId)
 		argID++
 	}
+	if change.ClearCapacity {
+		setClauses = append(setClauses, "capacity = NULL")
+	} else if event.Capacity != nil {
+		setClauses = append(setClauses, fmt.Sprintf("capacity = $%d", argID))
+		args = append(args, *event.Capacity)
+		argID++
+	}
+	if event.RRule != nil {
+		setClauses = append(setClauses, fmt.Sprintf("rrule = $%d", argID))
+		args = append(args, *event.RRule)
+		argID++
+	}
+	if event.ExDates != nil {
+		setClauses = append(setClauses, fmt.Sprintf("exdates = $%d", argID))
+		args = append(args, exdatesValue(event.ExDates))
+		argID++
+	}
 
 	if len(setClauses) == 0 {
-		return fmt.Errorf("no fields to update")
+		return 0, nil, fmt.Errorf("no fields to update")
 	}
 
 	// Add final ID condition
 	query := fmt.Sprintf(`UPDATE events SET %s WHERE id = $%d`, strings.Join(setClauses, ", "), argID)
 	args = append(args, event.Id)
 
-	_, err := m.DB.ExecContext(ctx, query, args...)
-	return err
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return 0, nil, err
+	}
+
+	var removed int64
+	for _, start := range change.Excluded {
+		key := OccurrenceKey(start)
+		query := "DELETE FROM event_occurrence_overrides WHERE event_id = $1 AND occurrence = $2 AND scope = $3"
+		if _, err := tx.ExecContext(ctx, query, event.Id, key, OverrideThis); err != nil {
+			return 0, nil, err
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM attendees WHERE event_id = $1 AND occurrence = $2", event.Id, key)
+		if err != nil {
+			return 0, nil, err
+		}
+		count, err := result.RowsAffected()
+		if err != nil {
+			return 0, nil, err
+		}
+		removed += count
+	}
+
+	if change.Rescheduled {
+		if err := dropOrphanedOverrides(ctx, tx, event); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	promoted := []*Attendee{}
+	if change.FillWaitlist {
+		if promoted, err = fillFromWaitlist(ctx, tx, event.Id); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return removed, promoted, tx.Commit()
 }
 
-// delete event by Id
-func (m *EventModel) Delete(Id int) error {
+// dropOrphanedOverrides deletes the overrides of occurrences an event's
+// schedule no longer has, all of them once it stops repeating
+func dropOrphanedOverrides(ctx context.Context, tx *sql.Tx, event *Event) error {
+	rows, err := tx.QueryContext(ctx, "SELECT DISTINCT occurrence FROM event_occurrence_overrides WHERE event_id = $1", event.Id)
+	if err != nil {
+		return err
+	}
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var rule *recurrence.Rule
+	if event.IsRecurring() {
+		if rule, err = event.Rule(); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range keys {
+		start, err := time.Parse(time.RFC3339, key)
+		if err == nil && rule != nil && rule.Includes(event.FirstStart(), start) {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM event_occurrence_overrides WHERE event_id = $1 AND occurrence = $2", event.Id, key); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// move an event to another status. It only changes while the event is still
+// in the status it was read with, so of two requests racing to change it the
+// second gets ErrEventStatusChanged.
+func (m *EventModel) SetStatus(event *Event, status string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	query := `DELETE FROM events WHERE id = $1`
+	now := time.Now().UTC()
+	query := `UPDATE events SET status = $1, status_changed_at = $2 WHERE id = $3 AND status = $4`
+	result, err := m.DB.ExecContext(ctx, query, status, now, event.Id, event.Status)
+	if err != nil {
+		return err
+	}
 
-	_, err := m.DB.ExecContext(ctx, query, Id)
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return ErrEventStatusChanged
+	}
 
+	event.Status = status
+	event.StatusChangedAt = &now
 	return nil
 }
 
+// delete event by Id, along with its registrations and occurrence overrides.
+// Foreign keys are not enforced, so the ON DELETE CASCADE clauses never fire
+func (m *EventModel) Delete(Id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		`DELETE FROM attendees WHERE event_id = $1`,
+		`DELETE FROM event_occurrence_overrides WHERE event_id = $1`,
+		`DELETE FROM events WHERE id = $1`,
+	} {
+		if _, err := tx.ExecContext(ctx, query, Id); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+
+// EventSearchResult is an event matched by full-text search, with the
+// matching terms wrapped in <mark> tags. The rest of the highlighted text is
+// HTML-escaped, so the highlights are safe to render as HTML.
+type EventSearchResult struct {
+	Event
+	Rank       float64           `json:"rank"`
+	Highlights map[string]string `json:"highlights"`
+}
+
+// FTS5 wraps matches in these while the column text is still raw; they are
+// swapped for <mark> tags once the text has been escaped
+const (
+	matchStart = "\x02"
+	matchEnd   = "\x03"
+)
+
+// markMatches HTML-escapes highlighted column text and turns the match markers into <mark> tags
+func markMatches(text string) string {
+	text = html.EscapeString(text)
+	return strings.NewReplacer(matchStart, "<mark>", matchEnd, "</mark>").Replace(text)
+}
+
+// ftsQuery turns free text into an FTS5 query: every word is quoted so user
+// input can never be parsed as FTS syntax, and matched as a prefix
+func ftsQuery(text string) string {
+	terms := []string{}
+	for _, word := range strings.Fields(text) {
+		word = strings.ReplaceAll(word, `"`, `""`)
+		terms = append(terms, `"`+word+`"*`)
+	}
+	return strings.Join(terms, " ")
+}
+
+// search the events viewer may see by name, description and location, best matches first
+func (m *EventModel) Search(query string, limit, offset int, viewer *User) ([]*EventSearchResult, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	match := ftsQuery(query)
+	if match == "" {
+		return []*EventSearchResult{}, 0, nil
+	}
+
+	where, args := visibleTo(viewer, ListQuery{}, []string{"events_fts MATCH ?"}, []interface{}{match})
+	conditions := strings.Join(where, " AND ")
+
+	var total int
+	countQuery := `SELECT COUNT(*) FROM events_fts JOIN events e ON e.id = events_fts.rowid WHERE ` + conditions
+	if err := m.DB.QueryRowContext(ctx, countQuery, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	searchQuery := `
+		SELECT
+			bm25(events_fts, 10.0, 2.0, 5.0),
+			highlight(events_fts, 0, char(2), char(3)),
+			snippet(events_fts, 1, char(2), char(3), '…', 12),
+			highlight(events_fts, 2, char(2), char(3)),
+			` + eventColumns + `
+		FROM events_fts
+		JOIN events e ON e.id = events_fts.rowid
+		WHERE ` + conditions + `
+		ORDER BY bm25(events_fts, 10.0, 2.0, 5.0)
+		LIMIT ? OFFSET ?
+	`
+
+	rows, err := m.DB.QueryContext(ctx, searchQuery, append(args, limit, offset)...)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	results := []*EventSearchResult{}
+	for rows.Next() {
+		var result EventSearchResult
+		var name, description, location string
+		event, err := scanEvent(rows, &result.Rank, &name, &description, &location)
+		if err != nil {
+			return nil, 0, err
+		}
+		result.Event = *event
+		result.Highlights = map[string]string{
+			"name":        markMatches(name),
+			"description": markMatches(description),
+			"location":    markMatches(location),
+		}
+		results = append(results, &result)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
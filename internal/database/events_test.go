@@ -0,0 +1,144 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+// a daily series of five, starting on 4 May 2026 at 09:00 UTC, with an
+// attendee and a renamed occurrence on each of the 5th and 6th
+func seriesWithRegistrations(t *testing.T) (Models, *Event) {
+	t.Helper()
+	models := NewModels(dbtest.New(t))
+
+	user := &User{Username: "owner", Email: "owner@example.com", Password: "x"}
+	if err := models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+
+	name, description, location, rule, zone := "Standup", "Daily sync", "Room 1", "FREQ=DAILY;COUNT=5", "UTC"
+	start := time.Date(2026, 5, 4, 9, 0, 0, 0, time.UTC)
+	end := start.Add(15 * time.Minute)
+	event := &Event{Name: &name, Description: &description, Location: &location, StartsAt: &start, EndsAt: &end,
+		Timezone: &zone, RRule: &rule, OwnerId: &user.ID, Status: EventPublished}
+	if err := models.Events.Insert(event); err != nil {
+		t.Fatal(err)
+	}
+
+	renamed := "Long standup"
+	for _, day := range []int{5, 6} {
+		key := OccurrenceKey(time.Date(2026, 5, day, 9, 0, 0, 0, time.UTC))
+		if _, err := models.Attendees.Register(event.Id, key, user.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := models.Events.OverrideOccurrence(event.Id, &OccurrenceOverride{Occurrence: key, Scope: OverrideThis, Name: &renamed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return models, event
+}
+
+func countRows(t *testing.T, models Models, table string, eventId int) int {
+	t.Helper()
+
+	var count int
+	if err := models.Events.DB.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE event_id = ?", eventId).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	return count
+}
+
+func TestUpdateExcludedOccurrences(t *testing.T) {
+	models, event := seriesWithRegistrations(t)
+
+	excluded := time.Date(2026, 5, 5, 9, 0, 0, 0, time.UTC)
+	event.ExDates = []time.Time{excluded}
+	removed, _, err := models.Events.Update(event, EventUpdate{Excluded: []time.Time{excluded}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed != 1 {
+		t.Errorf("removed %d registrations, want 1", removed)
+	}
+	if n := countRows(t, models, "attendees", event.Id); n != 1 {
+		t.Errorf("%d registrations left, want the one on the 6th", n)
+	}
+	if n := countRows(t, models, "event_occurrence_overrides", event.Id); n != 1 {
+		t.Errorf("%d overrides left, want the one on the 6th", n)
+	}
+}
+
+func TestUpdateRescheduledDropsOrphanedOverrides(t *testing.T) {
+	models, event := seriesWithRegistrations(t)
+	if _, err := models.Events.DB.Exec("DELETE FROM attendees WHERE event_id = ?", event.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rule string
+		want int
+	}{
+		// the 5th and 6th are still occurrences
+		{"FREQ=DAILY;COUNT=10", 2},
+		// only the 6th is
+		{"FREQ=DAILY;INTERVAL=2;COUNT=5", 1},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		rule := tt.rule
+		event.RRule = &rule
+		if _, _, err := models.Events.Update(event, EventUpdate{Rescheduled: true}); err != nil {
+			t.Fatal(err)
+		}
+		if n := countRows(t, models, "event_occurrence_overrides", event.Id); n != tt.want {
+			t.Errorf("rule %q: %d overrides left, want %d", tt.rule, n, tt.want)
+		}
+	}
+}
+
+func TestDeleteRemovesRegistrationsAndOverrides(t *testing.T) {
+	models, event := seriesWithRegistrations(t)
+
+	if err := models.Events.Delete(event.Id); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"attendees", "event_occurrence_overrides"} {
+		if n := countRows(t, models, table, event.Id); n != 0 {
+			t.Errorf("%d rows left in %s", n, table)
+		}
+	}
+	if deleted, err := models.Events.GET(event.Id); err != nil || deleted != nil {
+		t.Errorf("event still there: %+v, %v", deleted, err)
+	}
+}
+
+func TestCanTransitionEvent(t *testing.T) {
+	tests := []struct {
+		from, to string
+		want     bool
+	}{
+		{EventDraft, EventPublished, true},
+		{EventDraft, EventCancelled, true},
+		{EventPublished, EventCancelled, true},
+		{EventPublished, EventCompleted, true},
+		// a draft nobody could attend cannot have taken place
+		{EventDraft, EventCompleted, false},
+		{EventPublished, EventDraft, false},
+		{EventPublished, EventPublished, false},
+		{EventCancelled, EventPublished, false},
+		{EventCancelled, EventCompleted, false},
+		{EventCompleted, EventCancelled, false},
+		{"unknown", EventPublished, false},
+	}
+
+	for _, tt := range tests {
+		if got := CanTransitionEvent(tt.from, tt.to); got != tt.want {
+			t.Errorf("CanTransitionEvent(%s, %s) = %v, want %v", tt.from, tt.to, got, tt.want)
+		}
+	}
+}
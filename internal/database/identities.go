@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+type Identity struct {
+	Id          int        `json:"id"`
+	UserId      int        `json:"userId"`
+	Provider    string     `json:"provider"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	LastLoginAt *time.Time `json:"lastLoginAt"`
+}
+
+// find which user an external identity belongs to; 0 when it is not linked yet
+func (m *IdentityModel) GetUserId(provider, subject string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var userId int
+	query := "SELECT user_id FROM user_identities WHERE provider = $1 AND subject = $2"
+	err := m.DB.QueryRowContext(ctx, query, provider, subject).Scan(&userId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return userId, err
+}
+
+// link an external identity to a user
+func (m *IdentityModel) Link(userId int, provider, subject, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "INSERT INTO user_identities (user_id, provider, subject, email, created_at) VALUES ($1, $2, $3, $4, $5)"
+	_, err := m.DB.ExecContext(ctx, query, userId, provider, subject, email, time.Now().UTC())
+	return err
+}
+
+// remember when an identity was last used to sign in
+func (m *IdentityModel) Touch(provider, subject, email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE user_identities SET last_login_at = $1, email = $2 WHERE provider = $3 AND subject = $4"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), email, provider, subject)
+	return err
+}
+
+// list the identities linked to a user
+func (m *IdentityModel) ListForUser(userId int) ([]*Identity, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "SELECT id, user_id, provider, subject, email, created_at, last_login_at FROM user_identities WHERE user_id = $1 ORDER BY id"
+	rows, err := m.DB.QueryContext(ctx, query, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	identities := []*Identity{}
+	for rows.Next() {
+		var identity Identity
+		if err := rows.Scan(&identity.Id, &identity.UserId, &identity.Provider, &identity.Subject, &identity.Email, &identity.CreatedAt, &identity.LastLoginAt); err != nil {
+			return nil, err
+		}
+		identities = append(identities, &identity)
+	}
+
+	return identities, rows.Err()
+}
@@ -0,0 +1,249 @@
+package database
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// MFAKeySize is the length of the server key that encrypts TOTP secrets and
+// keys the recovery code hashes
+const MFAKeySize = 32
+
+// encrypted secrets are stored with this prefix; secrets saved before
+// encryption was added are plain base32 and never contain a colon
+const encryptedSecretPrefix = "v1:"
+
+var ErrNoMFAKey = errors.New("no two-factor encryption key is configured")
+
+type MFAModel struct {
+	DB *sql.DB
+	// Key encrypts TOTP secrets with AES-GCM and keys the HMAC of recovery codes
+	Key []byte
+}
+
+// get the TOTP secret of a user, or "" when two-factor authentication is off
+func (m *MFAModel) GetSecret(userId int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stored string
+	query := "SELECT totp_secret FROM user_mfa WHERE user_id = $1"
+	err := m.DB.QueryRowContext(ctx, query, userId).Scan(&stored)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(stored, encryptedSecretPrefix) {
+		return stored, nil
+	}
+	return m.decryptSecret(stored)
+}
+
+// turn two-factor authentication on, replacing any earlier secret and recovery codes
+func (m *MFAModel) Enable(userId int, secret string, recoveryCodes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	encrypted, err := m.encryptSecret(secret)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := "INSERT OR REPLACE INTO user_mfa (user_id, totp_secret, enabled_at) VALUES ($1, $2, $3)"
+	if _, err := tx.ExecContext(ctx, query, userId, encrypted, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	hashes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashes = append(hashes, m.hashRecoveryCode(code))
+	}
+	if err := replaceRecoveryCodes(ctx, tx, userId, hashes); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EncryptStoredSecrets encrypts the TOTP secrets saved in plain text before
+// secrets were encrypted, returning how many it changed
+func (m *MFAModel) EncryptStoredSecrets() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, "SELECT user_id, totp_secret FROM user_mfa WHERE totp_secret NOT LIKE $1", encryptedSecretPrefix+"%")
+	if err != nil {
+		return 0, err
+	}
+	plain := map[int]string{}
+	for rows.Next() {
+		var userId int
+		var secret string
+		if err := rows.Scan(&userId, &secret); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		plain[userId] = secret
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for userId, secret := range plain {
+		encrypted, err := m.encryptSecret(secret)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE user_mfa SET totp_secret = $1 WHERE user_id = $2", encrypted, userId); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(plain), tx.Commit()
+}
+
+// turn two-factor authentication off
+func (m *MFAModel) Disable(userId int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_mfa WHERE user_id = $1", userId); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// spend a recovery code; false when it does not exist or was already used.
+// Codes issued before they were keyed are still found by their plain SHA-256.
+func (m *MFAModel) UseRecoveryCode(userId int, code string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	legacy := sha256.Sum256([]byte(code))
+	query := "UPDATE mfa_recovery_codes SET used_at = $1 WHERE user_id = $2 AND code_hash IN ($3, $4) AND used_at IS NULL"
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), userId, m.hashRecoveryCode(code), hex.EncodeToString(legacy[:]))
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// count the recovery codes a user has left
+func (m *MFAModel) RemainingRecoveryCodes(userId int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	query := "SELECT COUNT(*) FROM mfa_recovery_codes WHERE user_id = $1 AND used_at IS NULL"
+	err := m.DB.QueryRowContext(ctx, query, userId).Scan(&count)
+	return count, err
+}
+
+func replaceRecoveryCodes(ctx context.Context, tx *sql.Tx, userId int, codeHashes []string) error {
+	if _, err := tx.ExecContext(ctx, "DELETE FROM mfa_recovery_codes WHERE user_id = $1", userId); err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		query := "INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES ($1, $2)"
+		if _, err := tx.ExecContext(ctx, query, userId, hash); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hashRecoveryCode keys the hash with the server key, so a leaked table
+// cannot be checked against guesses without it
+func (m *MFAModel) hashRecoveryCode(code string) string {
+	mac := hmac.New(sha256.New, m.Key)
+	mac.Write([]byte(code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (m *MFAModel) gcm() (cipher.AEAD, error) {
+	if len(m.Key) != MFAKeySize {
+		return nil, ErrNoMFAKey
+	}
+	block, err := aes.NewCipher(m.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (m *MFAModel) encryptSecret(secret string) (string, error) {
+	gcm, err := m.gcm()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, []byte(secret), nil)
+	return encryptedSecretPrefix + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+func (m *MFAModel) decryptSecret(stored string) (string, error) {
+	gcm, err := m.gcm()
+	if err != nil {
+		return "", err
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(stored, encryptedSecretPrefix))
+	if err != nil || len(sealed) < gcm.NonceSize() {
+		return "", fmt.Errorf("stored two-factor secret is malformed")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	secret, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("stored two-factor secret cannot be decrypted; was the key changed? %w", err)
+	}
+	return string(secret), nil
+}
@@ -0,0 +1,130 @@
+package database_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+const totpSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+func newMFAModels(t *testing.T) (database.Models, *database.User) {
+	t.Helper()
+
+	models := database.NewModels(dbtest.New(t))
+	models.MFA.Key = bytes.Repeat([]byte{7}, database.MFAKeySize)
+	user := &database.User{Username: "mfa", Email: "mfa@example.com", Password: "x"}
+	if err := models.Users.Insert(user); err != nil {
+		t.Fatal(err)
+	}
+	return models, user
+}
+
+func storedSecret(t *testing.T, models database.Models, userId int) string {
+	t.Helper()
+
+	var stored string
+	if err := models.MFA.DB.QueryRow("SELECT totp_secret FROM user_mfa WHERE user_id = ?", userId).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	return stored
+}
+
+func TestMFASecretIsEncrypted(t *testing.T) {
+	models, user := newMFAModels(t)
+
+	if err := models.MFA.Enable(user.ID, totpSecret, nil); err != nil {
+		t.Fatal(err)
+	}
+	if stored := storedSecret(t, models, user.ID); strings.Contains(stored, totpSecret) {
+		t.Errorf("secret stored in plain text: %q", stored)
+	}
+
+	secret, err := models.MFA.GetSecret(user.ID)
+	if err != nil || secret != totpSecret {
+		t.Errorf("got %q, %v; want %q", secret, err, totpSecret)
+	}
+
+	models.MFA.Key = bytes.Repeat([]byte{8}, database.MFAKeySize)
+	if _, err := models.MFA.GetSecret(user.ID); err == nil {
+		t.Error("decrypted the secret with another key")
+	}
+}
+
+func TestEncryptStoredSecrets(t *testing.T) {
+	models, user := newMFAModels(t)
+
+	// a secret saved before secrets were encrypted
+	if _, err := models.MFA.DB.Exec("INSERT INTO user_mfa (user_id, totp_secret) VALUES (?, ?)", user.ID, totpSecret); err != nil {
+		t.Fatal(err)
+	}
+	if secret, err := models.MFA.GetSecret(user.ID); err != nil || secret != totpSecret {
+		t.Fatalf("plain secret: got %q, %v", secret, err)
+	}
+
+	for _, want := range []int{1, 0} {
+		encrypted, err := models.MFA.EncryptStoredSecrets()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encrypted != want {
+			t.Errorf("encrypted %d secrets, want %d", encrypted, want)
+		}
+	}
+
+	if stored := storedSecret(t, models, user.ID); stored == totpSecret {
+		t.Error("secret still stored in plain text")
+	}
+	if secret, err := models.MFA.GetSecret(user.ID); err != nil || secret != totpSecret {
+		t.Errorf("got %q, %v; want %q", secret, err, totpSecret)
+	}
+}
+
+func TestRecoveryCodesAreKeyed(t *testing.T) {
+	models, user := newMFAModels(t)
+
+	const code, oldCode = "abcd2345", "wxyz6789"
+	if err := models.MFA.Enable(user.ID, totpSecret, []string{code}); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := sha256.Sum256([]byte(code))
+	var stored string
+	if err := models.MFA.DB.QueryRow("SELECT code_hash FROM mfa_recovery_codes WHERE user_id = ?", user.ID).Scan(&stored); err != nil {
+		t.Fatal(err)
+	}
+	if stored == hex.EncodeToString(plain[:]) {
+		t.Error("recovery code stored as a plain SHA-256")
+	}
+
+	// a code issued before the hashes were keyed
+	old := sha256.Sum256([]byte(oldCode))
+	if _, err := models.MFA.DB.Exec("INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES (?, ?)", user.ID, hex.EncodeToString(old[:])); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		code string
+		want bool
+	}{
+		{code, true},
+		{code, false},
+		{oldCode, true},
+		{oldCode, false},
+		{"nope0000", false},
+	}
+	for i, tt := range tests {
+		used, err := models.MFA.UseRecoveryCode(user.ID, tt.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used != tt.want {
+			t.Errorf("attempt %d with %q: used = %v, want %v", i+1, tt.code, used, tt.want)
+		}
+	}
+}
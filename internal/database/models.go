@@ -6,6 +6,12 @@ type Models struct{
 	Users  UserModel
 	Events EventModel
 	Attendees AttendeeModel
+	Roles     RoleModel
+	MFA       MFAModel
+	Audit     AuditModel
+	APIKeys   APIKeyModel
+	Identities IdentityModel
+	CalendarFeeds CalendarFeedModel
 }
 
 func NewModels(db *sql.DB) Models {
@@ -13,5 +19,11 @@ func NewModels(db *sql.DB) Models {
 		Users:     UserModel{DB: db},
 		Events:    EventModel{DB: db},
 		Attendees: AttendeeModel{DB: db},
+		Roles:     RoleModel{DB: db},
+		MFA:       MFAModel{DB: db},
+		Audit:     AuditModel{DB: db},
+		APIKeys:   APIKeyModel{DB: db},
+		Identities: IdentityModel{DB: db},
+		CalendarFeeds: CalendarFeedModel{DB: db},
 	}
 }
\ No newline at end of file
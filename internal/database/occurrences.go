@@ -0,0 +1,443 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// override scopes: one occurrence, or an occurrence and every later one
+const (
+	OverrideThis      = "this"
+	OverrideFollowing = "following"
+)
+
+const (
+	// MaxOccurrences caps how many occurrences one event expands into per request
+	MaxOccurrences = 1000
+	// MaxOccurrenceWindow is the longest time window occurrences are expanded over
+	MaxOccurrenceWindow = 366 * 24 * time.Hour
+)
+
+var ErrOccurrenceNotFound = errors.New("event has no such occurrence")
+
+// Occurrence is one instance of an event. For a recurring event StartsAt and
+// EndsAt are when this instance happens, after any edits, and OccurrenceId
+// identifies it.
+type Occurrence struct {
+	Event
+	OccurrenceId string `json:"occurrenceId,omitempty"`
+	// Modified is set when an override changed this occurrence
+	Modified bool `json:"modified,omitempty"`
+}
+
+// OccurrenceOverride changes some fields of one occurrence (scope "this") or
+// of an occurrence and all later ones (scope "following"). A StartsAt on a
+// "following" override moves every later occurrence by the same amount, and
+// an EndsAt gives them all the length it gives this one. A moved occurrence
+// keeps its length unless EndsAt is set too.
+type OccurrenceOverride struct {
+	Occurrence  string     `json:"occurrence"`
+	Scope       string     `json:"scope"`
+	Name        *string    `json:"name,omitempty"`
+	Description *string    `json:"description,omitempty"`
+	StartsAt    *time.Time `json:"startsAt,omitempty"`
+	EndsAt      *time.Time `json:"endsAt,omitempty"`
+	Location    *string    `json:"location,omitempty"`
+	Capacity    *int       `json:"capacity,omitempty"`
+}
+
+// the overrides of an event, oldest occurrence first; at the same occurrence
+// the "following" override applies before the "this" one
+func (m *EventModel) overrides(ctx context.Context, eventId int) ([]*OccurrenceOverride, error) {
+	query := `
+		SELECT occurrence, scope, name, description, starts_at, ends_at, location, capacity
+		FROM event_occurrence_overrides
+		WHERE event_id = $1
+		ORDER BY occurrence, scope = $2
+	`
+	rows, err := m.DB.QueryContext(ctx, query, eventId, OverrideThis)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	overrides := []*OccurrenceOverride{}
+	for rows.Next() {
+		var o OccurrenceOverride
+		var capacity sql.NullInt64
+		if err := rows.Scan(&o.Occurrence, &o.Scope, &o.Name, &o.Description, &o.StartsAt, &o.EndsAt, &o.Location, &capacity); err != nil {
+			return nil, err
+		}
+		if capacity.Valid {
+			value := int(capacity.Int64)
+			o.Capacity = &value
+		}
+		overrides = append(overrides, &o)
+	}
+
+	return overrides, rows.Err()
+}
+
+// applyOverrides builds the occurrence the rule schedules at scheduled
+func applyOverrides(event *Event, scheduled time.Time, overrides []*OccurrenceOverride) *Occurrence {
+	key := OccurrenceKey(scheduled)
+	occurrence := &Occurrence{Event: *event, OccurrenceId: key}
+	occurrence.ExDates = nil
+
+	start := scheduled
+	duration := event.Duration()
+	var end *time.Time
+	for _, o := range overrides {
+		if o.Occurrence > key {
+			break
+		}
+		if o.Scope == OverrideThis && o.Occurrence != key {
+			continue
+		}
+
+		if o.Name != nil {
+			occurrence.Name = o.Name
+		}
+		if o.Description != nil {
+			occurrence.Description = o.Description
+		}
+		if o.Location != nil {
+			occurrence.Location = o.Location
+		}
+		if o.Capacity != nil {
+			occurrence.Capacity = o.Capacity
+		}
+		original, _ := time.Parse(time.RFC3339, o.Occurrence)
+		if o.StartsAt != nil {
+			if o.Scope == OverrideThis {
+				start = *o.StartsAt
+			} else {
+				start = scheduled.Add(o.StartsAt.Sub(original))
+			}
+		}
+		if o.EndsAt != nil {
+			if o.Scope == OverrideThis {
+				end = o.EndsAt
+			} else if o.StartsAt != nil {
+				duration = o.EndsAt.Sub(*o.StartsAt)
+			} else {
+				duration = o.EndsAt.Sub(original)
+			}
+		}
+		occurrence.Modified = true
+	}
+
+	loc := event.Zone()
+	startsAt := start.In(loc)
+	endsAt := startsAt.Add(duration)
+	if end != nil {
+		endsAt = end.In(loc)
+	}
+	occurrence.StartsAt = &startsAt
+	occurrence.EndsAt = &endsAt
+
+	return occurrence
+}
+
+// Occurrences expands an event into the occurrences starting within
+// [from, to), ordered by start. A one-off event is its only occurrence.
+func (m *EventModel) Occurrences(event *Event, from, to time.Time) ([]*Occurrence, error) {
+	if !event.IsRecurring() {
+		if event.StartsAt != nil && !event.StartsAt.Before(from) && event.StartsAt.Before(to) {
+			return []*Occurrence{{Event: *event}}, nil
+		}
+		return []*Occurrence{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rule, err := event.Rule()
+	if err != nil {
+		return nil, err
+	}
+	overrides, err := m.overrides(ctx, event.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	// a moved occurrence can land in the window from outside it
+	var reach time.Duration
+	for _, o := range overrides {
+		original, err := time.Parse(time.RFC3339, o.Occurrence)
+		if err != nil || o.StartsAt == nil {
+			continue
+		}
+		shift := o.StartsAt.Sub(original)
+		if shift < 0 {
+			shift = -shift
+		}
+		if shift > reach {
+			reach = shift
+		}
+	}
+
+	occurrences := []*Occurrence{}
+	for _, start := range rule.Between(event.FirstStart(), from.Add(-reach), to.Add(reach), event.ExDates, MaxOccurrences) {
+		occurrence := applyOverrides(event, start, overrides)
+		if !occurrence.StartsAt.Before(from) && occurrence.StartsAt.Before(to) {
+			occurrences = append(occurrences, occurrence)
+		}
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool { return occurrences[i].StartsAt.Before(*occurrences[j].StartsAt) })
+
+	return occurrences, nil
+}
+
+// GetOccurrence returns one occurrence of a recurring event by its id (the
+// start its rule gives it), or ErrOccurrenceNotFound when the rule has no
+// such occurrence or it was cancelled
+func (m *EventModel) GetOccurrence(event *Event, occurrenceId string) (*Occurrence, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start, err := time.Parse(time.RFC3339, occurrenceId)
+	if err != nil || !event.IsRecurring() {
+		return nil, ErrOccurrenceNotFound
+	}
+
+	rule, err := event.Rule()
+	if err != nil {
+		return nil, err
+	}
+	if !rule.Includes(event.FirstStart(), start) {
+		return nil, ErrOccurrenceNotFound
+	}
+	for _, excluded := range event.ExDates {
+		if excluded.Equal(start) {
+			return nil, ErrOccurrenceNotFound
+		}
+	}
+
+	overrides, err := m.overrides(ctx, event.Id)
+	if err != nil {
+		return nil, err
+	}
+
+	return applyOverrides(event, start, overrides), nil
+}
+
+// ListOccurrences expands the events viewer may see matching the query
+// filters into their occurrences starting within [from, to), ordered by start
+// (latest first when q.Desc) and paged by offset. Recurring events match by
+// their occurrences rather than by their first start.
+func (m *EventModel) ListOccurrences(q ListQuery, from, to time.Time, viewer *User) ([]*Occurrence, *PageInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	filters := ListQuery{Filters: map[string]string{}}
+	for name, value := range q.Filters {
+		if name != "from" && name != "to" {
+			filters.Filters[name] = value
+		}
+	}
+	where, args := eventFilters(filters)
+	where, args = visibleTo(viewer, filters, where, args)
+
+	const windowFormat = "2006-01-02 15:04:05"
+	where = append(where, "((e.rrule <> '' AND datetime(e.starts_at) < ?) OR (datetime(e.starts_at) >= ? AND datetime(e.starts_at) < ?))")
+	args = append(args, to.UTC().Format(windowFormat), from.UTC().Format(windowFormat), to.UTC().Format(windowFormat))
+
+	query := `SELECT ` + eventColumns + ` FROM events e WHERE ` + strings.Join(where, " AND ") + ` ORDER BY e.id`
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := []*Event{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		events = append(events, event)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	occurrences := []*Occurrence{}
+	for _, event := range events {
+		expanded, err := m.Occurrences(event, from, to)
+		if err != nil {
+			return nil, nil, fmt.Errorf("expand event %d: %w", event.Id, err)
+		}
+		occurrences = append(occurrences, expanded...)
+	}
+	sort.SliceStable(occurrences, func(i, j int) bool {
+		a, b := occurrences[i], occurrences[j]
+		if !a.StartsAt.Equal(*b.StartsAt) {
+			return a.StartsAt.Before(*b.StartsAt) != q.Desc
+		}
+		return a.Id < b.Id
+	})
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	page := &PageInfo{Total: len(occurrences), Limit: limit, Offset: q.Offset}
+
+	if q.Offset >= len(occurrences) {
+		return []*Occurrence{}, page, nil
+	}
+	end := q.Offset + limit
+	if end > len(occurrences) {
+		end = len(occurrences)
+	}
+	page.HasMore = end < len(occurrences)
+
+	return occurrences[q.Offset:end], page, nil
+}
+
+// OverrideOccurrence saves an edit of one occurrence, or of it and all later
+// ones. A "following" edit takes precedence over earlier edits of the same
+// fields on those later occurrences, so they are cleared. Seats a new capacity
+// adds go to the waitlist in the same transaction; the promoted attendees are
+// returned.
+func (m *EventModel) OverrideOccurrence(eventId int, override *OccurrenceOverride) ([]*Attendee, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if override.Scope == OverrideFollowing {
+		fields := []struct {
+			column string
+			set    bool
+		}{
+			{"name", override.Name != nil},
+			{"description", override.Description != nil},
+			{"starts_at", override.StartsAt != nil},
+			{"ends_at", override.EndsAt != nil},
+			{"location", override.Location != nil},
+			{"capacity", override.Capacity != nil},
+		}
+		for _, field := range fields {
+			if !field.set {
+				continue
+			}
+			query := fmt.Sprintf(`UPDATE event_occurrence_overrides SET %s = NULL
+				WHERE event_id = $1 AND (occurrence > $2 OR (occurrence = $2 AND scope = $3))`, field.column)
+			if _, err := tx.ExecContext(ctx, query, eventId, override.Occurrence, OverrideThis); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	query := `
+		INSERT INTO event_occurrence_overrides (event_id, occurrence, scope, name, description, starts_at, ends_at, location, capacity)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (event_id, occurrence, scope) DO UPDATE SET
+			name = COALESCE(excluded.name, name),
+			description = COALESCE(excluded.description, description),
+			starts_at = COALESCE(excluded.starts_at, starts_at),
+			ends_at = COALESCE(excluded.ends_at, ends_at),
+			location = COALESCE(excluded.location, location),
+			capacity = COALESCE(excluded.capacity, capacity)
+	`
+	_, err = tx.ExecContext(ctx, query, eventId, override.Occurrence, override.Scope,
+		override.Name, override.Description, utc(override.StartsAt), utc(override.EndsAt), override.Location, override.Capacity)
+	if err != nil {
+		return nil, err
+	}
+
+	// overrides whose every field was superseded no longer change anything
+	cleanup := `DELETE FROM event_occurrence_overrides WHERE event_id = $1
+		AND name IS NULL AND description IS NULL AND starts_at IS NULL AND ends_at IS NULL AND location IS NULL AND capacity IS NULL`
+	if _, err := tx.ExecContext(ctx, cleanup, eventId); err != nil {
+		return nil, err
+	}
+
+	promoted := []*Attendee{}
+	if override.Capacity != nil {
+		if promoted, err = fillFromWaitlist(ctx, tx, eventId); err != nil {
+			return nil, err
+		}
+	}
+
+	return promoted, tx.Commit()
+}
+
+// CancelOccurrence removes one occurrence of a recurring event (scope "this")
+// by adding it to the event's EXDATEs, or ends the series before it (scope
+// "following"). Registrations for the cancelled occurrences are deleted and
+// their number returned.
+func (m *EventModel) CancelOccurrence(event *Event, occurrenceId, scope string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	start, err := time.Parse(time.RFC3339, occurrenceId)
+	if err != nil {
+		return 0, ErrOccurrenceNotFound
+	}
+	key := OccurrenceKey(start)
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var removed sql.Result
+	if scope == OverrideThis {
+		exdates := append(append([]time.Time{}, event.ExDates...), start)
+		if _, err := tx.ExecContext(ctx, "UPDATE events SET exdates = $1 WHERE id = $2", exdatesValue(exdates), event.Id); err != nil {
+			return 0, err
+		}
+		query := "DELETE FROM event_occurrence_overrides WHERE event_id = $1 AND occurrence = $2 AND scope = $3"
+		if _, err := tx.ExecContext(ctx, query, event.Id, key, OverrideThis); err != nil {
+			return 0, err
+		}
+		removed, err = tx.ExecContext(ctx, "DELETE FROM attendees WHERE event_id = $1 AND occurrence = $2", event.Id, key)
+		if err != nil {
+			return 0, err
+		}
+	} else {
+		rule, err := event.Rule()
+		if err != nil {
+			return 0, err
+		}
+		exdates := []time.Time{}
+		for _, excluded := range event.ExDates {
+			if excluded.Before(start) {
+				exdates = append(exdates, excluded)
+			}
+		}
+
+		query := "UPDATE events SET rrule = $1, exdates = $2 WHERE id = $3"
+		if _, err := tx.ExecContext(ctx, query, rule.EndBefore(start).String(), exdatesValue(exdates), event.Id); err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM event_occurrence_overrides WHERE event_id = $1 AND occurrence >= $2", event.Id, key); err != nil {
+			return 0, err
+		}
+		removed, err = tx.ExecContext(ctx, "DELETE FROM attendees WHERE event_id = $1 AND occurrence >= $2", event.Id, key)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	count, err := removed.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return count, tx.Commit()
+}
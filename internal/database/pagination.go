@@ -0,0 +1,263 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid or expired cursor")
+
+// FilterKind tells the query parser how to validate a filter value
+type FilterKind int
+
+const (
+	FilterString FilterKind = iota
+	FilterInt
+	FilterTime
+)
+
+// ListSpec describes what a list endpoint may be sorted and filtered by.
+// Sorts maps the public sort name to the SQL expression it orders by, and
+// Allowed restricts a filter to a set of values (comma-separated in the query).
+type ListSpec struct {
+	Sorts       map[string]string
+	DefaultSort string
+	Filters     map[string]FilterKind
+	Allowed     map[string][]string
+}
+
+// ListQuery is a parsed, validated list request. When Cursor is set it takes
+// precedence over Offset.
+type ListQuery struct {
+	Limit   int
+	Offset  int
+	Cursor  string
+	Sort    string
+	Desc    bool
+	Filters map[string]string
+}
+
+// PageInfo is returned alongside every paginated list
+type PageInfo struct {
+	Total      int    `json:"total"`
+	Limit      int    `json:"limit"`
+	Offset     int    `json:"offset"`
+	HasMore    bool   `json:"has_more"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+type cursor struct {
+	Sort  string `json:"s"`
+	Desc  bool   `json:"d"`
+	Value string `json:"v"`
+	Null  bool   `json:"n,omitempty"`
+	Id    int    `json:"id"`
+}
+
+// Filter returns the raw value of a filter, or "" when it was not supplied
+func (q ListQuery) Filter(name string) string {
+	return q.Filters[name]
+}
+
+// FilterList splits a comma-separated filter into its values
+func (q ListQuery) FilterList(name string) []string {
+	values := []string{}
+	for _, value := range strings.Split(q.Filters[name], ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// FilterTime returns a time filter normalised to the format SQLite's datetime() produces
+func (q ListQuery) FilterTime(name string) (string, bool) {
+	value := q.Filters[name]
+	if value == "" {
+		return "", false
+	}
+
+	t, err := ParseFilterTime(value)
+	if err != nil {
+		return "", false
+	}
+
+	return t.UTC().Format("2006-01-02 15:04:05"), true
+}
+
+// ParseFilterTime accepts either an RFC 3339 timestamp or a plain date
+func ParseFilterTime(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+func encodeCursor(c cursor) string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeCursor(value string) (*cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursor
+	if err := json.Unmarshal(raw, &c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	return &c, nil
+}
+
+// pageQuery is the model-specific half of a paginated SELECT
+type pageQuery struct {
+	columns  string
+	from     string
+	where    []string
+	args     []interface{}
+	idColumn string
+}
+
+// paginate runs a count and a keyset/offset page query. scanRow receives the
+// rows positioned on a record whose first column is the cursor sort key.
+func paginate(db *sql.DB, spec ListSpec, q ListQuery, pq pageQuery, scanRow func(rows *sql.Rows, key *sql.NullString) (int, error)) (*PageInfo, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	sortName := q.Sort
+	if sortName == "" {
+		sortName = spec.DefaultSort
+	}
+	sortColumn, ok := spec.Sorts[sortName]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sort field %q", sortName)
+	}
+
+	limit := q.Limit
+	if limit <= 0 {
+		limit = DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		limit = MaxPageSize
+	}
+
+	whereClause := ""
+	if len(pq.where) > 0 {
+		whereClause = " WHERE " + strings.Join(pq.where, " AND ")
+	}
+
+	page := &PageInfo{Limit: limit}
+	countQuery := fmt.Sprintf("SELECT COUNT(*) FROM %s%s", pq.from, whereClause)
+	if err := db.QueryRowContext(ctx, countQuery, pq.args...).Scan(&page.Total); err != nil {
+		return nil, err
+	}
+
+	where := append([]string{}, pq.where...)
+	args := append([]interface{}{}, pq.args...)
+
+	desc := q.Desc
+	offset := q.Offset
+	if q.Cursor != "" {
+		c, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		// a cursor is only meaningful for the ordering it was issued for
+		if c.Sort != sortName || c.Desc != desc {
+			return nil, ErrInvalidCursor
+		}
+
+		op := ">"
+		if desc {
+			op = "<"
+		}
+		// SQLite sorts NULL keys first ascending and last descending, and
+		// never matches them with = or a comparison, so they need their own
+		// branches to be neither skipped nor repeated
+		switch {
+		case c.Null && desc:
+			where = append(where, fmt.Sprintf("(%s IS NULL AND %s < ?)", sortColumn, pq.idColumn))
+			args = append(args, c.Id)
+		case c.Null:
+			where = append(where, fmt.Sprintf("(%[1]s IS NOT NULL OR %[2]s > ?)", sortColumn, pq.idColumn))
+			args = append(args, c.Id)
+		default:
+			nulls := ""
+			if desc {
+				nulls = fmt.Sprintf(" OR %s IS NULL", sortColumn)
+			}
+			// SQLite applies the column's affinity to the text cursor value, so
+			// integer columns still compare numerically here
+			where = append(where, fmt.Sprintf("(%[1]s %[2]s ? OR (%[1]s = ? AND %[3]s %[2]s ?)%[4]s)", sortColumn, op, pq.idColumn, nulls))
+			args = append(args, c.Value, c.Value, c.Id)
+		}
+		offset = 0
+	}
+	page.Offset = offset
+
+	direction := "ASC"
+	if desc {
+		direction = "DESC"
+	}
+
+	query := fmt.Sprintf("SELECT CAST(%s AS TEXT), %s FROM %s", sortColumn, pq.columns, pq.from)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// fetch one extra row to learn whether another page exists
+	query += fmt.Sprintf(" ORDER BY %s %s, %s %s LIMIT ? OFFSET ?", sortColumn, direction, pq.idColumn, direction)
+	args = append(args, limit+1, offset)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var last cursor
+	count := 0
+	for rows.Next() {
+		count++
+		if count > limit {
+			page.HasMore = true
+			break
+		}
+
+		var key sql.NullString
+		id, err := scanRow(rows, &key)
+		if err != nil {
+			return nil, err
+		}
+		last = cursor{Sort: sortName, Desc: desc, Value: key.String, Null: !key.Valid, Id: id}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if page.HasMore {
+		page.NextCursor = encodeCursor(last)
+	}
+
+	return page, nil
+}
+
+// likePattern turns user input into a LIKE substring pattern, escaping wildcards
+func likePattern(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return "%" + replacer.Replace(value) + "%"
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+var itemSpec = ListSpec{
+	Sorts:       map[string]string{"id": "id", "score": "score"},
+	DefaultSort: "id",
+}
+
+// items has runs of equal scores, and scores that sort differently as text
+// (9, 10, 100) than as numbers
+func itemsDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	db := dbtest.New(t)
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, score INTEGER NOT NULL)"); err != nil {
+		t.Fatal(err)
+	}
+	for id, score := range []int{10, 9, 10, 9, 100, 10, 9} {
+		if _, err := db.Exec("INSERT INTO items (id, score) VALUES (?, ?)", id+1, score); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db
+}
+
+func itemPage(db *sql.DB, q ListQuery) ([]int, *PageInfo, error) {
+	ids := []int{}
+	page, err := paginate(db, itemSpec, q, pageQuery{columns: "id", from: "items", idColumn: "id"},
+		func(rows *sql.Rows, key *sql.NullString) (int, error) {
+			var id int
+			if err := rows.Scan(key, &id); err != nil {
+				return 0, err
+			}
+			ids = append(ids, id)
+			return id, nil
+		})
+	return ids, page, err
+}
+
+func TestPaginateCursorWalksTies(t *testing.T) {
+	db := itemsDB(t)
+
+	tests := []struct {
+		name  string
+		sort  string
+		desc  bool
+		limit int
+		want  []int
+	}{
+		{"score ascending, pages split ties", "score", false, 2, []int{2, 4, 7, 1, 3, 6, 5}},
+		{"score descending, pages split ties", "score", true, 2, []int{5, 6, 3, 1, 7, 4, 2}},
+		{"score ascending, one per page", "score", false, 1, []int{2, 4, 7, 1, 3, 6, 5}},
+		{"score ascending, page ends on a tie", "score", false, 3, []int{2, 4, 7, 1, 3, 6, 5}},
+		{"score descending, everything on one page", "score", true, 10, []int{5, 6, 3, 1, 7, 4, 2}},
+		{"default sort", "", false, 3, []int{1, 2, 3, 4, 5, 6, 7}},
+		{"id descending", "id", true, 4, []int{7, 6, 5, 4, 3, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := ListQuery{Sort: tt.sort, Desc: tt.desc, Limit: tt.limit}
+			got := []int{}
+			for pages := 1; ; pages++ {
+				if pages > len(tt.want)+1 {
+					t.Fatalf("cursor never ran out; got %v so far", got)
+				}
+
+				ids, page, err := itemPage(db, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if page.Total != len(tt.want) {
+					t.Errorf("page %d: total %d, want %d", pages, page.Total, len(tt.want))
+				}
+				got = append(got, ids...)
+
+				if !page.HasMore {
+					if page.NextCursor != "" {
+						t.Errorf("page %d: last page has a next cursor", pages)
+					}
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPaginateCursorWalksNullKeys(t *testing.T) {
+	db := dbtest.New(t)
+	if _, err := db.Exec("CREATE TABLE items (id INTEGER PRIMARY KEY, score INTEGER)"); err != nil {
+		t.Fatal(err)
+	}
+	for id, score := range []interface{}{nil, 10, nil, 9, nil, 10} {
+		if _, err := db.Exec("INSERT INTO items (id, score) VALUES (?, ?)", id+1, score); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		desc bool
+		want []int
+	}{
+		// NULL keys sort first ascending and last descending
+		{false, []int{1, 3, 5, 4, 2, 6}},
+		{true, []int{6, 2, 4, 5, 3, 1}},
+	}
+
+	for _, tt := range tests {
+		for limit := 1; limit <= len(tt.want); limit++ {
+			q := ListQuery{Sort: "score", Desc: tt.desc, Limit: limit}
+			got := []int{}
+			for pages := 1; ; pages++ {
+				if pages > len(tt.want)+1 {
+					t.Fatalf("desc %v, limit %d: cursor never ran out; got %v so far", tt.desc, limit, got)
+				}
+
+				ids, page, err := itemPage(db, q)
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, ids...)
+				if !page.HasMore {
+					break
+				}
+				q.Cursor = page.NextCursor
+			}
+
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("desc %v, limit %d: got %v, want %v", tt.desc, limit, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestPaginateRejectsForeignCursors(t *testing.T) {
+	db := itemsDB(t)
+
+	_, first, err := itemPage(db, ListQuery{Sort: "score", Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		q    ListQuery
+	}{
+		{"not base64", ListQuery{Sort: "score", Cursor: "not a cursor!"}},
+		{"not json", ListQuery{Sort: "score", Cursor: "bm90IGpzb24"}},
+		{"other sort", ListQuery{Sort: "id", Cursor: first.NextCursor}},
+		{"other direction", ListQuery{Sort: "score", Desc: true, Cursor: first.NextCursor}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, _, err := itemPage(db, tt.q); !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got %v, want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrRoleNotFound    = errors.New("role does not exist")
+	ErrRoleNotAssigned = errors.New("user does not have this role")
+	ErrLastRoleManager = errors.New("revoking this role would leave no active user who can manage roles")
+)
+
+// built-in role names seeded by the roles migration
+const (
+	RoleAdmin     = "admin"
+	RoleOrganizer = "organizer"
+	RoleMember    = "member"
+)
+
+// permission names seeded by the migrations
+const (
+	PermEventsCreate      = "events:create"
+	PermEventsUpdate      = "events:update"
+	PermEventsDelete      = "events:delete"
+	PermEventsManageAny   = "events:manage_any"
+	PermAttendeesRegister = "attendees:register"
+	PermAttendeesManage   = "attendees:manage"
+	PermRolesManage       = "roles:manage"
+	PermUsersManage       = "users:manage"
+	PermUsersImpersonate  = "users:impersonate"
+)
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+type Role struct {
+	Id          int      `json:"id"`
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// get all roles with their permissions
+func (m *RoleModel) GetAll() ([]*Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `
+		SELECT r.id, r.name, r.description, p.name
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_id = r.id
+		LEFT JOIN permissions p ON p.id = rp.permission_id
+		ORDER BY r.id, p.name
+	`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+	byId := map[int]*Role{}
+	for rows.Next() {
+		var role Role
+		var permission sql.NullString
+		if err := rows.Scan(&role.Id, &role.Name, &role.Description, &permission); err != nil {
+			return nil, err
+		}
+
+		existing, ok := byId[role.Id]
+		if !ok {
+			role.Permissions = []string{}
+			existing = &role
+			byId[role.Id] = existing
+			roles = append(roles, existing)
+		}
+		if permission.Valid {
+			existing.Permissions = append(existing.Permissions, permission.String)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// get role names assigned to a user
+func (m *RoleModel) GetRolesForUser(userId int) ([]string, error) {
+	query := `
+		SELECT r.name
+		FROM roles r
+		JOIN user_roles ur ON ur.role_id = r.id
+		WHERE ur.user_id = $1
+		ORDER BY r.name
+	`
+	return m.queryNames(query, userId)
+}
+
+// get the union of permissions granted to a user through their roles
+func (m *RoleModel) GetPermissionsForUser(userId int) ([]string, error) {
+	query := `
+		SELECT DISTINCT p.name
+		FROM permissions p
+		JOIN role_permissions rp ON rp.permission_id = p.id
+		JOIN user_roles ur ON ur.role_id = rp.role_id
+		WHERE ur.user_id = $1
+		ORDER BY p.name
+	`
+	return m.queryNames(query, userId)
+}
+
+// load roles and permissions onto a user
+func (m *RoleModel) LoadForUser(user *User) error {
+	roles, err := m.GetRolesForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	permissions, err := m.GetPermissionsForUser(user.ID)
+	if err != nil {
+		return err
+	}
+
+	user.Roles = roles
+	user.Permissions = permissions
+	return nil
+}
+
+// grant a role to a user; granting a role the user already has is a no-op
+func (m *RoleModel) Grant(userId int, roleName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := grantRole(ctx, tx, userId, roleName); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// grantRole grants a role inside a transaction, so it can be saved together with the user it is granted to
+func grantRole(ctx context.Context, tx *sql.Tx, userId int, roleName string) error {
+	var roleId int
+	err := tx.QueryRowContext(ctx, `SELECT id FROM roles WHERE name = $1`, roleName).Scan(&roleId)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrRoleNotFound
+		}
+		return err
+	}
+
+	query := `INSERT OR IGNORE INTO user_roles (user_id, role_id) VALUES ($1, $2)`
+	_, err = tx.ExecContext(ctx, query, userId, roleId)
+	return err
+}
+
+// revoke a role from a user. The revoke is refused with ErrLastRoleManager when
+// it takes roles:manage from the last active user holding it; the count is taken
+// in the same transaction so concurrent revokes cannot both pass it.
+func (m *RoleModel) Revoke(userId int, roleName string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	roleId, err := m.roleId(ctx, roleName)
+	if err != nil {
+		return err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var managersBefore int
+	if err := tx.QueryRowContext(ctx, activeWithPermissionQuery, PermRolesManage).Scan(&managersBefore); err != nil {
+		return err
+	}
+
+	query := `DELETE FROM user_roles WHERE user_id = $1 AND role_id = $2`
+	result, err := tx.ExecContext(ctx, query, userId, roleId)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrRoleNotAssigned
+	}
+
+	var managersAfter int
+	if err := tx.QueryRowContext(ctx, activeWithPermissionQuery, PermRolesManage).Scan(&managersAfter); err != nil {
+		return err
+	}
+	if managersBefore > 0 && managersAfter == 0 {
+		return ErrLastRoleManager
+	}
+
+	return tx.Commit()
+}
+
+const activeWithPermissionQuery = `
+	SELECT COUNT(DISTINCT u.id)
+	FROM users u
+	JOIN user_roles ur ON ur.user_id = u.id
+	JOIN role_permissions rp ON rp.role_id = ur.role_id
+	JOIN permissions p ON p.id = rp.permission_id
+	WHERE p.name = $1 AND u.suspended_at IS NULL
+`
+
+// count users who are not suspended and hold a permission through any of their roles
+func (m *RoleModel) CountActiveUsersWithPermission(permission string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, activeWithPermissionQuery, permission).Scan(&count)
+	return count, err
+}
+
+func (m *RoleModel) roleId(ctx context.Context, roleName string) (int, error) {
+	var id int
+	err := m.DB.QueryRowContext(ctx, `SELECT id FROM roles WHERE name = $1`, roleName).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrRoleNotFound
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (m *RoleModel) queryNames(query string, args ...interface{}) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
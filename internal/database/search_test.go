@@ -0,0 +1,54 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+func TestSearchEscapesHighlights(t *testing.T) {
+	db := dbtest.New(t)
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name = 'events_fts'").Scan(&tables); err != nil {
+		t.Fatal(err)
+	}
+	if tables == 0 {
+		t.Skip("full-text search needs the sqlite_fts5 build tag")
+	}
+	models := NewModels(db)
+
+	owner := &User{Username: "owner", Email: "owner@example.com", Password: "x"}
+	if err := models.Users.Insert(owner); err != nil {
+		t.Fatal(err)
+	}
+
+	name := `<script>alert(1)</script> party`
+	description := `Bring <b>snacks</b> & friends to the party`
+	location := `"Main" hall`
+	start := time.Date(2030, 1, 1, 18, 0, 0, 0, time.UTC)
+	end := start.Add(2 * time.Hour)
+	event := &Event{Name: &name, Description: &description, Location: &location, StartsAt: &start, EndsAt: &end, OwnerId: &owner.ID, Status: EventPublished}
+	if err := models.Events.Insert(event); err != nil {
+		t.Fatal(err)
+	}
+
+	results, total, err := models.Events.Search("party", 10, 0, &User{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 || len(results) != 1 {
+		t.Fatalf("got %d results (total %d), want 1", len(results), total)
+	}
+
+	want := map[string]string{
+		"name":        `&lt;script&gt;alert(1)&lt;/script&gt; <mark>party</mark>`,
+		"description": `Bring &lt;b&gt;snacks&lt;/b&gt; &amp; friends to the <mark>party</mark>`,
+		"location":    `&#34;Main&#34; hall`,
+	}
+	for field, value := range want {
+		if got := results[0].Highlights[field]; got != value {
+			t.Errorf("%s highlight = %q, want %q", field, got, value)
+		}
+	}
+}
@@ -3,10 +3,17 @@ package database
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
+var (
+	ErrUsernameTaken = errors.New("username is already taken")
+	ErrEmailTaken    = errors.New("email is already registered")
+)
+
 type UserModel struct {
 	DB *sql.DB
 }
@@ -16,6 +23,16 @@ type User struct {
 	Username string `json:"username" binding:"required"`
 	Password string `json:"password" binding:"required"`
 	Email    string `json:"email" binding:"required"`
+	EmailVerifiedAt *time.Time `json:"emailVerifiedAt"`
+	DisplayName string `json:"displayName"`
+	Bio         string `json:"bio"`
+	AvatarURL   string `json:"avatarUrl"`
+	Timezone    string `json:"timezone"`
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt,omitempty"`
+	SuspendedAt      *time.Time `json:"suspendedAt,omitempty"`
+	SuspensionReason string     `json:"suspensionReason,omitempty"`
+	Roles       []string `json:"roles,omitempty"`
+	Permissions []string `json:"-"`
 }
 
 type SafeUser struct {
@@ -24,16 +41,143 @@ type SafeUser struct {
 	Email    string `json:"email"`
 }
 
+// UserListSpec is what user listings may be sorted and filtered by
+var UserListSpec = ListSpec{
+	Sorts: map[string]string{
+		"id":       "u.id",
+		"username": "u.username",
+		"email":    "u.email",
+	},
+	DefaultSort: "id",
+	Filters: map[string]FilterKind{
+		"username": FilterString,
+		"email":    FilterString,
+	},
+}
+
+// userFilters translates the UserListSpec filters into WHERE conditions
+func userFilters(q ListQuery) ([]string, []interface{}) {
+	where := []string{}
+	args := []interface{}{}
+
+	if username := q.Filter("username"); username != "" {
+		where = append(where, `u.username LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(username))
+	}
+	if email := q.Filter("email"); email != "" {
+		where = append(where, `u.email LIKE ? ESCAPE '\'`)
+		args = append(args, likePattern(email))
+	}
+
+	return where, args
+}
+
+// AdminUser is a user as the admin user listing shows them
+type AdminUser struct {
+	SafeUser
+	DisplayName         string     `json:"displayName"`
+	EmailVerifiedAt     *time.Time `json:"emailVerifiedAt"`
+	SuspendedAt         *time.Time `json:"suspendedAt"`
+	SuspensionReason    string     `json:"suspensionReason,omitempty"`
+	DeletionScheduledAt *time.Time `json:"deletionScheduledAt"`
+	Roles               []string   `json:"roles"`
+}
+
+// account states the admin user listing can filter by
+const (
+	UserStatusActive          = "active"
+	UserStatusSuspended       = "suspended"
+	UserStatusUnverified      = "unverified"
+	UserStatusPendingDeletion = "pending_deletion"
+)
+
+// AdminUserListSpec is what the admin user listing may be sorted and filtered by.
+// q searches the username, email and display name.
+var AdminUserListSpec = ListSpec{
+	Sorts:       UserListSpec.Sorts,
+	DefaultSort: UserListSpec.DefaultSort,
+	Filters: map[string]FilterKind{
+		"q":        FilterString,
+		"username": FilterString,
+		"email":    FilterString,
+		"role":     FilterString,
+		"status":   FilterString,
+	},
+	Allowed: map[string][]string{
+		"status": {UserStatusActive, UserStatusSuspended, UserStatusUnverified, UserStatusPendingDeletion},
+	},
+}
+
+// adminUserFilters translates the AdminUserListSpec filters into WHERE conditions
+func adminUserFilters(q ListQuery) ([]string, []interface{}) {
+	where, args := userFilters(q)
+
+	if search := q.Filter("q"); search != "" {
+		where = append(where, `(u.username LIKE ? ESCAPE '\' OR u.email LIKE ? ESCAPE '\' OR u.display_name LIKE ? ESCAPE '\')`)
+		pattern := likePattern(search)
+		args = append(args, pattern, pattern, pattern)
+	}
+	if role := q.Filter("role"); role != "" {
+		where = append(where, "EXISTS (SELECT 1 FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = u.id AND r.name = ?)")
+		args = append(args, role)
+	}
+
+	statuses := []string{}
+	for _, status := range q.FilterList("status") {
+		switch status {
+		case UserStatusActive:
+			statuses = append(statuses, "(u.suspended_at IS NULL AND u.deletion_scheduled_at IS NULL)")
+		case UserStatusSuspended:
+			statuses = append(statuses, "u.suspended_at IS NOT NULL")
+		case UserStatusUnverified:
+			statuses = append(statuses, "u.email_verified_at IS NULL")
+		case UserStatusPendingDeletion:
+			statuses = append(statuses, "u.deletion_scheduled_at IS NOT NULL")
+		}
+	}
+	if len(statuses) > 0 {
+		where = append(where, "("+strings.Join(statuses, " OR ")+")")
+	}
+
+	return where, args
+}
+
+// check whether the user was granted a role
+func (u *User) HasRole(role string) bool {
+	for _, r := range u.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// check whether any of the user's roles grants a permission
+func (u *User) HasPermission(permission string) bool {
+	for _, p := range u.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
 
 // create user
-func (m *UserModel) Insert(user *User) error {
+func (m *UserModel) Insert(user *User, roles ...string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	// the user and their roles are saved together, so no account is ever left without its roles
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
 	// Step 1: Check for existing email
 	var exists bool
 	checkQuery := `SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)`
-	if err := m.DB.QueryRowContext(ctx, checkQuery, user.Email).Scan(&exists); err != nil {
+	if err := tx.QueryRowContext(ctx, checkQuery, user.Email).Scan(&exists); err != nil {
 		return fmt.Errorf("email check failed: %w", err)
 	}
 	if exists {
@@ -42,8 +186,11 @@ func (m *UserModel) Insert(user *User) error {
 
 	// Step 2: Insert the user
 	insertQuery := `INSERT INTO users (username, password, email) VALUES (?, ?, ?)`
-	result, err := m.DB.ExecContext(ctx, insertQuery, user.Username, user.Password, user.Email)
+	result, err := tx.ExecContext(ctx, insertQuery, user.Username, user.Password, user.Email)
 	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.username") {
+			return ErrUsernameTaken
+		}
 		return fmt.Errorf("insert failed: %w", err)
 	}
 
@@ -53,24 +200,36 @@ func (m *UserModel) Insert(user *User) error {
 	}
 	user.ID = int(id)
 
-	// Step 3: Select the created user (if you want)
+	// Step 3: Grant the roles
+	for _, role := range roles {
+		if err := grantRole(ctx, tx, user.ID, role); err != nil {
+			return fmt.Errorf("granting %s failed: %w", role, err)
+		}
+	}
+
+	// Step 4: Select the created user (if you want)
 	// Optional: remove this if you already know the fields
 	selectQuery := `SELECT username, email FROM users WHERE id = ?`
-	err = m.DB.QueryRowContext(ctx, selectQuery, user.ID).Scan(&user.Username, &user.Email)
+	err = tx.QueryRowContext(ctx, selectQuery, user.ID).Scan(&user.Username, &user.Email)
 	if err != nil {
 		return fmt.Errorf("failed to fetch created user: %w", err)
 	}
 
-	return nil
+	return tx.Commit()
 }
 
+// columns selected for a User, in the order getUser scans them
+const userColumns = "id, username, email, password, email_verified_at, display_name, bio, avatar_url, timezone, deletion_scheduled_at, suspended_at, suspension_reason"
+
 // get user utility function
 func (m *UserModel) getUser(query string, args ...interface{}) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	var user User
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Email, &user.Username, &user.Password)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.Username, &user.Email, &user.Password, &user.EmailVerifiedAt,
+		&user.DisplayName, &user.Bio, &user.AvatarURL, &user.Timezone, &user.DeletionScheduledAt,
+		&user.SuspendedAt, &user.SuspensionReason)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -84,21 +243,271 @@ func (m *UserModel) getUser(query string, args ...interface{}) (*User, error) {
 
 // get user by ID
 func (m *UserModel) Get(id int) (*User, error) {
-	query := "SELECT * FROM users WHERE id = $1"
+	query := "SELECT " + userColumns + " FROM users WHERE id = $1"
 	return m.getUser(query, id)
 }
 
 // get user by email
 func (m *UserModel) GetByEmail(email string) (*User, error) {
-	query := "SELECT * FROM users WHERE email = $1"
+	query := "SELECT " + userColumns + " FROM users WHERE email = $1"
 	return m.getUser(query, email)
 }
 
+// get user by username
+func (m *UserModel) GetByUsername(username string) (*User, error) {
+	query := "SELECT " + userColumns + " FROM users WHERE username = $1"
+	return m.getUser(query, username)
+}
+
+// replace a user's password hash
+func (m *UserModel) UpdatePassword(id int, passwordHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET password = $1 WHERE id = $2"
+	_, err := m.DB.ExecContext(ctx, query, passwordHash, id)
+	return err
+}
+
+// save the username and profile fields
+func (m *UserModel) UpdateProfile(user *User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `UPDATE users SET username = $1, display_name = $2, bio = $3, avatar_url = $4, timezone = $5 WHERE id = $6`
+	_, err := m.DB.ExecContext(ctx, query, user.Username, user.DisplayName, user.Bio, user.AvatarURL, user.Timezone, user.ID)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: users.username") {
+		return ErrUsernameTaken
+	}
+	return err
+}
+
+// switch to a confirmed new email address, as long as the old one is still on the account
+func (m *UserModel) ChangeEmail(id int, oldEmail, newEmail string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET email = $1, email_verified_at = $2 WHERE id = $3 AND email = $4"
+	result, err := m.DB.ExecContext(ctx, query, newEmail, time.Now().UTC(), id, oldEmail)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed: users.email") {
+			return false, ErrEmailTaken
+		}
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// suspend a user; suspending an already suspended user updates the reason
+func (m *UserModel) Suspend(id int, reason string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET suspended_at = COALESCE(suspended_at, $1), suspension_reason = $2 WHERE id = $3"
+	_, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), reason, id)
+	return err
+}
+
+// lift a suspension; false when the user was not suspended
+func (m *UserModel) Unsuspend(id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET suspended_at = NULL, suspension_reason = '' WHERE id = $1 AND suspended_at IS NOT NULL"
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// schedule the account to be purged at the given time
+func (m *UserModel) ScheduleDeletion(id int, at time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET deletion_scheduled_at = $1 WHERE id = $2"
+	_, err := m.DB.ExecContext(ctx, query, at.UTC(), id)
+	return err
+}
+
+// cancel a scheduled deletion; false when none was scheduled
+func (m *UserModel) CancelDeletion(id int) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET deletion_scheduled_at = NULL WHERE id = $1 AND deletion_scheduled_at IS NOT NULL"
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
+// ids of the accounts whose deletion grace period is over
+func (m *UserModel) DueForDeletion(now time.Time) ([]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := "SELECT id FROM users WHERE deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= $1"
+	rows, err := m.DB.QueryContext(ctx, query, now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, rows.Err()
+}
+
+// Delete removes a user and everything that references them. The schema
+// declares owner_id and the other user references ON DELETE CASCADE, so the
+// user's events go with them (along with everyone's registrations for those
+// events). The connection does not enable SQLite foreign keys, so the cascade
+// is spelled out here. Audit entries are kept but lose the IP address.
+func (m *UserModel) Delete(id int) error {
+	_, err := m.delete(id, "DELETE FROM users WHERE id = $1", id)
+	return err
+}
+
+// PurgeDue deletes a user the way Delete does, but only if their deletion
+// grace period is still over by now. It reports false, deleting nothing, when
+// the account was restored after it was found due.
+func (m *UserModel) PurgeDue(id int, now time.Time) (bool, error) {
+	return m.delete(id, "DELETE FROM users WHERE id = $1 AND deletion_scheduled_at IS NOT NULL AND deletion_scheduled_at <= $2", id, now.UTC())
+}
+
+// delete runs the user's cascade in the same transaction as userQuery, which
+// removes the user row; nothing else is deleted unless it removed one
+func (m *UserModel) delete(id int, userQuery string, args ...any) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, userQuery, args...)
+	if err != nil {
+		return false, fmt.Errorf("delete user %d: %w", id, err)
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if affected == 0 {
+		return false, nil
+	}
+
+	for _, statement := range []string{
+		"DELETE FROM attendees WHERE event_id IN (SELECT id FROM events WHERE owner_id = $1)",
+		"DELETE FROM event_occurrence_overrides WHERE event_id IN (SELECT id FROM events WHERE owner_id = $1)",
+		"DELETE FROM events WHERE owner_id = $1",
+	} {
+		if _, err := tx.ExecContext(ctx, statement, id); err != nil {
+			return false, fmt.Errorf("delete user %d: %w", id, err)
+		}
+	}
+
+	// seats the user held in other people's events go to their waitlists
+	rows, err := tx.QueryContext(ctx, "SELECT event_id, occurrence FROM attendees WHERE user_id = $1 AND registration_status = $2 AND status <> $3",
+		id, RegistrationConfirmed, StatusDeclined)
+	if err != nil {
+		return false, err
+	}
+	seats := []*Attendee{}
+	for rows.Next() {
+		var seat Attendee
+		if err := rows.Scan(&seat.EventId, &seat.Occurrence); err != nil {
+			rows.Close()
+			return false, err
+		}
+		seats = append(seats, &seat)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM attendees WHERE user_id = $1", id); err != nil {
+		return false, fmt.Errorf("delete user %d: %w", id, err)
+	}
+	for _, seat := range seats {
+		if _, err := promoteWaitlisted(ctx, tx, seat.EventId, seat.Occurrence); err != nil {
+			return false, err
+		}
+	}
+
+	statements := []string{
+		"DELETE FROM user_roles WHERE user_id = $1",
+		"DELETE FROM user_mfa WHERE user_id = $1",
+		"DELETE FROM mfa_recovery_codes WHERE user_id = $1",
+		"DELETE FROM api_keys WHERE user_id = $1",
+		"DELETE FROM user_identities WHERE user_id = $1",
+		"DELETE FROM calendar_feeds WHERE user_id = $1",
+		"UPDATE audit_log SET ip = '' WHERE actor_id = $1 OR target_user_id = $1",
+	}
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement, id); err != nil {
+			return false, fmt.Errorf("delete user %d: %w", id, err)
+		}
+	}
+
+	return true, tx.Commit()
+}
+
+// mark the email verified, as long as it is still the address on the account
+func (m *UserModel) MarkEmailVerified(id int, email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := "UPDATE users SET email_verified_at = $1 WHERE id = $2 AND email = $3"
+	result, err := m.DB.ExecContext(ctx, query, time.Now().UTC(), id, email)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return affected > 0, nil
+}
+
 // get all users
 func (m *UserModel) GetAllUser()([]*SafeUser, error){
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
-	query := "SELECT * FROM users"
+	query := "SELECT id, username, email, password FROM users"
 
 	rows, err := m.DB.QueryContext(ctx, query)
 	if err != nil {
@@ -126,4 +535,64 @@ func (m *UserModel) GetAllUser()([]*SafeUser, error){
 	}
 
 	return users, nil
-}
\ No newline at end of file
+}
+
+// list one page of users matching the query filters
+func (m *UserModel) List(q ListQuery) ([]*SafeUser, *PageInfo, error) {
+	where, args := userFilters(q)
+
+	users := []*SafeUser{}
+	page, err := paginate(m.DB, UserListSpec, q, pageQuery{
+		columns:  "u.id, u.username, u.email",
+		from:     "users u",
+		where:    where,
+		args:     args,
+		idColumn: "u.id",
+	}, func(rows *sql.Rows, key *sql.NullString) (int, error) {
+		var user SafeUser
+		if err := rows.Scan(key, &user.ID, &user.Username, &user.Email); err != nil {
+			return 0, err
+		}
+		users = append(users, &user)
+		return user.ID, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return users, page, nil
+}
+
+// list one page of users with their account state, for admins
+func (m *UserModel) ListForAdmin(q ListQuery) ([]*AdminUser, *PageInfo, error) {
+	where, args := adminUserFilters(q)
+
+	users := []*AdminUser{}
+	page, err := paginate(m.DB, AdminUserListSpec, q, pageQuery{
+		columns: `u.id, u.username, u.email, u.display_name, u.email_verified_at, u.suspended_at, u.suspension_reason, u.deletion_scheduled_at,
+			(SELECT GROUP_CONCAT(r.name) FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE ur.user_id = u.id)`,
+		from:     "users u",
+		where:    where,
+		args:     args,
+		idColumn: "u.id",
+	}, func(rows *sql.Rows, key *sql.NullString) (int, error) {
+		var user AdminUser
+		var roles sql.NullString
+		err := rows.Scan(key, &user.ID, &user.Username, &user.Email, &user.DisplayName, &user.EmailVerifiedAt,
+			&user.SuspendedAt, &user.SuspensionReason, &user.DeletionScheduledAt, &roles)
+		if err != nil {
+			return 0, err
+		}
+		user.Roles = []string{}
+		if roles.String != "" {
+			user.Roles = strings.Split(roles.String, ",")
+		}
+		users = append(users, &user)
+		return user.ID, nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return users, page, nil
+}
@@ -0,0 +1,82 @@
+package database_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/muhamash/go-first-rest-api/internal/database"
+	"github.com/muhamash/go-first-rest-api/internal/database/dbtest"
+)
+
+func TestPurgeDueSkipsRestoredAccounts(t *testing.T) {
+	users := database.NewModels(dbtest.New(t)).Users
+	now := time.Now()
+
+	tests := []struct {
+		name        string
+		scheduledAt *time.Time
+		restore     bool
+		wantDeleted bool
+	}{
+		{name: "due", scheduledAt: ptr(now.Add(-time.Hour)), wantDeleted: true},
+		{name: "restored after being found due", scheduledAt: ptr(now.Add(-time.Hour)), restore: true},
+		{name: "rescheduled for later", scheduledAt: ptr(now.Add(time.Hour))},
+		{name: "never scheduled"},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &database.User{Username: tt.name, Email: string(rune('a'+i)) + "@example.com", Password: "x"}
+			if err := users.Insert(user); err != nil {
+				t.Fatal(err)
+			}
+			if tt.scheduledAt != nil {
+				if err := users.ScheduleDeletion(user.ID, *tt.scheduledAt); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.restore {
+				if _, err := users.CancelDeletion(user.ID); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deleted, err := users.PurgeDue(user.ID, now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if deleted != tt.wantDeleted {
+				t.Errorf("deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+
+			remaining, err := users.Get(user.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if (remaining == nil) != tt.wantDeleted {
+				t.Errorf("user still exists = %v, want %v", remaining != nil, !tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
+func TestInsertWithUnknownRoleSavesNothing(t *testing.T) {
+	users := database.NewModels(dbtest.New(t)).Users
+
+	user := &database.User{Username: "half", Email: "half@example.com", Password: "x"}
+	if err := users.Insert(user, database.RoleMember, "no-such-role"); err == nil {
+		t.Fatal("inserted a user with a role that does not exist")
+	}
+
+	saved, err := users.GetByEmail("half@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != nil {
+		t.Errorf("user %d was saved without their roles", saved.ID)
+	}
+}
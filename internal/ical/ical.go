@@ -0,0 +1,268 @@
+// Package ical reads and writes RFC 5545 iCalendar documents: a VCALENDAR
+// holding the VTIMEZONE definitions its events need and the VEVENTs themselves.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	dateTimeFormat = "20060102T150405"
+	// lines longer than this many octets are folded
+	maxLineOctets = 75
+	// recurring events are given time zone rules this many years past their start
+	recurringZoneYears = 10
+)
+
+// Calendar is a VCALENDAR
+type Calendar struct {
+	ProdID string
+	// Name is shown by clients that support X-WR-CALNAME
+	Name   string
+	Events []*Event
+}
+
+// Event is a VEVENT. Start's location decides the TZID it is written in.
+type Event struct {
+	UID         string
+	Start       time.Time
+	End         *time.Time
+	Summary     string
+	Description string
+	Location    string
+	URL         string
+	// RRule is an RRULE value without the "RRULE:" prefix
+	RRule   string
+	ExDates []time.Time
+	// RecurrenceID marks this VEVENT as a changed occurrence of the series with the same UID
+	RecurrenceID *time.Time
+	Status       string
+
+	// Line is where the VEVENT began, and Errors what could not be read; both set by Parse
+	Line   int
+	Errors []string
+
+	// read by Parse to work out End when there is no DTEND
+	duration time.Duration
+	allDay   bool
+}
+
+// Encode writes the calendar. stamp becomes every event's DTSTAMP.
+func (c *Calendar) Encode(w io.Writer, stamp time.Time) error {
+	out := &writer{w: bufio.NewWriter(w)}
+
+	out.line("BEGIN:VCALENDAR")
+	out.line("VERSION:2.0")
+	out.line("PRODID:" + c.ProdID)
+	out.line("CALSCALE:GREGORIAN")
+	out.line("METHOD:PUBLISH")
+	if c.Name != "" {
+		out.line("X-WR-CALNAME:" + escape(c.Name))
+	}
+
+	for _, zone := range c.zones() {
+		zone.write(out)
+	}
+
+	for _, event := range c.Events {
+		event.write(out, stamp)
+	}
+
+	out.line("END:VCALENDAR")
+	if out.err != nil {
+		return out.err
+	}
+	return out.w.Flush()
+}
+
+func (e *Event) write(out *writer, stamp time.Time) {
+	out.line("BEGIN:VEVENT")
+	out.line("UID:" + escape(e.UID))
+	out.line("DTSTAMP:" + stamp.UTC().Format(dateTimeFormat) + "Z")
+	if e.RecurrenceID != nil {
+		out.line("RECURRENCE-ID" + dateTime(*e.RecurrenceID, e.Start.Location()))
+	}
+	out.line("DTSTART" + dateTime(e.Start, e.Start.Location()))
+	if e.End != nil {
+		out.line("DTEND" + dateTime(*e.End, e.Start.Location()))
+	}
+	if e.RRule != "" {
+		out.line("RRULE:" + e.RRule)
+	}
+	if len(e.ExDates) > 0 {
+		values := make([]string, len(e.ExDates))
+		for i, t := range e.ExDates {
+			values[i] = t.In(zoneOf(e.Start.Location())).Format(dateTimeFormat)
+		}
+		out.line("EXDATE;TZID=" + tzid(e.Start.Location()) + ":" + strings.Join(values, ","))
+	}
+	out.line("SUMMARY:" + escape(e.Summary))
+	if e.Description != "" {
+		out.line("DESCRIPTION:" + escape(e.Description))
+	}
+	if e.Location != "" {
+		out.line("LOCATION:" + escape(e.Location))
+	}
+	if e.URL != "" {
+		out.line("URL:" + e.URL)
+	}
+	if e.Status != "" {
+		out.line("STATUS:" + e.Status)
+	}
+	out.line("END:VEVENT")
+}
+
+// dateTime formats a DTSTART-style property parameter and value in loc
+func dateTime(t time.Time, loc *time.Location) string {
+	return ";TZID=" + tzid(loc) + ":" + t.In(zoneOf(loc)).Format(dateTimeFormat)
+}
+
+// zoneOf maps the process-local zone, which has no portable name, to UTC
+func zoneOf(loc *time.Location) *time.Location {
+	if loc == nil || loc.String() == "" || loc.String() == "Local" {
+		return time.UTC
+	}
+	return loc
+}
+
+func tzid(loc *time.Location) string {
+	return zoneOf(loc).String()
+}
+
+// escape quotes a TEXT value
+func escape(value string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(value)
+}
+
+// zones collects the time zones the events are written in, with the range of
+// years each needs rules for
+func (c *Calendar) zones() []*zone {
+	byName := map[string]*zone{}
+	for _, event := range c.Events {
+		loc := zoneOf(event.Start.Location())
+		first, last := event.Start.Year(), event.Start.Year()
+		if event.RRule != "" {
+			last += recurringZoneYears
+		}
+		if event.End != nil && event.End.Year() > last {
+			last = event.End.Year()
+		}
+
+		z, ok := byName[loc.String()]
+		if !ok {
+			byName[loc.String()] = &zone{loc: loc, first: first, last: last}
+			continue
+		}
+		if first < z.first {
+			z.first = first
+		}
+		if last > z.last {
+			z.last = last
+		}
+	}
+
+	zones := make([]*zone, 0, len(byName))
+	for _, z := range byName {
+		zones = append(zones, z)
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].loc.String() < zones[j].loc.String() })
+	return zones
+}
+
+type zone struct {
+	loc         *time.Location
+	first, last int
+}
+
+// write emits a VTIMEZONE with one observance per offset change between the
+// start of the first year and the end of the last, read from Go's zone data
+func (z *zone) write(out *writer) {
+	out.line("BEGIN:VTIMEZONE")
+	out.line("TZID:" + z.loc.String())
+
+	start := time.Date(z.first, time.January, 1, 0, 0, 0, 0, z.loc)
+	end := time.Date(z.last+1, time.January, 1, 0, 0, 0, 0, z.loc)
+
+	// the observance in effect when the range begins
+	name, offset := start.Zone()
+	observance(out, start.IsDST(), time.Date(1970, time.January, 1, 0, 0, 0, 0, time.UTC), offset, offset, name)
+
+	t := start
+	for {
+		_, next := t.ZoneBounds()
+		if next.IsZero() || !next.Before(end) {
+			break
+		}
+		_, before := next.Add(-time.Second).Zone()
+		name, after := next.Zone()
+		// DTSTART is the local time the change happens at, before it happens
+		onset := next.In(time.FixedZone("", before))
+		observance(out, next.IsDST(), time.Date(onset.Year(), onset.Month(), onset.Day(), onset.Hour(), onset.Minute(), onset.Second(), 0, time.UTC), before, after, name)
+		t = next
+	}
+
+	out.line("END:VTIMEZONE")
+}
+
+func observance(out *writer, daylight bool, onset time.Time, from, to int, name string) {
+	kind := "STANDARD"
+	if daylight {
+		kind = "DAYLIGHT"
+	}
+	out.line("BEGIN:" + kind)
+	out.line("DTSTART:" + onset.Format(dateTimeFormat))
+	out.line("TZOFFSETFROM:" + utcOffset(from))
+	out.line("TZOFFSETTO:" + utcOffset(to))
+	if name != "" {
+		out.line("TZNAME:" + escape(name))
+	}
+	out.line("END:" + kind)
+}
+
+// utcOffset formats seconds east of UTC as +HHMM, with seconds when needed
+func utcOffset(seconds int) string {
+	sign := "+"
+	if seconds < 0 {
+		sign = "-"
+		seconds = -seconds
+	}
+	value := fmt.Sprintf("%s%02d%02d", sign, seconds/3600, seconds%3600/60)
+	if seconds%60 != 0 {
+		value += fmt.Sprintf("%02d", seconds%60)
+	}
+	return value
+}
+
+// writer emits CRLF-terminated content lines, folding long ones
+type writer struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (w *writer) line(content string) {
+	if w.err != nil {
+		return
+	}
+
+	limit := maxLineOctets
+	for len(content) > limit {
+		// never split a UTF-8 sequence across lines
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(content[cut]) {
+			cut--
+		}
+		if _, w.err = w.w.WriteString(content[:cut] + "\r\n "); w.err != nil {
+			return
+		}
+		content = content[cut:]
+		// continuation lines start with a space, which counts against the limit
+		limit = maxLineOctets - 1
+	}
+	_, w.err = w.w.WriteString(content + "\r\n")
+}
@@ -0,0 +1,207 @@
+package ical
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestLineFolding(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"short", "SUMMARY:Standup"},
+		{"exactly the limit", "SUMMARY:" + strings.Repeat("a", maxLineOctets-len("SUMMARY:"))},
+		{"ascii", "DESCRIPTION:" + strings.Repeat("abcdefghij", 30)},
+		// two and four octet runes land across every possible cut
+		{"two octet runes", "SUMMARY:" + strings.Repeat("é", 100)},
+		{"four octet runes", "SUMMARY:x" + strings.Repeat("🎉", 60)},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		out := &writer{w: bufio.NewWriter(&buf)}
+		out.line(tt.content)
+		if err := out.w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		folded := strings.TrimSuffix(buf.String(), "\r\n")
+		lines := strings.Split(folded, "\r\n")
+		for i, line := range lines {
+			if len(line) > maxLineOctets {
+				t.Errorf("%s: line %d is %d octets", tt.name, i, len(line))
+			}
+			if i > 0 && !strings.HasPrefix(line, " ") {
+				t.Errorf("%s: continuation line %d does not start with a space", tt.name, i)
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("%s: line %d splits a UTF-8 sequence: %q", tt.name, i, line)
+			}
+		}
+		if unfolded := strings.ReplaceAll(folded, "\r\n ", ""); unfolded != tt.content {
+			t.Errorf("%s: unfolds to %q", tt.name, unfolded)
+		}
+		if len(tt.content) <= maxLineOctets && len(lines) != 1 {
+			t.Errorf("%s: folded a line that fits", tt.name)
+		}
+	}
+}
+
+func TestEscape(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"plain", "plain"},
+		{`back\slash`, `back\\slash`},
+		{"semi;colon, comma", `semi\;colon\, comma`},
+		{"two\nlines", `two\nlines`},
+		{"windows\r\nlines", `windows\nlines`},
+		{"old mac\rlines", `old mac\nlines`},
+		// escaping the backslash first keeps the others from being doubled
+		{`\n`, `\\n`},
+	}
+
+	for _, tt := range tests {
+		if got := escape(tt.in); got != tt.want {
+			t.Errorf("escape(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestUTCOffset(t *testing.T) {
+	tests := []struct {
+		seconds int
+		want    string
+	}{
+		{0, "+0000"},
+		{3600, "+0100"},
+		{-4 * 3600, "-0400"},
+		{5*3600 + 45*60, "+0545"},
+		{-(3*3600 + 30*60), "-0330"},
+		// local mean times such as Amsterdam's before 1937
+		{19*60 + 32, "+001932"},
+	}
+
+	for _, tt := range tests {
+		if got := utcOffset(tt.seconds); got != tt.want {
+			t.Errorf("utcOffset(%d) = %s, want %s", tt.seconds, got, tt.want)
+		}
+	}
+}
+
+// blocks returns the lines from BEGIN:name up to and including END:name, for
+// every such block in doc
+func blocks(doc, name string) [][]string {
+	var found [][]string
+	var current []string
+	for _, line := range strings.Split(doc, "\r\n") {
+		if line == "BEGIN:"+name {
+			current = []string{}
+		}
+		if current != nil {
+			current = append(current, line)
+		}
+		if line == "END:"+name && current != nil {
+			found = append(found, current)
+			current = nil
+		}
+	}
+	return found
+}
+
+func TestTimezoneObservances(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, berlin)
+	cal := &Calendar{ProdID: "-//test//EN", Events: []*Event{{UID: "a", Start: start, Summary: "Standup"}}}
+
+	var buf bytes.Buffer
+	if err := cal.Encode(&buf, time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)); err != nil {
+		t.Fatal(err)
+	}
+	doc := buf.String()
+
+	zones := blocks(doc, "VTIMEZONE")
+	if len(zones) != 1 || zones[0][1] != "TZID:Europe/Berlin" {
+		t.Fatalf("VTIMEZONE blocks = %q", zones)
+	}
+	zoneDoc := strings.Join(zones[0], "\r\n")
+
+	want := [][]string{
+		// in effect when the year begins
+		{"BEGIN:STANDARD", "DTSTART:19700101T000000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0100", "TZNAME:CET", "END:STANDARD"},
+		// clocks go forward at 02:00 local winter time on the last Sunday of March
+		{"BEGIN:DAYLIGHT", "DTSTART:20260329T020000", "TZOFFSETFROM:+0100", "TZOFFSETTO:+0200", "TZNAME:CEST", "END:DAYLIGHT"},
+		// and back at 03:00 local summer time on the last Sunday of October
+		{"BEGIN:STANDARD", "DTSTART:20261025T030000", "TZOFFSETFROM:+0200", "TZOFFSETTO:+0100", "TZNAME:CET", "END:STANDARD"},
+	}
+	got := append(blocks(zoneDoc, "STANDARD"), blocks(zoneDoc, "DAYLIGHT")...)
+	if len(got) != len(want) {
+		t.Fatalf("observances = %q", got)
+	}
+	for _, observance := range want {
+		if !strings.Contains(zoneDoc, strings.Join(observance, "\r\n")) {
+			t.Errorf("missing observance %q in\n%s", observance, zoneDoc)
+		}
+	}
+
+	if !strings.Contains(doc, "DTSTART;TZID=Europe/Berlin:20260601T090000\r\n") {
+		t.Errorf("event start not written in its zone:\n%s", doc)
+	}
+
+	// a recurring event needs the rules for years to come
+	cal.Events[0].RRule = "FREQ=WEEKLY"
+	buf.Reset()
+	if err := cal.Encode(&buf, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Count(buf.String(), "BEGIN:DAYLIGHT"), recurringZoneYears+1; got != want {
+		t.Errorf("recurring event: %d DAYLIGHT observances, want %d", got, want)
+	}
+}
+
+func TestEncodeStampOnlyChangesDTSTAMP(t *testing.T) {
+	start := time.Date(2026, 6, 1, 9, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	cal := &Calendar{ProdID: "-//test//EN", Name: "Team, events", Events: []*Event{
+		{UID: "a", Start: start, End: &end, Summary: "Standup; daily", Description: "Line one\nline two", RRule: "FREQ=DAILY;COUNT=3"},
+	}}
+
+	encode := func(stamp time.Time) string {
+		var buf bytes.Buffer
+		if err := cal.Encode(&buf, stamp); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	first, second := encode(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)), encode(time.Date(2026, 2, 1, 12, 0, 0, 0, time.UTC))
+	if first == second {
+		t.Fatal("DTSTAMP did not change")
+	}
+	firstLines, secondLines := strings.Split(first, "\r\n"), strings.Split(second, "\r\n")
+	if len(firstLines) != len(secondLines) {
+		t.Fatalf("%d lines, then %d", len(firstLines), len(secondLines))
+	}
+	for i := range firstLines {
+		if firstLines[i] != secondLines[i] && !strings.HasPrefix(firstLines[i], "DTSTAMP:") {
+			t.Errorf("line %d changed with the stamp: %q, then %q", i, firstLines[i], secondLines[i])
+		}
+	}
+
+	for _, line := range []string{"X-WR-CALNAME:Team\\, events", "SUMMARY:Standup\\; daily", "DESCRIPTION:Line one\\nline two", "DTSTAMP:20260101T000000Z"} {
+		if !strings.Contains(first, line+"\r\n") {
+			t.Errorf("missing %q in\n%s", line, first)
+		}
+	}
+	if encode(time.Time{}) != encode(time.Time{}) {
+		t.Error("encoding the same calendar twice differs")
+	}
+}
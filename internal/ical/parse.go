@@ -0,0 +1,303 @@
+package ical
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrNotCalendar = errors.New("not an iCalendar document")
+
+// Parse reads the VEVENTs of an iCalendar document, setting each one's Line.
+// A property that cannot be read is recorded in the event's Errors, so one bad
+// event does not hide the others.
+func Parse(r io.Reader) ([]*Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []*Event{}
+	var current *Event
+	sawCalendar := false
+	// components nested in a VEVENT, such as VALARM, are skipped
+	nested := 0
+
+	for _, l := range lines {
+		name, params, value := splitProperty(l.text)
+
+		switch {
+		case name == "BEGIN" && strings.EqualFold(value, "VCALENDAR"):
+			sawCalendar = true
+			continue
+		case name == "BEGIN" && strings.EqualFold(value, "VEVENT") && current == nil:
+			current = &Event{Line: l.number}
+			continue
+		case current == nil:
+			continue
+		case name == "BEGIN":
+			nested++
+			continue
+		case name == "END" && nested > 0:
+			nested--
+			continue
+		case nested > 0:
+			continue
+		case name == "END" && strings.EqualFold(value, "VEVENT"):
+			current.finish()
+			events = append(events, current)
+			current = nil
+			continue
+		}
+
+		current.setProperty(name, params, value)
+	}
+
+	if !sawCalendar {
+		return nil, ErrNotCalendar
+	}
+	if current != nil {
+		return nil, fmt.Errorf("VEVENT starting on line %d is never closed", current.Line)
+	}
+
+	return events, nil
+}
+
+func (e *Event) setProperty(name string, params map[string]string, value string) {
+	fail := func(err error) {
+		e.Errors = append(e.Errors, fmt.Sprintf("%s: %v", name, err))
+	}
+
+	switch name {
+	case "UID":
+		e.UID = unescape(value)
+	case "SUMMARY":
+		e.Summary = unescape(value)
+	case "DESCRIPTION":
+		e.Description = unescape(value)
+	case "LOCATION":
+		e.Location = unescape(value)
+	case "URL":
+		e.URL = value
+	case "STATUS":
+		e.Status = strings.ToUpper(value)
+	case "RRULE":
+		e.RRule = value
+	case "DURATION":
+		d, err := parseDuration(value)
+		if err != nil {
+			fail(err)
+			return
+		}
+		e.duration = d
+	case "DTSTART", "DTEND", "RECURRENCE-ID":
+		t, err := parseDateTime(value, params)
+		if err != nil {
+			fail(err)
+			return
+		}
+		switch name {
+		case "DTSTART":
+			e.Start = t
+			e.allDay = params["VALUE"] == "DATE" || len(value) == len("20060102")
+		case "DTEND":
+			e.End = &t
+		default:
+			e.RecurrenceID = &t
+		}
+	case "EXDATE":
+		for _, part := range strings.Split(value, ",") {
+			t, err := parseDateTime(part, params)
+			if err != nil {
+				fail(err)
+				return
+			}
+			e.ExDates = append(e.ExDates, t)
+		}
+	}
+}
+
+// finish works out the end of an event given by DURATION, or by nothing at
+// all, which RFC 5545 reads as one day for a date and no time for a date-time
+func (e *Event) finish() {
+	if e.End != nil || e.Start.IsZero() {
+		return
+	}
+	end := e.Start.Add(e.duration)
+	if e.duration == 0 && e.allDay {
+		end = e.Start.AddDate(0, 0, 1)
+	}
+	e.End = &end
+}
+
+// parseDuration reads a DURATION value such as PT1H30M, P1D or -P1W
+func parseDuration(value string) (time.Duration, error) {
+	rest := value
+	sign := time.Duration(1)
+	if strings.HasPrefix(rest, "-") {
+		sign = -1
+	}
+	rest = strings.TrimLeft(rest, "+-")
+	if !strings.HasPrefix(rest, "P") || len(rest) < 3 {
+		return 0, fmt.Errorf("%q is not a duration", value)
+	}
+
+	units := map[byte]time.Duration{'W': 7 * 24 * time.Hour, 'D': 24 * time.Hour, 'H': time.Hour, 'M': time.Minute, 'S': time.Second}
+	var total time.Duration
+	number := -1
+	inTime := false
+	for i := 1; i < len(rest); i++ {
+		c := rest[i]
+		switch {
+		case c >= '0' && c <= '9':
+			if number < 0 {
+				number = 0
+			}
+			number = number*10 + int(c-'0')
+		case c == 'T' && !inTime && number < 0:
+			inTime = true
+		case number >= 0 && units[c] != 0 && (inTime == (c == 'H' || c == 'M' || c == 'S')):
+			total += time.Duration(number) * units[c]
+			number = -1
+		default:
+			return 0, fmt.Errorf("%q is not a duration", value)
+		}
+	}
+	if number >= 0 {
+		return 0, fmt.Errorf("%q is not a duration", value)
+	}
+
+	return sign * total, nil
+}
+
+// parseDateTime reads a DATE or DATE-TIME value. UTC values end in Z, TZID
+// names an IANA zone and anything else is floating and read as UTC.
+func parseDateTime(value string, params map[string]string) (time.Time, error) {
+	loc := time.UTC
+	if name := params["TZID"]; name != "" {
+		zone, err := time.LoadLocation(strings.TrimPrefix(name, "/"))
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %q", name)
+		}
+		loc = zone
+	}
+
+	if params["VALUE"] == "DATE" || len(value) == len("20060102") {
+		t, err := time.ParseInLocation("20060102", value, loc)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a date", value)
+		}
+		return t, nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(dateTimeFormat+"Z", value)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("%q is not a date-time", value)
+		}
+		return t, nil
+	}
+	t, err := time.ParseInLocation(dateTimeFormat, value, loc)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is not a date-time", value)
+	}
+	return t, nil
+}
+
+// unescape reverses the TEXT escaping escape applies
+func unescape(value string) string {
+	var out strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '\\' || i == len(value)-1 {
+			out.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'n', 'N':
+			out.WriteByte('\n')
+		default:
+			out.WriteByte(value[i])
+		}
+	}
+	return out.String()
+}
+
+type contentLine struct {
+	number int
+	text   string
+}
+
+// unfold joins folded lines back together, remembering where each began
+func unfold(r io.Reader) ([]contentLine, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	lines := []contentLine{}
+	number := 0
+	for scanner.Scan() {
+		number++
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if number == 1 {
+			text = strings.TrimPrefix(text, "\ufeff")
+		}
+		if (strings.HasPrefix(text, " ") || strings.HasPrefix(text, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1].text += text[1:]
+			continue
+		}
+		if text != "" {
+			lines = append(lines, contentLine{number: number, text: text})
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+// splitProperty splits "NAME;PARAM=VALUE:value", honouring quoted parameter values
+func splitProperty(line string) (string, map[string]string, string) {
+	inQuotes := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		}
+		if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return strings.ToUpper(line), map[string]string{}, ""
+	}
+
+	head, value := line[:colon], line[colon+1:]
+	params := map[string]string{}
+	parts := splitUnquoted(head, ';')
+	for _, part := range parts[1:] {
+		key, val, _ := strings.Cut(part, "=")
+		params[strings.ToUpper(key)] = strings.Trim(val, `"`)
+	}
+	if v, ok := params["VALUE"]; ok {
+		params["VALUE"] = strings.ToUpper(v)
+	}
+
+	return strings.ToUpper(parts[0]), params, value
+}
+
+func splitUnquoted(value string, sep rune) []string {
+	parts := []string{}
+	inQuotes := false
+	start := 0
+	for i, r := range value {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+		case r == sep && !inQuotes:
+			parts = append(parts, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, value[start:])
+}
@@ -0,0 +1,137 @@
+package ical
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func calendar(lines ...string) string {
+	return "BEGIN:VCALENDAR\r\nVERSION:2.0\r\n" + strings.Join(lines, "\r\n") + "\r\nEND:VCALENDAR\r\n"
+}
+
+func TestParse(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(loc *time.Location, hour, minute int) time.Time {
+		return time.Date(2026, 5, 1, hour, minute, 0, 0, loc)
+	}
+
+	tests := []struct {
+		name string
+		doc  string
+		want Event
+	}{
+		{
+			name: "utc with dtend",
+			doc:  calendar("BEGIN:VEVENT", "UID:a", "SUMMARY:Standup", "DTSTART:20260501T090000Z", "DTEND:20260501T091500Z", "END:VEVENT"),
+			want: Event{UID: "a", Summary: "Standup", Start: at(time.UTC, 9, 0), End: ptr(at(time.UTC, 9, 15))},
+		},
+		{
+			name: "tzid with duration",
+			doc:  calendar("BEGIN:VEVENT", "UID:b", "DTSTART;TZID=Europe/Berlin:20260501T180000", "DURATION:PT1H30M", "END:VEVENT"),
+			want: Event{UID: "b", Start: at(berlin, 18, 0), End: ptr(at(berlin, 19, 30))},
+		},
+		{
+			name: "all-day date lasts a day",
+			doc:  calendar("BEGIN:VEVENT", "UID:c", "DTSTART;VALUE=DATE:20260501", "END:VEVENT"),
+			want: Event{UID: "c", Start: at(time.UTC, 0, 0), End: ptr(time.Date(2026, 5, 2, 0, 0, 0, 0, time.UTC))},
+		},
+		{
+			name: "folded and escaped text",
+			doc:  calendar("BEGIN:VEVENT", "UID:d", "DESCRIPTION:Bring snacks\\, drinks\\;", "  and\\nchairs", "LOCATION:Room \\\\ 4", "DTSTART:20260501T090000Z", "END:VEVENT"),
+			want: Event{UID: "d", Description: "Bring snacks, drinks; and\nchairs", Location: `Room \ 4`, Start: at(time.UTC, 9, 0), End: ptr(at(time.UTC, 9, 0))},
+		},
+		{
+			name: "rrule, exdates and a nested alarm",
+			doc: calendar("BEGIN:VEVENT", "UID:e", "DTSTART:20260501T090000Z", "DTEND:20260501T100000Z", "RRULE:FREQ=DAILY;COUNT=3",
+				"EXDATE:20260502T090000Z,20260503T090000Z", "BEGIN:VALARM", "SUMMARY:not the event", "END:VALARM", "END:VEVENT"),
+			want: Event{UID: "e", Start: at(time.UTC, 9, 0), End: ptr(at(time.UTC, 10, 0)), RRule: "FREQ=DAILY;COUNT=3",
+				ExDates: []time.Time{time.Date(2026, 5, 2, 9, 0, 0, 0, time.UTC), time.Date(2026, 5, 3, 9, 0, 0, 0, time.UTC)}},
+		},
+		{
+			name: "changed occurrence",
+			doc:  calendar("BEGIN:VEVENT", "UID:f", "RECURRENCE-ID:20260501T090000Z", "DTSTART:20260501T093000Z", "DTEND:20260501T103000Z", "STATUS:confirmed", "END:VEVENT"),
+			want: Event{UID: "f", RecurrenceID: ptr(at(time.UTC, 9, 0)), Start: at(time.UTC, 9, 30), End: ptr(at(time.UTC, 10, 30)), Status: "CONFIRMED"},
+		},
+		{
+			name: "unreadable properties are recorded",
+			doc:  calendar("BEGIN:VEVENT", "UID:g", "DTSTART;TZID=Mars/Olympus:20260501T090000", "DURATION:soon", "END:VEVENT"),
+			want: Event{UID: "g", Errors: []string{`DTSTART: unknown time zone "Mars/Olympus"`, `DURATION: "soon" is not a duration`}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := Parse(strings.NewReader(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(events) != 1 {
+				t.Fatalf("got %d events, want 1", len(events))
+			}
+			got := events[0]
+
+			if got.UID != tt.want.UID || got.Summary != tt.want.Summary || got.Description != tt.want.Description ||
+				got.Location != tt.want.Location || got.RRule != tt.want.RRule || got.Status != tt.want.Status {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if !got.Start.Equal(tt.want.Start) || got.Start.Location().String() != tt.want.Start.Location().String() {
+				t.Errorf("start = %v, want %v", got.Start, tt.want.Start)
+			}
+			if !equalTime(got.End, tt.want.End) {
+				t.Errorf("end = %v, want %v", got.End, tt.want.End)
+			}
+			if !equalTime(got.RecurrenceID, tt.want.RecurrenceID) {
+				t.Errorf("recurrence id = %v, want %v", got.RecurrenceID, tt.want.RecurrenceID)
+			}
+			if !slices.EqualFunc(got.ExDates, tt.want.ExDates, time.Time.Equal) {
+				t.Errorf("exdates = %v, want %v", got.ExDates, tt.want.ExDates)
+			}
+			if !slices.Equal(got.Errors, tt.want.Errors) {
+				t.Errorf("errors = %q, want %q", got.Errors, tt.want.Errors)
+			}
+			if got.Line != 3 {
+				t.Errorf("line = %d, want 3", got.Line)
+			}
+		})
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []struct {
+		name string
+		doc  string
+		want string
+	}{
+		{"not a calendar", "name,starts_at\nParty,2026-05-01 18:00\n", ErrNotCalendar.Error()},
+		{"unclosed event", "BEGIN:VCALENDAR\nBEGIN:VEVENT\nUID:a\nEND:VCALENDAR\n", "VEVENT starting on line 2 is never closed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse(strings.NewReader(tt.doc))
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("got %v, want %q", err, tt.want)
+			}
+			if tt.want == ErrNotCalendar.Error() && !errors.Is(err, ErrNotCalendar) {
+				t.Errorf("got %v, want ErrNotCalendar", err)
+			}
+		})
+	}
+}
+
+func ptr(t time.Time) *time.Time {
+	return &t
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
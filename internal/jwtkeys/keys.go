@@ -0,0 +1,269 @@
+package jwtkeys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrUnknownKey         = errors.New("token was signed with an unknown key")
+	ErrUnexpectedMethod   = errors.New("token signing method does not match its key")
+	ErrUnsupportedKeyType = errors.New("unsupported key type; use RSA (2048 bits or more) or Ed25519")
+)
+
+// Key is one signing or verification key, identified by its kid
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	// private is only set for the signing key; public is the key tokens are verified with
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+}
+
+// Manager signs tokens with one key and verifies them against every key still
+// in rotation, so tokens signed with a retired key stay valid until they expire.
+type Manager struct {
+	signing *Key
+	keys    map[string]*Key
+	// retired keys in the order they were configured, for a stable JWKS document
+	retired []*Key
+}
+
+// JWK is the public half of a key as published in the JWKS document
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// JWKSet is the body of /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Load reads the PEM private key tokens are signed with, plus the public (or
+// private) keys of earlier signing keys that should still be accepted
+func Load(signingKeyFile string, verificationKeyFiles []string) (*Manager, error) {
+	raw, err := os.ReadFile(signingKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read signing key: %w", err)
+	}
+	private, err := parsePrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", signingKeyFile, err)
+	}
+
+	signing, err := newKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", signingKeyFile, err)
+	}
+
+	m := &Manager{signing: signing, keys: map[string]*Key{signing.ID: signing}}
+
+	for _, file := range verificationKeyFiles {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read verification key: %w", err)
+		}
+		public, err := parsePublicKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		key, err := newVerificationKey(public)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if _, exists := m.keys[key.ID]; !exists {
+			m.keys[key.ID] = key
+			m.retired = append(m.retired, key)
+		}
+	}
+
+	return m, nil
+}
+
+// Ephemeral generates an Ed25519 key that only lives as long as the process.
+// Every restart signs everyone out, so it is only meant for local development.
+func Ephemeral() (*Manager, error) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	signing, err := newKey(private)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manager{signing: signing, keys: map[string]*Key{signing.ID: signing}}, nil
+}
+
+func newKey(private crypto.PrivateKey) (*Key, error) {
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	key, err := newVerificationKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	key.private = private
+
+	return key, nil
+}
+
+func newVerificationKey(public crypto.PublicKey) (*Key, error) {
+	key := &Key{public: public}
+
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < 2048 {
+			return nil, ErrUnsupportedKeyType
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+
+	key.ID = thumbprint(key.jwk())
+	return key, nil
+}
+
+func parsePrivateKey(raw []byte) (crypto.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+
+	return nil, fmt.Errorf("unexpected PEM block %q; want a private key", block.Type)
+}
+
+// parsePublicKey accepts a public key, or a private key whose public half is used
+func parsePublicKey(raw []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	private, err := parsePrivateKey(raw)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+	return signer.Public(), nil
+}
+
+// Sign signs the claims with the current signing key and stamps its kid in the header
+func (m *Manager) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(m.signing.Method, claims)
+	token.Header["kid"] = m.signing.ID
+	return token.SignedString(m.signing.private)
+}
+
+// Parse verifies a token against the key named by its kid. The algorithm is
+// taken from the key, never from the token, so a token cannot pick how it is checked.
+func (m *Manager) Parse(tokenString string) (*jwt.Token, error) {
+	return jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := m.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKey
+		}
+		if t.Method.Alg() != key.Method.Alg() {
+			return nil, ErrUnexpectedMethod
+		}
+		return key.public, nil
+	})
+}
+
+// JWKS returns the public keys currently accepted, signing key first
+func (m *Manager) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{m.signing.jwk()}}
+	for _, key := range m.retired {
+		set.Keys = append(set.Keys, key.jwk())
+	}
+	return set
+}
+
+// SigningKeyID is the kid new tokens are signed with
+func (m *Manager) SigningKeyID() string {
+	return m.signing.ID
+}
+
+func (k *Key) jwk() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+
+	switch pub := k.public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+
+	return jwk
+}
+
+// thumbprint derives a stable kid from the key itself (RFC 7638)
+func thumbprint(jwk JWK) string {
+	var members interface{}
+	switch jwk.Kty {
+	case "RSA":
+		members = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{jwk.E, jwk.Kty, jwk.N}
+	default:
+		members = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{jwk.Crv, jwk.Kty, jwk.X}
+	}
+
+	raw, _ := json.Marshal(members)
+	sum := sha256.Sum256(raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
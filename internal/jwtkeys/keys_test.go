@@ -0,0 +1,320 @@
+package jwtkeys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func writePEM(t *testing.T, blockType string, der []byte) string {
+	t.Helper()
+
+	file, err := os.CreateTemp(t.TempDir(), "*.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if err := pem.Encode(file, &pem.Block{Type: blockType, Bytes: der}); err != nil {
+		t.Fatal(err)
+	}
+	return file.Name()
+}
+
+func rsaKey(t *testing.T, bits int) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func pkcs8(t *testing.T, key any) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func pkix(t *testing.T, key any) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func claims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "1", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+}
+
+func TestLoadPEMFormats(t *testing.T) {
+	rsaPrivate := rsaKey(t, 2048)
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		signing    string
+		wantMethod string
+	}{
+		{"PKCS1 RSA", writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPrivate)), "RS256"},
+		{"PKCS8 RSA", writePEM(t, "PRIVATE KEY", pkcs8(t, rsaPrivate)), "RS256"},
+		{"PKCS8 Ed25519", writePEM(t, "PRIVATE KEY", pkcs8(t, edPrivate)), "EdDSA"},
+	}
+
+	for _, tt := range tests {
+		m, err := Load(tt.signing, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if m.signing.Method.Alg() != tt.wantMethod {
+			t.Errorf("%s: method %s, want %s", tt.name, m.signing.Method.Alg(), tt.wantMethod)
+		}
+	}
+
+	// both RSA encodings are the same key, so they get the same kid
+	fromPKCS1, _ := Load(tests[0].signing, nil)
+	fromPKCS8, _ := Load(tests[1].signing, nil)
+	if fromPKCS1.SigningKeyID() != fromPKCS8.SigningKeyID() {
+		t.Errorf("kid differs between PKCS1 (%s) and PKCS8 (%s)", fromPKCS1.SigningKeyID(), fromPKCS8.SigningKeyID())
+	}
+
+	verification := []string{
+		writePEM(t, "PUBLIC KEY", pkix(t, &rsaPrivate.PublicKey)),
+		writePEM(t, "RSA PUBLIC KEY", x509.MarshalPKCS1PublicKey(&rsaPrivate.PublicKey)),
+		writePEM(t, "PUBLIC KEY", pkix(t, edPrivate.Public())),
+		// a private key is accepted for its public half
+		tests[1].signing,
+	}
+	m, err := Load(tests[2].signing, verification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the Ed25519 key signs, and the RSA key given three ways is kept once
+	if got := len(m.JWKS().Keys); got != 2 {
+		t.Errorf("JWKS has %d keys, want 2", got)
+	}
+}
+
+func TestLoadRejects(t *testing.T) {
+	small, large := rsaKey(t, 1024), rsaKey(t, 2048)
+	signing := writePEM(t, "PRIVATE KEY", pkcs8(t, large))
+	notPEM := filepath.Join(t.TempDir(), "key.txt")
+	if err := os.WriteFile(notPEM, []byte("not a key"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		signing      string
+		verification string
+		wantErr      error
+	}{
+		{"small RSA signing key", writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(small)), "", ErrUnsupportedKeyType},
+		{"small RSA verification key", signing, writePEM(t, "PUBLIC KEY", pkix(t, &small.PublicKey)), ErrUnsupportedKeyType},
+		{"public key as signing key", writePEM(t, "PUBLIC KEY", pkix(t, &large.PublicKey)), "", nil},
+		{"not PEM", notPEM, "", nil},
+		{"missing file", filepath.Join(t.TempDir(), "missing.pem"), "", nil},
+	}
+
+	for _, tt := range tests {
+		var verification []string
+		if tt.verification != "" {
+			verification = []string{tt.verification}
+		}
+		_, err := Load(tt.signing, verification)
+		if err == nil {
+			t.Errorf("%s: loaded", tt.name)
+			continue
+		}
+		if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestThumbprint(t *testing.T) {
+	// the example key from RFC 7638, section 3.1
+	jwk := JWK{
+		Kty: "RSA",
+		E:   "AQAB",
+		N:   "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		// members outside the thumbprint do not change it
+		Kid: "ignored",
+		Use: "sig",
+		Alg: "RS256",
+	}
+
+	if got, want := thumbprint(jwk), "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"; got != want {
+		t.Errorf("thumbprint = %s, want %s", got, want)
+	}
+}
+
+func TestRotatedKeysStillVerify(t *testing.T) {
+	oldKey := writePEM(t, "PRIVATE KEY", pkcs8(t, rsaKey(t, 2048)))
+	_, newPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKey := writePEM(t, "PRIVATE KEY", pkcs8(t, newPrivate))
+
+	before, err := Load(oldKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldToken, err := before.Sign(claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := Load(newKey, []string{oldKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Parse(oldToken); err != nil {
+		t.Errorf("token signed before the rotation: %v", err)
+	}
+	newToken, err := rotated.Sign(claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Parse(newToken); err != nil {
+		t.Errorf("token signed after the rotation: %v", err)
+	}
+
+	// once the old key is dropped its tokens stop working
+	retired, err := Load(newKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Parse(oldToken); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token signed with a dropped key: got %v, want %v", err, ErrUnknownKey)
+	}
+}
+
+func TestParseRejectsMismatchedAlgorithm(t *testing.T) {
+	rsaPrivate := rsaKey(t, 2048)
+	m, err := Load(writePEM(t, "PRIVATE KEY", pkcs8(t, rsaPrivate)), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the classic confusion: an HMAC token keyed with the published public key
+	public := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkix(t, &rsaPrivate.PublicKey)})
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims())
+	forged.Header["kid"] = m.SigningKeyID()
+	hmacToken, err := forged.SignedString(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and a token signed with another algorithm under the RSA key's kid
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	eddsa := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims())
+	eddsa.Header["kid"] = m.SigningKeyID()
+	eddsaToken, err := eddsa.SignedString(edPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"HS256": hmacToken, "EdDSA": eddsaToken} {
+		if _, err := m.Parse(token); !errors.Is(err, ErrUnexpectedMethod) {
+			t.Errorf("%s token: got %v, want %v", name, err, ErrUnexpectedMethod)
+		}
+	}
+
+	none := jwt.NewWithClaims(jwt.SigningMethodNone, claims())
+	none.Header["kid"] = m.SigningKeyID()
+	noneToken, err := none.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Parse(noneToken); err == nil {
+		t.Error("unsigned token parsed")
+	}
+}
+
+func TestJWKS(t *testing.T) {
+	rsaPrivate := rsaKey(t, 2048)
+	_, edPrivate, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edFile := writePEM(t, "PRIVATE KEY", pkcs8(t, edPrivate))
+
+	m, err := Load(edFile, []string{writePEM(t, "RSA PRIVATE KEY", x509.MarshalPKCS1PrivateKey(rsaPrivate)), edFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := m.JWKS()
+	if len(set.Keys) != 2 {
+		t.Fatalf("JWKS has %d keys, want 2", len(set.Keys))
+	}
+
+	signing, retired := set.Keys[0], set.Keys[1]
+	if signing.Kid != m.SigningKeyID() || signing.Kty != "OKP" || signing.Crv != "Ed25519" || signing.Alg != "EdDSA" || signing.X == "" {
+		t.Errorf("signing key = %+v", signing)
+	}
+	if retired.Kty != "RSA" || retired.Alg != "RS256" || retired.E != "AQAB" || retired.N == "" {
+		t.Errorf("retired key = %+v", retired)
+	}
+	for _, key := range set.Keys {
+		if key.Use != "sig" || key.Kid != thumbprint(key) {
+			t.Errorf("%s: use %q, kid does not match its thumbprint", key.Kid, key.Use)
+		}
+	}
+
+	// only public members are published
+	raw, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, private := range []string{`"d"`, `"p"`, `"q"`, `"dp"`, `"dq"`, `"qi"`} {
+		if strings.Contains(string(raw), private) {
+			t.Errorf("JWKS contains %s: %s", private, raw)
+		}
+	}
+}
+
+func TestSignStampsKid(t *testing.T) {
+	m, err := Ephemeral()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := m.Sign(claims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := m.Parse(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.Header["kid"] != m.SigningKeyID() || token.Method.Alg() != "EdDSA" {
+		t.Errorf("header = %v", token.Header)
+	}
+}
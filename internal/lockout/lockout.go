@@ -0,0 +1,185 @@
+// Package lockout throttles password guessing. Failed logins are counted per
+// email and per client IP; reaching the limit locks that subject out, and every
+// further lockout within a day lasts twice as long as the one before.
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// how long a subject's lockout history counts towards the next, longer lockout
+const historyTTL = 24 * time.Hour
+
+type Policy struct {
+	MaxAttempts      int
+	MaxAttemptsPerIP int
+	Window           time.Duration
+	LockoutBase      time.Duration
+	LockoutMax       time.Duration
+}
+
+type Guard struct {
+	Redis  *redis.Client
+	Policy Policy
+}
+
+// Lockout describes the lockout a failed attempt triggered
+type Lockout struct {
+	// Subject is "email" or "ip"
+	Subject  string
+	Duration time.Duration
+	// Count is how many times the subject was locked out in the last day
+	Count int
+}
+
+// failScript counts a failure and, on reaching the limit, swaps the counter
+// for a lock whose length doubles with every lockout in the history window
+var failScript = redis.NewScript(`
+local failures = redis.call('INCR', KEYS[1])
+if failures == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[2])
+end
+if failures < tonumber(ARGV[1]) then
+	return {0, 0}
+end
+
+redis.call('DEL', KEYS[1])
+local count = redis.call('INCR', KEYS[3])
+redis.call('EXPIRE', KEYS[3], ARGV[5])
+
+local seconds = tonumber(ARGV[4])
+if count < 31 then
+	seconds = math.min(tonumber(ARGV[3]) * 2 ^ (count - 1), seconds)
+end
+seconds = math.floor(seconds)
+redis.call('SET', KEYS[2], 1, 'EX', seconds)
+return {count, seconds}
+`)
+
+func normaliseEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+func keys(subject, value string) (failures, lock, history string) {
+	return fmt.Sprintf("login_failures:%s:%s", subject, value),
+		fmt.Sprintf("login_lock:%s:%s", subject, value),
+		fmt.Sprintf("login_lockouts:%s:%s", subject, value)
+}
+
+// RetryAfter returns how long the email or IP is still locked out, or 0
+func (g *Guard) RetryAfter(ctx context.Context, email, ip string) (time.Duration, error) {
+	_, emailLock, _ := keys("email", normaliseEmail(email))
+	_, ipLock, _ := keys("ip", ip)
+
+	pipe := g.Redis.Pipeline()
+	emailTTL := pipe.TTL(ctx, emailLock)
+	ipTTL := pipe.TTL(ctx, ipLock)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	// TTL is negative for keys that do not exist
+	wait := emailTTL.Val()
+	if ipTTL.Val() > wait {
+		wait = ipTTL.Val()
+	}
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
+
+// ipsKey names the set of IPs that failed logins for an email, so unlocking
+// the email can lift the lockouts those failures put on its IPs as well
+func ipsKey(email string) string {
+	return fmt.Sprintf("login_ips:email:%s", email)
+}
+
+// RecordFailure counts a failed login against both the email and the IP and
+// returns the lockout it triggered, if any. When both are locked out at once
+// the longer lockout is returned.
+func (g *Guard) RecordFailure(ctx context.Context, email, ip string) (*Lockout, error) {
+	email = normaliseEmail(email)
+	if email != "" && ip != "" {
+		pipe := g.Redis.TxPipeline()
+		pipe.SAdd(ctx, ipsKey(email), ip)
+		pipe.Expire(ctx, ipsKey(email), historyTTL)
+		if _, err := pipe.Exec(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	emailLock, err := g.fail(ctx, "email", email, g.Policy.MaxAttempts)
+	if err != nil {
+		return nil, err
+	}
+	ipLock, err := g.fail(ctx, "ip", ip, g.Policy.MaxAttemptsPerIP)
+	if err != nil {
+		return emailLock, err
+	}
+
+	if ipLock != nil && (emailLock == nil || ipLock.Duration > emailLock.Duration) {
+		return ipLock, nil
+	}
+	return emailLock, nil
+}
+
+// seconds rounds a duration up to whole seconds, and to at least one, since
+// Redis rejects or instantly expires keys given a TTL of zero
+func seconds(d time.Duration) int {
+	return max(1, int(math.Ceil(d.Seconds())))
+}
+
+func (g *Guard) fail(ctx context.Context, subject, value string, maxAttempts int) (*Lockout, error) {
+	if value == "" || maxAttempts <= 0 {
+		return nil, nil
+	}
+
+	failures, lock, history := keys(subject, value)
+	result, err := failScript.Run(ctx, g.Redis, []string{failures, lock, history},
+		maxAttempts,
+		seconds(g.Policy.Window),
+		seconds(g.Policy.LockoutBase),
+		seconds(g.Policy.LockoutMax),
+		seconds(historyTTL),
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	if result[0] == 0 {
+		return nil, nil
+	}
+	return &Lockout{Subject: subject, Count: int(result[0]), Duration: time.Duration(result[1]) * time.Second}, nil
+}
+
+// RecordSuccess forgets the failed attempts against an email after a good login
+func (g *Guard) RecordSuccess(ctx context.Context, email string) error {
+	failures, _, history := keys("email", normaliseEmail(email))
+	return g.Redis.Del(ctx, failures, history).Err()
+}
+
+// Unlock lifts a lockout on an email and clears its history, along with the
+// lockouts and failure counts of every IP that failed logins for it in the
+// last day
+func (g *Guard) Unlock(ctx context.Context, email string) error {
+	email = normaliseEmail(email)
+	ips, err := g.Redis.SMembers(ctx, ipsKey(email)).Result()
+	if err != nil {
+		return err
+	}
+
+	failures, lock, history := keys("email", email)
+	stale := []string{failures, lock, history, ipsKey(email)}
+	for _, ip := range ips {
+		failures, lock, history := keys("ip", ip)
+		stale = append(stale, failures, lock, history)
+	}
+	return g.Redis.Del(ctx, stale...).Err()
+}
@@ -0,0 +1,257 @@
+package lockout
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newGuard(t *testing.T) (*Guard, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	return &Guard{
+		Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		Policy: Policy{
+			MaxAttempts:      3,
+			MaxAttemptsPerIP: 10,
+			Window:           15 * time.Minute,
+			LockoutBase:      time.Minute,
+			LockoutMax:       10 * time.Minute,
+		},
+	}, mr
+}
+
+// failUntilLocked records failures for the email until one of them locks it out
+func failUntilLocked(t *testing.T, g *Guard, email, ip string) *Lockout {
+	t.Helper()
+
+	for i := 1; i <= g.Policy.MaxAttempts; i++ {
+		lock, err := g.RecordFailure(context.Background(), email, ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lock != nil {
+			if i != g.Policy.MaxAttempts {
+				t.Fatalf("locked out after %d failures, want %d", i, g.Policy.MaxAttempts)
+			}
+			return lock
+		}
+	}
+	t.Fatalf("no lockout after %d failures", g.Policy.MaxAttempts)
+	return nil
+}
+
+func TestBackoffDoublesUpToCeiling(t *testing.T) {
+	g, _ := newGuard(t)
+
+	want := []time.Duration{
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		10 * time.Minute,
+		10 * time.Minute,
+	}
+	for i, duration := range want {
+		// a different IP each round keeps the per-IP limit out of the way
+		lock := failUntilLocked(t, g, "a@example.com", fmt.Sprintf("10.0.0.%d", i))
+		if lock.Subject != "email" || lock.Count != i+1 || lock.Duration != duration {
+			t.Errorf("lockout %d: got %+v, want email lockout %d for %s", i+1, *lock, i+1, duration)
+		}
+	}
+}
+
+func TestBackoffCeilingWithLongHistory(t *testing.T) {
+	tests := []struct {
+		name    string
+		history int
+		want    time.Duration
+	}{
+		{"just under the ceiling", 3, 8 * time.Minute},
+		{"first over the ceiling", 4, 10 * time.Minute},
+		{"where 2^n stops being computed", 30, 10 * time.Minute},
+		{"far beyond", 1000, 10 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, mr := newGuard(t)
+			_, _, history := keys("email", "a@example.com")
+			mr.Set(history, fmt.Sprint(tt.history))
+
+			lock := failUntilLocked(t, g, "a@example.com", "10.0.0.1")
+			if lock.Count != tt.history+1 || lock.Duration != tt.want {
+				t.Errorf("got %+v, want lockout %d for %s", *lock, tt.history+1, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	g, mr := newGuard(t)
+	ctx := context.Background()
+
+	failUntilLocked(t, g, "a@example.com", "10.0.0.1")
+
+	tests := []struct {
+		name  string
+		email string
+		ip    string
+		after time.Duration
+		want  time.Duration
+	}{
+		{"locked email", "a@example.com", "10.0.0.2", 0, time.Minute},
+		{"email in another case", " A@Example.com", "10.0.0.2", 0, time.Minute},
+		{"other email", "b@example.com", "10.0.0.1", 0, 0},
+		{"part way through", "a@example.com", "10.0.0.2", 20 * time.Second, 40 * time.Second},
+		{"lock over", "a@example.com", "10.0.0.2", 40 * time.Second, 0},
+	}
+
+	for _, tt := range tests {
+		mr.FastForward(tt.after)
+		wait, err := g.RetryAfter(ctx, tt.email, tt.ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != tt.want {
+			t.Errorf("%s: waiting %s, want %s", tt.name, wait, tt.want)
+		}
+	}
+}
+
+func TestFailuresOutsideWindowAreForgotten(t *testing.T) {
+	g, mr := newGuard(t)
+	ctx := context.Background()
+
+	for i := 0; i < g.Policy.MaxAttempts-1; i++ {
+		if _, err := g.RecordFailure(ctx, "a@example.com", "10.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mr.FastForward(g.Policy.Window + time.Second)
+
+	lock, err := g.RecordFailure(ctx, "a@example.com", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock != nil {
+		t.Errorf("locked out by failures from an earlier window: %+v", *lock)
+	}
+}
+
+func TestIPLockoutAcrossEmails(t *testing.T) {
+	g, _ := newGuard(t)
+	ctx := context.Background()
+
+	var lock *Lockout
+	for i := 1; i <= g.Policy.MaxAttemptsPerIP; i++ {
+		var err error
+		lock, err = g.RecordFailure(ctx, fmt.Sprintf("user%d@example.com", i), "10.0.0.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if lock != nil && i < g.Policy.MaxAttemptsPerIP {
+			t.Fatalf("locked out after %d failures: %+v", i, *lock)
+		}
+	}
+	if lock == nil || lock.Subject != "ip" || lock.Duration != time.Minute {
+		t.Fatalf("got %+v, want a one minute ip lockout", lock)
+	}
+
+	wait, err := g.RetryAfter(ctx, "someone-else@example.com", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != time.Minute {
+		t.Errorf("waiting %s, want 1m0s", wait)
+	}
+}
+
+func TestRecordSuccessResetsBackoff(t *testing.T) {
+	g, mr := newGuard(t)
+	ctx := context.Background()
+
+	failUntilLocked(t, g, "a@example.com", "10.0.0.1")
+	if lock := failUntilLocked(t, g, "a@example.com", "10.0.0.1"); lock.Duration != 2*time.Minute {
+		t.Fatalf("second lockout lasts %s, want 2m0s", lock.Duration)
+	}
+
+	mr.FastForward(2 * time.Minute)
+	if err := g.RecordSuccess(ctx, "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	if lock := failUntilLocked(t, g, "a@example.com", "10.0.0.1"); lock.Count != 1 || lock.Duration != time.Minute {
+		t.Errorf("got %+v after a good login, want the first lockout again", *lock)
+	}
+}
+
+func TestEmailLockoutStillCountsIPFailures(t *testing.T) {
+	g, mr := newGuard(t)
+	g.Policy.MaxAttemptsPerIP = g.Policy.MaxAttempts
+
+	lock := failUntilLocked(t, g, "a@example.com", "10.0.0.1")
+	if lock.Duration != time.Minute {
+		t.Fatalf("got %+v, want a one minute lockout", *lock)
+	}
+
+	_, ipLock, _ := keys("ip", "10.0.0.1")
+	if !mr.Exists(ipLock) {
+		t.Error("the IP was not locked out by the failure that locked out the email")
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	g, _ := newGuard(t)
+	g.Policy.MaxAttemptsPerIP = g.Policy.MaxAttempts
+	ctx := context.Background()
+
+	failUntilLocked(t, g, "a@example.com", "10.0.0.1")
+	if err := g.Unlock(ctx, "A@example.com"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range []string{"10.0.0.1", "10.0.0.2"} {
+		wait, err := g.RetryAfter(ctx, "a@example.com", ip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != 0 {
+			t.Errorf("%s: still locked for %s after unlock", ip, wait)
+		}
+	}
+
+	// the IP's failure count starts over too
+	lock, err := g.RecordFailure(ctx, "b@example.com", "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lock != nil {
+		t.Errorf("locked out by failures from before the unlock: %+v", *lock)
+	}
+}
+
+func TestSubSecondPolicyRoundsUp(t *testing.T) {
+	g, mr := newGuard(t)
+	g.Policy.Window = 500 * time.Millisecond
+	g.Policy.LockoutBase = 100 * time.Millisecond
+	ctx := context.Background()
+
+	if _, err := g.RecordFailure(ctx, "a@example.com", "10.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	failures, _, _ := keys("email", "a@example.com")
+	if ttl := mr.TTL(failures); ttl != time.Second {
+		t.Errorf("failure count expires in %s, want 1s", ttl)
+	}
+
+	lock := failUntilLocked(t, g, "b@example.com", "10.0.0.1")
+	if lock.Duration != time.Second {
+		t.Errorf("got %+v, want a one second lockout", *lock)
+	}
+}
@@ -0,0 +1,134 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// newTestProvider serves discovery and a JWKS holding one Ed25519 key,
+// counting how often the JWKS is fetched
+func newTestProvider(t *testing.T) (*Provider, ed25519.PrivateKey, *atomic.Int32) {
+	t.Helper()
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fetches := &atomic.Int32{}
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(discovery{
+			Issuer:                server.URL,
+			AuthorizationEndpoint: server.URL + "/authorize",
+			TokenEndpoint:         server.URL + "/token",
+			JWKSURI:               server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		json.NewEncoder(w).Encode(map[string]any{"keys": []jsonWebKey{
+			{Kty: "OKP", Crv: "Ed25519", Kid: "known", Use: "sig", X: base64.RawURLEncoding.EncodeToString(public)},
+		}})
+	})
+
+	return &Provider{Name: "test", Issuer: server.URL, ClientID: "client"}, private, fetches
+}
+
+func signIDToken(t *testing.T, key ed25519.PrivateKey, kid string, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestVerify(t *testing.T) {
+	provider, key, _ := newTestProvider(t)
+	now := time.Now()
+	claims := func(edit func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{"iss": provider.Issuer, "aud": "client", "sub": "123", "nonce": "n", "exp": now.Add(time.Minute).Unix(), "email": "a@example.com"}
+		if edit != nil {
+			edit(c)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		claims  jwt.MapClaims
+		wantErr bool
+	}{
+		{"valid", claims(nil), false},
+		{"no expiry", claims(func(c jwt.MapClaims) { delete(c, "exp") }), true},
+		{"expired", claims(func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() }), true},
+		{"wrong issuer", claims(func(c jwt.MapClaims) { c["iss"] = "https://elsewhere.example" }), true},
+		{"wrong audience", claims(func(c jwt.MapClaims) { c["aud"] = "someone-else" }), true},
+		{"wrong nonce", claims(func(c jwt.MapClaims) { c["nonce"] = "other" }), true},
+		{"no subject", claims(func(c jwt.MapClaims) { delete(c, "sub") }), true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.verify(context.Background(), signIDToken(t, key, "known", tt.claims), "n")
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Errorf("got %v, want ErrInvalidToken", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Subject != "123" || got.Email != "a@example.com" {
+				t.Errorf("got %+v", got)
+			}
+		})
+	}
+}
+
+func TestUnknownKidRefetchIsRateLimited(t *testing.T) {
+	provider, key, fetches := newTestProvider(t)
+	claims := jwt.MapClaims{"iss": provider.Issuer, "aud": "client", "sub": "123", "nonce": "n", "exp": time.Now().Add(time.Minute).Unix()}
+
+	for i := 0; i < 5; i++ {
+		if _, err := provider.verify(context.Background(), signIDToken(t, key, "made-up", claims), "n"); err == nil {
+			t.Fatal("verified a token with an unknown kid")
+		}
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Errorf("fetched the JWKS %d times for unknown kids, want 1", got)
+	}
+
+	// a known kid is served from the keys already fetched
+	if _, err := provider.verify(context.Background(), signIDToken(t, key, "known", claims), "n"); err != nil {
+		t.Fatal(err)
+	}
+
+	// once the interval has passed an unknown kid may refetch again
+	provider.mu.Lock()
+	provider.keysFetchedAt = time.Now().Add(-jwksRefetchInterval)
+	provider.mu.Unlock()
+	provider.verify(context.Background(), signIDToken(t, key, "made-up", claims), "n")
+	if got := fetches.Load(); got != 2 {
+		t.Errorf("fetched the JWKS %d times, want 2", got)
+	}
+}
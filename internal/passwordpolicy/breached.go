@@ -0,0 +1,106 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// the SHA-1 hashes of the most common leaked passwords, one per line
+//
+//go:embed breached_sha1.txt
+var bundledBreachList string
+
+const rangePrefixLength = 5
+
+// BreachList is a corpus of breached password hashes. Like the Pwned Passwords
+// range API it is looked up by the first five hex characters of a password's
+// SHA-1, returning every hash suffix in that range, so the full hash of a
+// password never has to leave the caller.
+type BreachList struct {
+	ranges map[string][]string
+}
+
+// DefaultBreachList returns the list bundled with the binary
+func DefaultBreachList() *BreachList {
+	list, err := ParseBreachList(strings.NewReader(bundledBreachList))
+	if err != nil {
+		panic(fmt.Sprintf("bundled breach list is invalid: %v", err))
+	}
+	return list
+}
+
+// LoadBreachList adds the hashes in a file to the bundled list
+func LoadBreachList(path string) (*BreachList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	extra, err := ParseBreachList(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	list := DefaultBreachList()
+	for prefix, suffixes := range extra.ranges {
+		list.ranges[prefix] = append(list.ranges[prefix], suffixes...)
+	}
+	return list, nil
+}
+
+// ParseBreachList reads one hex SHA-1 per line. A ":count" after the hash, as in
+// Pwned Passwords downloads, is ignored, as are blank lines and # comments.
+func ParseBreachList(r io.Reader) (*BreachList, error) {
+	list := &BreachList{ranges: map[string][]string{}}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash := strings.ToUpper(strings.SplitN(text, ":", 2)[0])
+		if len(hash) != sha1.Size*2 {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+		if _, err := hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("line %d: not a SHA-1 hash", line)
+		}
+
+		prefix := hash[:rangePrefixLength]
+		list.ranges[prefix] = append(list.ranges[prefix], hash[rangePrefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Range returns the hash suffixes that share a five-character prefix
+func (b *BreachList) Range(prefix string) []string {
+	return b.ranges[strings.ToUpper(prefix)]
+}
+
+// Contains reports whether the password is in the list
+func (b *BreachList) Contains(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	for _, suffix := range b.Range(hash[:rangePrefixLength]) {
+		if suffix == hash[rangePrefixLength:] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,64 @@
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestBundledBreachList(t *testing.T) {
+	list := DefaultBreachList()
+
+	for _, password := range []string{"password", "123456", "qwerty"} {
+		if !list.Contains(password) {
+			t.Errorf("%q is not in the bundled list", password)
+		}
+	}
+	if list.Contains("Correct horse 42") {
+		t.Error("an unknown password was reported as breached")
+	}
+
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	found := false
+	for _, suffix := range list.Range("5baa6") {
+		found = found || suffix == "1E4C9B93F3F0682250B6CF8331B7EE68FD8"
+	}
+	if !found {
+		t.Error("the range for 5BAA6 lacks the hash of \"password\"")
+	}
+}
+
+func TestLoadBreachList(t *testing.T) {
+	// in Pwned Passwords format, with a count after the hash
+	path := filepath.Join(t.TempDir(), "extra.txt")
+	extra := "# extra hashes\n\n" + sha1Hex("Correct horse 42") + ":17\n"
+	if err := os.WriteFile(path, []byte(extra), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadBreachList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, password := range []string{"Correct horse 42", "password"} {
+		if !list.Contains(password) {
+			t.Errorf("%q is not in the loaded list", password)
+		}
+	}
+}
+
+func TestParseBreachListRejectsMalformedLines(t *testing.T) {
+	for _, line := range []string{"not-a-hash", strings.Repeat("Z", 40), strings.Repeat("A", 39)} {
+		if _, err := ParseBreachList(strings.NewReader(line + "\n")); err == nil || err.Error() != "line 1: not a SHA-1 hash" {
+			t.Errorf("%q: got %v", line, err)
+		}
+	}
+}
+
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,117 @@
+package passwordpolicy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// bcrypt ignores everything after the first 72 bytes of a password
+const BcryptMaxBytes = 72
+
+// violation codes reported in FieldError.Code
+const (
+	CodeTooShort         = "too_short"
+	CodeTooLong          = "too_long"
+	CodeTooFewClasses    = "too_few_character_classes"
+	CodeContainsIdentity = "contains_identity"
+	CodeBreached         = "breached"
+	// not checked by Policy, which never sees the old password
+	CodeUnchanged = "unchanged"
+)
+
+// FieldError is one reason a request field was rejected
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Policy is what a new password must satisfy
+type Policy struct {
+	MinLength int
+	// MaxBytes is capped at BcryptMaxBytes so no part of a password is silently ignored
+	MaxBytes int
+	// MinClasses is how many of lowercase, uppercase, digits and symbols must appear
+	MinClasses int
+	// Breached rejects known-compromised passwords; nil skips the check
+	Breached *BreachList
+}
+
+// Check returns every rule the password breaks, reported against field. The
+// username and email belong to the account, and the password may not contain either.
+func (p *Policy) Check(field, password, username, email string) []FieldError {
+	errs := []FieldError{}
+	fail := func(code, format string, args ...interface{}) {
+		errs = append(errs, FieldError{Field: field, Code: code, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if length := len([]rune(password)); length < p.MinLength {
+		fail(CodeTooShort, "must be at least %d characters long", p.MinLength)
+	}
+
+	maxBytes := p.MaxBytes
+	if maxBytes <= 0 || maxBytes > BcryptMaxBytes {
+		maxBytes = BcryptMaxBytes
+	}
+	if len(password) > maxBytes {
+		fail(CodeTooLong, "must be at most %d bytes long", maxBytes)
+	}
+
+	if classes := characterClasses(password); classes < p.MinClasses {
+		fail(CodeTooFewClasses, "must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinClasses)
+	}
+
+	if containsIdentity(password, username, email) {
+		fail(CodeContainsIdentity, "must not contain your username or email address")
+	}
+
+	if p.Breached != nil && p.Breached.Contains(password) {
+		fail(CodeBreached, "appears in a list of breached passwords; choose another")
+	}
+
+	return errs
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	count := 0
+	for _, present := range []bool{lower, upper, digit, symbol} {
+		if present {
+			count++
+		}
+	}
+	return count
+}
+
+// containsIdentity ignores case and parts shorter than three characters,
+// which would match too many unrelated passwords
+func containsIdentity(password, username, email string) bool {
+	lowered := strings.ToLower(password)
+
+	parts := []string{username, email}
+	if at := strings.LastIndex(email, "@"); at > 0 {
+		parts = append(parts, email[:at])
+	}
+
+	for _, part := range parts {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if len(part) >= 3 && strings.Contains(lowered, part) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,76 @@
+package passwordpolicy
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	policy := &Policy{MinLength: 10, MaxBytes: BcryptMaxBytes, MinClasses: 2, Breached: DefaultBreachList()}
+
+	tests := []struct {
+		name     string
+		password string
+		want     []string
+	}{
+		{"good", "Correct horse 42", nil},
+		{"too short", "Ab1!", []string{CodeTooShort}},
+		{"length counts characters, not bytes", "Ünïcödé-äö", nil},
+		{"exactly the byte limit", strings.Repeat("a", 71) + "B", nil},
+		{"one byte over the limit", strings.Repeat("a", 72) + "B", []string{CodeTooLong}},
+		{"one class", "correcthorsebattery", []string{CodeTooFewClasses}},
+		{"symbols count as a class", "correct horse battery", nil},
+		{"contains the username", "Xx-jdoe-2024", []string{CodeContainsIdentity}},
+		{"contains the username in another case", "Xx-JDOE-2024", []string{CodeContainsIdentity}},
+		{"contains the email's local part", "Janedoe!Rules", []string{CodeContainsIdentity}},
+		{"breached", "password", []string{CodeTooShort, CodeTooFewClasses, CodeBreached}},
+		{"breached and long enough", "Password123", []string{CodeBreached}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errs := policy.Check("password", tt.password, "jdoe", "janedoe@example.com")
+
+			codes := []string{}
+			for _, err := range errs {
+				if err.Field != "password" || err.Message == "" {
+					t.Errorf("error %+v lacks its field or message", err)
+				}
+				codes = append(codes, err.Code)
+			}
+			if !slices.Equal(codes, tt.want) {
+				t.Errorf("got %v, want %v", codes, tt.want)
+			}
+		})
+	}
+}
+
+func TestMaxBytesIsCappedAtBcryptLimit(t *testing.T) {
+	tests := []struct {
+		maxBytes int
+		password string
+		want     bool
+	}{
+		{0, strings.Repeat("aB", 36), false},
+		{0, strings.Repeat("aB", 36) + "c", true},
+		{200, strings.Repeat("aB", 36) + "c", true},
+		{20, strings.Repeat("aB", 10) + "c", true},
+	}
+
+	for _, tt := range tests {
+		policy := &Policy{MaxBytes: tt.maxBytes}
+		errs := policy.Check("password", tt.password, "", "")
+		tooLong := slices.ContainsFunc(errs, func(err FieldError) bool { return err.Code == CodeTooLong })
+		if tooLong != tt.want {
+			t.Errorf("MaxBytes %d, %d bytes: too long = %v, want %v", tt.maxBytes, len(tt.password), tooLong, tt.want)
+		}
+	}
+}
+
+func TestShortIdentityPartsAreIgnored(t *testing.T) {
+	// "al" would match far too many passwords to be worth rejecting
+	if containsIdentity("Royal-Gala-Apple", "al", "al@example.com") {
+		t.Error("a two-character username was matched")
+	}
+}
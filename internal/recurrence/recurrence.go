@@ -0,0 +1,498 @@
+// Package recurrence parses and expands the RFC 5545 recurrence rules events
+// repeat by. It covers the parts calendars commonly produce: FREQ (DAILY,
+// WEEKLY, MONTHLY, YEARLY), INTERVAL, COUNT, UNTIL, BYDAY (with ordinals for
+// monthly and yearly rules), BYMONTHDAY, BYMONTH and WKST. Any other part is
+// rejected rather than silently ignored.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+// a rule that never produces an occurrence (say, February 30th) must not loop forever
+const maxPeriods = 100000
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+// Day is a BYDAY entry. N is the ordinal ("2nd Tuesday" is N=2, "last Friday"
+// is N=-1) and 0 means every such weekday in the period.
+type Day struct {
+	N       int
+	Weekday time.Weekday
+}
+
+// Rule is a parsed RRULE
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	Count      int
+	Until      *time.Time
+	ByDay      []Day
+	ByMonthDay []int
+	ByMonth    []time.Month
+	WeekStart  time.Weekday
+}
+
+func invalid(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", ErrInvalidRule, fmt.Sprintf(format, args...))
+}
+
+// Parse reads an RRULE value, with or without the "RRULE:" prefix. A floating
+// UNTIL (without a trailing Z) is read in loc, the time zone the event's start
+// is in.
+func Parse(value string, loc *time.Location) (*Rule, error) {
+	value = strings.TrimPrefix(strings.TrimSpace(value), "RRULE:")
+	if value == "" {
+		return nil, invalid("empty rule")
+	}
+
+	rule := &Rule{Interval: 1, WeekStart: time.Monday}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(value, ";") {
+		name, val, ok := strings.Cut(part, "=")
+		name = strings.ToUpper(strings.TrimSpace(name))
+		val = strings.ToUpper(strings.TrimSpace(val))
+		if !ok || val == "" {
+			return nil, invalid("%q is not NAME=VALUE", part)
+		}
+		if seen[name] {
+			return nil, invalid("%s appears twice", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			switch Frequency(val) {
+			case Daily, Weekly, Monthly, Yearly:
+				rule.Freq = Frequency(val)
+			default:
+				return nil, invalid("FREQ=%s is not supported", val)
+			}
+		case "INTERVAL":
+			rule.Interval, err = positive(val)
+		case "COUNT":
+			rule.Count, err = positive(val)
+		case "UNTIL":
+			var until time.Time
+			until, err = parseUntil(val, loc)
+			rule.Until = &until
+		case "BYDAY":
+			rule.ByDay, err = parseDays(val)
+		case "BYMONTHDAY":
+			rule.ByMonthDay, err = parseInts(val, -31, 31)
+		case "BYMONTH":
+			var months []int
+			months, err = parseInts(val, 1, 12)
+			for _, m := range months {
+				rule.ByMonth = append(rule.ByMonth, time.Month(m))
+			}
+		case "WKST":
+			day, known := weekdays[val]
+			if !known {
+				err = fmt.Errorf("unknown weekday %q", val)
+			}
+			rule.WeekStart = day
+		default:
+			return nil, invalid("%s is not supported", name)
+		}
+		if err != nil {
+			return nil, invalid("%s: %v", name, err)
+		}
+	}
+
+	if rule.Freq == "" {
+		return nil, invalid("FREQ is required")
+	}
+	if rule.Count > 0 && rule.Until != nil {
+		return nil, invalid("COUNT and UNTIL cannot be combined")
+	}
+	if rule.Freq == Weekly && len(rule.ByMonthDay) > 0 {
+		return nil, invalid("BYMONTHDAY cannot be used with FREQ=WEEKLY")
+	}
+	for _, day := range rule.ByDay {
+		if day.N != 0 && rule.Freq != Monthly && rule.Freq != Yearly {
+			return nil, invalid("numbered BYDAY values need FREQ=MONTHLY or FREQ=YEARLY")
+		}
+	}
+
+	return rule, nil
+}
+
+func positive(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%q is not a positive number", value)
+	}
+	return n, nil
+}
+
+func parseInts(value string, min, max int) ([]int, error) {
+	result := []int{}
+	for _, part := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < min || n > max || n == 0 {
+			return nil, fmt.Errorf("%q is out of range", part)
+		}
+		result = append(result, n)
+	}
+	return result, nil
+}
+
+func parseDays(value string) ([]Day, error) {
+	days := []Day{}
+	for _, part := range strings.Split(value, ",") {
+		if len(part) < 2 {
+			return nil, fmt.Errorf("%q is not a weekday", part)
+		}
+		weekday, known := weekdays[part[len(part)-2:]]
+		if !known {
+			return nil, fmt.Errorf("%q is not a weekday", part)
+		}
+
+		day := Day{Weekday: weekday}
+		if ordinal := part[:len(part)-2]; ordinal != "" {
+			n, err := strconv.Atoi(ordinal)
+			if err != nil || n == 0 || n < -53 || n > 53 {
+				return nil, fmt.Errorf("%q has an invalid ordinal", part)
+			}
+			day.N = n
+		}
+		days = append(days, day)
+	}
+	return days, nil
+}
+
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse("20060102T150405Z", value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102", value, loc); err == nil {
+		// a date-only UNTIL includes the whole day
+		return t.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return time.Time{}, fmt.Errorf("%q is not a date or UTC date-time", value)
+}
+
+// String formats the rule as an RRULE value, without the "RRULE:" prefix
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+	if len(r.ByDay) > 0 {
+		days := []string{}
+		for _, day := range r.ByDay {
+			name := strings.ToUpper(day.Weekday.String()[:2])
+			if day.N != 0 {
+				name = strconv.Itoa(day.N) + name
+			}
+			days = append(days, name)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if len(r.ByMonthDay) > 0 {
+		parts = append(parts, "BYMONTHDAY="+joinInts(r.ByMonthDay))
+	}
+	if len(r.ByMonth) > 0 {
+		months := []int{}
+		for _, m := range r.ByMonth {
+			months = append(months, int(m))
+		}
+		parts = append(parts, "BYMONTH="+joinInts(months))
+	}
+	if r.WeekStart != time.Monday {
+		parts = append(parts, "WKST="+strings.ToUpper(r.WeekStart.String()[:2]))
+	}
+	return strings.Join(parts, ";")
+}
+
+func joinInts(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = strconv.Itoa(v)
+	}
+	return strings.Join(parts, ",")
+}
+
+// EndBefore returns a copy of the rule that stops before the occurrence
+// starting at t, which is how "this and all following" ends a series
+func (r *Rule) EndBefore(t time.Time) *Rule {
+	ended := *r
+	until := t.Add(-time.Second).UTC()
+	ended.Until = &until
+	ended.Count = 0
+	return &ended
+}
+
+// Between returns the starts of the occurrences of a series that begins at
+// dtstart and fall within [from, to), in order. Starts in exclude (EXDATE) are
+// left out but still count towards COUNT. limit caps the result when above 0.
+// Occurrences keep dtstart's wall-clock time in dtstart's time zone.
+func (r *Rule) Between(dtstart, from, to time.Time, exclude []time.Time, limit int) []time.Time {
+	excluded := map[int64]bool{}
+	for _, t := range exclude {
+		excluded[t.Unix()] = true
+	}
+
+	result := []time.Time{}
+	r.each(dtstart, to, func(t time.Time) bool {
+		if !t.Before(from) && !excluded[t.Unix()] {
+			result = append(result, t)
+		}
+		return limit <= 0 || len(result) < limit
+	})
+	return result
+}
+
+// Includes reports whether the series has an occurrence starting at t,
+// ignoring exclusions
+func (r *Rule) Includes(dtstart, t time.Time) bool {
+	found := r.Between(dtstart, t, t.Add(time.Second), nil, 1)
+	return len(found) == 1 && found[0].Equal(t)
+}
+
+// each calls yield with every occurrence before end, in order, until yield returns false
+func (r *Rule) each(dtstart, end time.Time, yield func(time.Time) bool) {
+	count := 0
+	for period := 0; period < maxPeriods; period++ {
+		start, candidates := r.period(dtstart, period)
+		if !start.Before(end) || (r.Until != nil && start.After(*r.Until)) {
+			return
+		}
+
+		for _, t := range candidates {
+			if t.Before(dtstart) {
+				continue
+			}
+			if !t.Before(end) || (r.Until != nil && t.After(*r.Until)) {
+				return
+			}
+
+			count++
+			if !yield(t) {
+				return
+			}
+			if r.Count > 0 && count >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+// period returns the start of the n-th period (day, week, month or year) of
+// the series and the occurrence candidates within it, sorted
+func (r *Rule) period(dtstart time.Time, n int) (time.Time, []time.Time) {
+	loc := dtstart.Location()
+	hour, minute, second := dtstart.Clock()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, minute, second, 0, loc)
+	}
+	step := n * r.Interval
+
+	var start time.Time
+	candidates := []time.Time{}
+	switch r.Freq {
+	case Daily:
+		start = time.Date(dtstart.Year(), dtstart.Month(), dtstart.Day()+step, 0, 0, 0, 0, loc)
+		day := at(start.Year(), start.Month(), start.Day())
+		if r.monthAllowed(day.Month()) && r.monthDayAllowed(day) && r.weekdayAllowed(day) {
+			candidates = append(candidates, day)
+		}
+
+	case Weekly:
+		offset := (int(dtstart.Weekday()) - int(r.WeekStart) + 7) % 7
+		start = time.Date(dtstart.Year(), dtstart.Month(), dtstart.Day()-offset+7*step, 0, 0, 0, 0, loc)
+		days := r.ByDay
+		if len(days) == 0 {
+			days = []Day{{Weekday: dtstart.Weekday()}}
+		}
+		for _, day := range days {
+			shift := (int(day.Weekday) - int(r.WeekStart) + 7) % 7
+			t := at(start.Year(), start.Month(), start.Day()+shift)
+			if r.monthAllowed(t.Month()) {
+				candidates = append(candidates, t)
+			}
+		}
+
+	case Monthly:
+		start = time.Date(dtstart.Year(), dtstart.Month()+time.Month(step), 1, 0, 0, 0, 0, loc)
+		if r.monthAllowed(start.Month()) {
+			for _, day := range r.monthDays(start.Year(), start.Month(), dtstart.Day()) {
+				candidates = append(candidates, at(start.Year(), start.Month(), day))
+			}
+		}
+
+	case Yearly:
+		start = time.Date(dtstart.Year()+step, time.January, 1, 0, 0, 0, 0, loc)
+		year := start.Year()
+		switch {
+		case len(r.ByMonth) > 0 || len(r.ByMonthDay) > 0:
+			months := r.ByMonth
+			if len(months) == 0 {
+				months = []time.Month{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+			}
+			for _, month := range months {
+				for _, day := range r.monthDays(year, month, dtstart.Day()) {
+					candidates = append(candidates, at(year, month, day))
+				}
+			}
+		case len(r.ByDay) > 0:
+			// numbered weekdays count through the whole year
+			for _, yearDay := range nthWeekdays(r.ByDay, time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC), daysIn(year, 0)) {
+				candidates = append(candidates, at(year, time.January, yearDay))
+			}
+		default:
+			if dtstart.Day() <= daysIn(year, dtstart.Month()) {
+				candidates = append(candidates, at(year, dtstart.Month(), dtstart.Day()))
+			}
+		}
+	}
+
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	return start, dedupe(candidates)
+}
+
+// monthDays lists the days of a month a monthly (or yearly, per month) rule picks
+func (r *Rule) monthDays(year int, month time.Month, defaultDay int) []int {
+	length := daysIn(year, month)
+	first := time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+
+	if len(r.ByMonthDay) > 0 {
+		days := []int{}
+		for _, d := range r.ByMonthDay {
+			if d < 0 {
+				d = length + d + 1
+			}
+			if d < 1 || d > length {
+				continue
+			}
+			// BYDAY only narrows the days BYMONTHDAY picked
+			if len(r.ByDay) > 0 && !r.weekdayAllowed(first.AddDate(0, 0, d-1)) {
+				continue
+			}
+			days = append(days, d)
+		}
+		return days
+	}
+
+	if len(r.ByDay) > 0 {
+		return nthWeekdays(r.ByDay, first, length)
+	}
+
+	if defaultDay > length {
+		return nil
+	}
+	return []int{defaultDay}
+}
+
+// nthWeekdays returns the 1-based day numbers, counted from first, of the
+// BYDAY entries within a span of length days
+func nthWeekdays(days []Day, first time.Time, length int) []int {
+	result := []int{}
+	for _, day := range days {
+		matches := []int{}
+		offset := (int(day.Weekday) - int(first.Weekday()) + 7) % 7
+		for d := offset + 1; d <= length; d += 7 {
+			matches = append(matches, d)
+		}
+
+		switch {
+		case day.N == 0:
+			result = append(result, matches...)
+		case day.N > 0 && day.N <= len(matches):
+			result = append(result, matches[day.N-1])
+		case day.N < 0 && -day.N <= len(matches):
+			result = append(result, matches[len(matches)+day.N])
+		}
+	}
+	return result
+}
+
+// daysIn returns the number of days in a month, or in the whole year when month is 0
+func daysIn(year int, month time.Month) int {
+	if month == 0 {
+		return time.Date(year, time.December, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	}
+	return time.Date(year, month+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func (r *Rule) monthAllowed(month time.Month) bool {
+	if len(r.ByMonth) == 0 || r.Freq == Yearly {
+		return true
+	}
+	for _, m := range r.ByMonth {
+		if m == month {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) monthDayAllowed(t time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+	length := daysIn(t.Year(), t.Month())
+	for _, d := range r.ByMonthDay {
+		if d == t.Day() || length+d+1 == t.Day() {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Rule) weekdayAllowed(t time.Time) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, day := range r.ByDay {
+		if day.Weekday == t.Weekday() {
+			return true
+		}
+	}
+	return false
+}
+
+func dedupe(times []time.Time) []time.Time {
+	result := []time.Time{}
+	for i, t := range times {
+		if i == 0 || !t.Equal(times[i-1]) {
+			result = append(result, t)
+		}
+	}
+	return result
+}
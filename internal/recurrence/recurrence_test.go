@@ -0,0 +1,190 @@
+package recurrence
+
+import (
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+const wallClock = "2006-01-02 15:04 MST"
+
+func newYork(t *testing.T) *time.Location {
+	t.Helper()
+
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("no time zone database: %v", err)
+	}
+	return loc
+}
+
+// In 2026 New York springs forward on Sunday 8 March and falls back on
+// Sunday 1 November; every case here crosses one of the two.
+func TestBetweenAcrossDST(t *testing.T) {
+	loc := newYork(t)
+	at := func(value string) time.Time {
+		parsed, err := time.ParseInLocation("2006-01-02 15:04", value, loc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	far := at("2030-01-01 00:00")
+
+	tests := []struct {
+		name    string
+		rule    string
+		dtstart time.Time
+		from    time.Time
+		exclude []time.Time
+		limit   int
+		want    []string
+	}{
+		{
+			name:    "COUNT keeps the wall-clock time into summer time",
+			rule:    "FREQ=DAILY;COUNT=5",
+			dtstart: at("2026-03-05 09:00"),
+			want: []string{
+				"2026-03-05 09:00 EST", "2026-03-06 09:00 EST", "2026-03-07 09:00 EST",
+				"2026-03-08 09:00 EDT", "2026-03-09 09:00 EDT",
+			},
+		},
+		{
+			name:    "weekly COUNT on the day the clocks change",
+			rule:    "FREQ=WEEKLY;BYDAY=SU;COUNT=3",
+			dtstart: at("2026-03-01 10:00"),
+			want:    []string{"2026-03-01 10:00 EST", "2026-03-08 10:00 EDT", "2026-03-15 10:00 EDT"},
+		},
+		{
+			name:    "UTC UNTIL is inclusive once the offset changes",
+			rule:    "FREQ=DAILY;UNTIL=20261102T140000Z",
+			dtstart: at("2026-10-30 09:00"),
+			want: []string{
+				"2026-10-30 09:00 EDT", "2026-10-31 09:00 EDT",
+				"2026-11-01 09:00 EST", "2026-11-02 09:00 EST",
+			},
+		},
+		{
+			name:    "UTC UNTIL a second earlier drops the last one",
+			rule:    "FREQ=DAILY;UNTIL=20261102T135959Z",
+			dtstart: at("2026-10-30 09:00"),
+			want:    []string{"2026-10-30 09:00 EDT", "2026-10-31 09:00 EDT", "2026-11-01 09:00 EST"},
+		},
+		{
+			name:    "floating UNTIL is read in the event's zone",
+			rule:    "FREQ=DAILY;UNTIL=20261101T090000",
+			dtstart: at("2026-10-30 09:00"),
+			want:    []string{"2026-10-30 09:00 EDT", "2026-10-31 09:00 EDT", "2026-11-01 09:00 EST"},
+		},
+		{
+			name:    "date-only UNTIL includes the whole day",
+			rule:    "FREQ=DAILY;UNTIL=20261101",
+			dtstart: at("2026-10-30 21:00"),
+			want:    []string{"2026-10-30 21:00 EDT", "2026-10-31 21:00 EDT", "2026-11-01 21:00 EST"},
+		},
+		{
+			name:    "EXDATE still counts towards COUNT",
+			rule:    "FREQ=WEEKLY;COUNT=4",
+			dtstart: at("2026-03-01 10:00"),
+			exclude: []time.Time{at("2026-03-08 10:00")},
+			want:    []string{"2026-03-01 10:00 EST", "2026-03-15 10:00 EDT", "2026-03-22 10:00 EDT"},
+		},
+		{
+			name:    "EXDATE given in UTC matches the same instant",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: at("2026-10-31 09:00"),
+			exclude: []time.Time{time.Date(2026, 11, 1, 14, 0, 0, 0, time.UTC)},
+			want:    []string{"2026-10-31 09:00 EDT", "2026-11-02 09:00 EST"},
+		},
+		{
+			name:    "EXDATE with the pre-change offset matches nothing",
+			rule:    "FREQ=DAILY;COUNT=3",
+			dtstart: at("2026-10-31 09:00"),
+			exclude: []time.Time{time.Date(2026, 11, 1, 13, 0, 0, 0, time.UTC)},
+			want:    []string{"2026-10-31 09:00 EDT", "2026-11-01 09:00 EST", "2026-11-02 09:00 EST"},
+		},
+		{
+			name:    "window starting mid-series still counts from the start",
+			rule:    "FREQ=DAILY;COUNT=5",
+			dtstart: at("2026-03-05 09:00"),
+			from:    at("2026-03-07 00:00"),
+			want:    []string{"2026-03-07 09:00 EST", "2026-03-08 09:00 EDT", "2026-03-09 09:00 EDT"},
+		},
+		{
+			name:    "limit",
+			rule:    "FREQ=DAILY",
+			dtstart: at("2026-03-07 09:00"),
+			limit:   2,
+			want:    []string{"2026-03-07 09:00 EST", "2026-03-08 09:00 EDT"},
+		},
+		{
+			name:    "monthly by ordinal weekday",
+			rule:    "FREQ=MONTHLY;BYDAY=1SU;COUNT=3",
+			dtstart: at("2026-10-04 09:00"),
+			want:    []string{"2026-10-04 09:00 EDT", "2026-11-01 09:00 EST", "2026-12-06 09:00 EST"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := Parse(tt.rule, loc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from := tt.from
+			if from.IsZero() {
+				from = tt.dtstart
+			}
+
+			got := []string{}
+			for _, occurrence := range rule.Between(tt.dtstart, from, far, tt.exclude, tt.limit) {
+				got = append(got, occurrence.Format(wallClock))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q\nwant %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEndBeforeAcrossDST(t *testing.T) {
+	loc := newYork(t)
+	dtstart := time.Date(2026, 10, 30, 9, 0, 0, 0, loc)
+
+	rule, err := Parse("FREQ=DAILY;COUNT=10", loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ended := rule.EndBefore(time.Date(2026, 11, 2, 9, 0, 0, 0, loc))
+
+	got := ended.Between(dtstart, dtstart, dtstart.AddDate(1, 0, 0), nil, 0)
+	if len(got) != 3 || got[2].Format(wallClock) != "2026-11-01 09:00 EST" {
+		t.Errorf("got %v, want three occurrences ending 1 November", got)
+	}
+	if ended.Count != 0 || rule.Count != 10 {
+		t.Errorf("EndBefore changed COUNT: original %d, ended %d", rule.Count, ended.Count)
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	tests := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;COUNT=0",
+		"FREQ=DAILY;COUNT=3;UNTIL=20260101",
+		"FREQ=DAILY;COUNT=3;COUNT=4",
+		"FREQ=WEEKLY;BYMONTHDAY=1",
+		"FREQ=WEEKLY;BYDAY=2TU",
+		"FREQ=MONTHLY;BYDAY=XX",
+		"FREQ=DAILY;BYSETPOS=1",
+		"FREQ=DAILY;UNTIL=tomorrow",
+	}
+
+	for _, value := range tests {
+		if _, err := Parse(value, time.UTC); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("Parse(%q): got %v, want ErrInvalidRule", value, err)
+		}
+	}
+}
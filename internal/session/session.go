@@ -0,0 +1,236 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	ErrNotFound    = errors.New("session not found or revoked")
+	ErrTokenReused = errors.New("refresh token reuse detected")
+)
+
+// Session is one signed-in device. Every refresh token issued for it belongs
+// to the same rotation family; only the newest one (RefreshJTI) is accepted.
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     int       `json:"userId"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastUsedAt time.Time `json:"lastUsedAt"`
+	RefreshJTI string    `json:"-"`
+}
+
+// Store keeps sessions in Redis: a hash per session plus a set of session ids per user
+type Store struct {
+	Redis *redis.Client
+	TTL   time.Duration
+}
+
+func sessionKey(id string) string {
+	return fmt.Sprintf("session:%s", id)
+}
+
+func userKey(userID int) string {
+	return fmt.Sprintf("sessions:user:%d", userID)
+}
+
+// rotateScript swaps the refresh jti only if the presented one is current;
+// anything else is a replayed token, so the whole session is dropped.
+var rotateScript = redis.NewScript(`
+local current = redis.call('HGET', KEYS[1], 'refresh_jti')
+if not current then
+	return -1
+end
+if current ~= ARGV[1] then
+	redis.call('DEL', KEYS[1])
+	redis.call('SREM', KEYS[2], ARGV[4])
+	return 0
+end
+redis.call('HSET', KEYS[1], 'refresh_jti', ARGV[2], 'last_used_at', ARGV[3])
+redis.call('EXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// Create starts a new session for a device
+func (s *Store) Create(ctx context.Context, sess *Session) error {
+	return s.CreateExpiring(ctx, sess, s.TTL)
+}
+
+// CreateExpiring starts a session that lasts ttl instead of the store's TTL,
+// for short-lived sessions such as impersonation that are never refreshed
+func (s *Store) CreateExpiring(ctx context.Context, sess *Session, ttl time.Duration) error {
+	now := time.Now().UTC()
+	sess.CreatedAt = now
+	sess.LastUsedAt = now
+
+	pipe := s.Redis.TxPipeline()
+	pipe.HSet(ctx, sessionKey(sess.ID), map[string]interface{}{
+		"user_id":      sess.UserID,
+		"device":       sess.Device,
+		"ip":           sess.IP,
+		"created_at":   now.Unix(),
+		"last_used_at": now.Unix(),
+		"refresh_jti":  sess.RefreshJTI,
+	})
+	pipe.Expire(ctx, sessionKey(sess.ID), ttl)
+	pipe.SAdd(ctx, userKey(sess.UserID), sess.ID)
+	// the index outlives every session in it
+	pipe.Expire(ctx, userKey(sess.UserID), max(ttl, s.TTL))
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Get loads a session, returning ErrNotFound once it expired or was revoked
+func (s *Store) Get(ctx context.Context, id string) (*Session, error) {
+	values, err := s.Redis.HGetAll(ctx, sessionKey(id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(values) == 0 {
+		return nil, ErrNotFound
+	}
+
+	userID, _ := strconv.Atoi(values["user_id"])
+	createdAt, _ := strconv.ParseInt(values["created_at"], 10, 64)
+	lastUsedAt, _ := strconv.ParseInt(values["last_used_at"], 10, 64)
+
+	return &Session{
+		ID:         id,
+		UserID:     userID,
+		Device:     values["device"],
+		IP:         values["ip"],
+		CreatedAt:  time.Unix(createdAt, 0).UTC(),
+		LastUsedAt: time.Unix(lastUsedAt, 0).UTC(),
+		RefreshJTI: values["refresh_jti"],
+	}, nil
+}
+
+// Rotate accepts the refresh token jti presented by the client and replaces it
+// with newJTI. Presenting any older jti revokes the session and returns ErrTokenReused.
+func (s *Store) Rotate(ctx context.Context, sess *Session, presentedJTI, newJTI, ip string) error {
+	now := time.Now().UTC()
+	result, err := rotateScript.Run(ctx, s.Redis,
+		[]string{sessionKey(sess.ID), userKey(sess.UserID)},
+		presentedJTI, newJTI, now.Unix(), sess.ID, int(s.TTL.Seconds()),
+	).Int()
+	if err != nil {
+		return err
+	}
+
+	switch result {
+	case -1:
+		return ErrNotFound
+	case 0:
+		return ErrTokenReused
+	}
+
+	if ip != "" {
+		s.Redis.HSet(ctx, sessionKey(sess.ID), "ip", ip)
+	}
+	s.Redis.Expire(ctx, userKey(sess.UserID), s.TTL)
+
+	sess.RefreshJTI = newJTI
+	sess.LastUsedAt = now
+	return nil
+}
+
+// List returns a user's live sessions, most recently used first
+func (s *Store) List(ctx context.Context, userID int) ([]*Session, error) {
+	ids, err := s.Redis.SMembers(ctx, userKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []*Session{}
+	for _, id := range ids {
+		sess, err := s.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			// expired on its own; tidy the index
+			s.Redis.SRem(ctx, userKey(userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, sess)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// Revoke ends one session
+func (s *Store) Revoke(ctx context.Context, userID int, id string) error {
+	pipe := s.Redis.TxPipeline()
+	pipe.Del(ctx, sessionKey(id))
+	pipe.SRem(ctx, userKey(userID), id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RevokeAll ends every session of a user, except the ids in keep
+func (s *Store) RevokeAll(ctx context.Context, userID int, keep ...string) error {
+	ids, err := s.Redis.SMembers(ctx, userKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	kept := map[string]bool{}
+	for _, id := range keep {
+		kept[id] = true
+	}
+
+	pipe := s.Redis.TxPipeline()
+	for _, id := range ids {
+		if kept[id] {
+			continue
+		}
+		pipe.Del(ctx, sessionKey(id))
+		pipe.SRem(ctx, userKey(userID), id)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func denylistKey(jti string) string {
+	return fmt.Sprintf("denylist:access:%s", jti)
+}
+
+// DenyAccessToken blocks an access token until it would have expired anyway
+func (s *Store) DenyAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ttl := time.Until(expiresAt)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.Redis.Set(ctx, denylistKey(jti), 1, ttl).Err()
+}
+
+// AccessTokenRevoked reports whether an access token was denylisted or the
+// session it was issued for has since been revoked
+func (s *Store) AccessTokenRevoked(ctx context.Context, jti, sessionID string) (bool, error) {
+	pipe := s.Redis.Pipeline()
+	denied := pipe.Exists(ctx, denylistKey(jti))
+	var live *redis.IntCmd
+	if sessionID != "" {
+		live = pipe.Exists(ctx, sessionKey(sessionID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return false, err
+	}
+
+	if denied.Val() > 0 {
+		return true, nil
+	}
+	return live != nil && live.Val() == 0, nil
+}
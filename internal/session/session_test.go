@@ -0,0 +1,219 @@
+package session
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newStore(t *testing.T) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	return &Store{Redis: redis.NewClient(&redis.Options{Addr: mr.Addr()}), TTL: time.Hour}, mr
+}
+
+type rotation struct {
+	presented string
+	next      string
+	wantErr   error
+}
+
+func TestRotate(t *testing.T) {
+	tests := []struct {
+		name      string
+		rotations []rotation
+		wantAlive bool
+		wantJTI   string
+	}{
+		{
+			name:      "each new token in turn",
+			rotations: []rotation{{"r1", "r2", nil}, {"r2", "r3", nil}, {"r3", "r4", nil}},
+			wantAlive: true,
+			wantJTI:   "r4",
+		},
+		{
+			name:      "replayed first token revokes the family",
+			rotations: []rotation{{"r1", "r2", nil}, {"r1", "x", ErrTokenReused}},
+		},
+		{
+			name: "rightful holder is signed out after a replay",
+			rotations: []rotation{
+				{"r1", "r2", nil},
+				{"r2", "r3", nil},
+				{"r2", "x", ErrTokenReused},
+				{"r3", "r4", ErrNotFound},
+			},
+		},
+		{
+			name:      "two refreshes racing with the same token",
+			rotations: []rotation{{"r1", "a", nil}, {"r1", "b", ErrTokenReused}, {"a", "c", ErrNotFound}},
+		},
+		{
+			name:      "token never issued",
+			rotations: []rotation{{"forged", "x", ErrTokenReused}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, _ := newStore(t)
+			ctx := context.Background()
+
+			sess := &Session{ID: "s1", UserID: 7, Device: "phone", RefreshJTI: "r1"}
+			other := &Session{ID: "s2", UserID: 7, Device: "laptop", RefreshJTI: "o1"}
+			for _, s := range []*Session{sess, other} {
+				if err := store.Create(ctx, s); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i, r := range tt.rotations {
+				err := store.Rotate(ctx, sess, r.presented, r.next, "")
+				if !errors.Is(err, r.wantErr) {
+					t.Fatalf("rotation %d (%s): got %v, want %v", i+1, r.presented, err, r.wantErr)
+				}
+			}
+
+			got, err := store.Get(ctx, sess.ID)
+			if tt.wantAlive {
+				if err != nil {
+					t.Fatalf("session gone: %v", err)
+				}
+				if got.RefreshJTI != tt.wantJTI {
+					t.Errorf("refresh jti %q, want %q", got.RefreshJTI, tt.wantJTI)
+				}
+			} else if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("session still there after reuse: %v", err)
+			}
+
+			// access tokens of a revoked session stop working with it
+			revoked, err := store.AccessTokenRevoked(ctx, "access-jti", sess.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if revoked == tt.wantAlive {
+				t.Errorf("access token revoked = %v, want %v", revoked, !tt.wantAlive)
+			}
+
+			// the user's other devices are a different family and stay signed in
+			sessions, err := store.List(ctx, 7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 1
+			if tt.wantAlive {
+				want = 2
+			}
+			if len(sessions) != want {
+				t.Errorf("%d sessions listed, want %d", len(sessions), want)
+			}
+			if _, err := store.Get(ctx, other.ID); err != nil {
+				t.Errorf("other session: %v", err)
+			}
+		})
+	}
+}
+
+func TestRotateExtendsSession(t *testing.T) {
+	store, mr := newStore(t)
+	ctx := context.Background()
+
+	sess := &Session{ID: "s1", UserID: 7, RefreshJTI: "r1"}
+	if err := store.Create(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+
+	mr.FastForward(50 * time.Minute)
+	if err := store.Rotate(ctx, sess, "r1", "r2", "10.0.0.9"); err != nil {
+		t.Fatal(err)
+	}
+	mr.FastForward(50 * time.Minute)
+
+	got, err := store.Get(ctx, sess.ID)
+	if err != nil {
+		t.Fatalf("session expired despite being used: %v", err)
+	}
+	if got.IP != "10.0.0.9" {
+		t.Errorf("ip %q, want the one the refresh came from", got.IP)
+	}
+
+	mr.FastForward(time.Hour)
+	if _, err := store.Get(ctx, sess.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("idle session still there: %v", err)
+	}
+	if err := store.Rotate(ctx, sess, "r2", "r3", ""); !errors.Is(err, ErrNotFound) {
+		t.Errorf("rotating an expired session: got %v, want ErrNotFound", err)
+	}
+}
+
+func TestRevokeAllKeeps(t *testing.T) {
+	store, _ := newStore(t)
+	ctx := context.Background()
+
+	for _, id := range []string{"s1", "s2", "s3"} {
+		if err := store.Create(ctx, &Session{ID: id, UserID: 7, RefreshJTI: id + "-r"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.Create(ctx, &Session{ID: "theirs", UserID: 8, RefreshJTI: "t-r"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.RevokeAll(ctx, 7, "s2"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id        string
+		wantAlive bool
+	}{
+		{"s1", false},
+		{"s2", true},
+		{"s3", false},
+		{"theirs", true},
+	}
+	for _, tt := range tests {
+		_, err := store.Get(ctx, tt.id)
+		if alive := err == nil; alive != tt.wantAlive {
+			t.Errorf("%s: alive = %v, want %v (%v)", tt.id, alive, tt.wantAlive, err)
+		}
+	}
+}
+
+func TestDenyAccessToken(t *testing.T) {
+	store, mr := newStore(t)
+	ctx := context.Background()
+
+	if err := store.DenyAccessToken(ctx, "expired", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.DenyAccessToken(ctx, "live", time.Now().Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		jti   string
+		after time.Duration
+		want  bool
+	}{
+		{"expired", 0, false},
+		{"live", 0, true},
+		{"live", 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		mr.FastForward(tt.after)
+		// no session id: a token from before sessions were tracked
+		revoked, err := store.AccessTokenRevoked(ctx, tt.jti, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked != tt.want {
+			t.Errorf("%s after %s: revoked = %v, want %v", tt.jti, tt.after, revoked, tt.want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// the SHA-1 key from RFC 6238 appendix B, "12345678901234567890", in base32
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238 appendix B, cut to the last six of its eight digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("%d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("%d: got %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidateClockSkew(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := Step(now)
+
+	codeAt := func(offset int64) string {
+		code, err := Code(rfcSecret, current+offset)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		code     string
+		skew     int
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", rfcSecret, codeAt(0), 1, current, true},
+		{"phone one step behind", rfcSecret, codeAt(-1), 1, current - 1, true},
+		{"phone one step ahead", rfcSecret, codeAt(1), 1, current + 1, true},
+		{"two steps behind is too far", rfcSecret, codeAt(-2), 1, 0, false},
+		{"two steps ahead is too far", rfcSecret, codeAt(2), 1, 0, false},
+		{"no skew allowed", rfcSecret, codeAt(-1), 0, 0, false},
+		{"wider skew", rfcSecret, codeAt(-2), 2, current - 2, true},
+		{"surrounding spaces", rfcSecret, " " + codeAt(0) + " ", 1, current, true},
+		{"lower-case secret", "gezdgnbvgy3tqojqgezdgnbvgy3tqojq", codeAt(0), 1, current, true},
+		{"too short", rfcSecret, codeAt(0)[:5], 1, 0, false},
+		{"too long", rfcSecret, codeAt(0) + "0", 1, 0, false},
+		{"invalid secret", "not base32!", codeAt(0), 1, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.code, now, tt.skew)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("got (%d, %v), want (%d, %v)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateAtStepBoundary(t *testing.T) {
+	// the last second of a step and the first of the next are one step apart,
+	// so a code read just before the boundary still works just after it
+	boundary := time.Unix(Step(time.Unix(1234567890, 0))*int64(Period.Seconds()), 0)
+	code, err := Code(rfcSecret, Step(boundary.Add(-time.Second)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := Validate(rfcSecret, code, boundary, 1); !ok {
+		t.Error("code from the previous step rejected right after the boundary")
+	}
+	if _, ok := Validate(rfcSecret, code, boundary.Add(Period), 1); ok {
+		t.Error("code accepted two steps later")
+	}
+}